@@ -14,6 +14,7 @@ import (
 	"github.com/gdp-org/gd/net/dogrpc"
 	"github.com/gdp-org/gd/runtime/helper"
 	"github.com/gdp-org/gd/runtime/inject"
+	"github.com/gdp-org/gd/runtime/limiter"
 	"github.com/gdp-org/gd/runtime/pc"
 	"github.com/gdp-org/gd/runtime/stat"
 	"github.com/gdp-org/gd/utls"
@@ -148,7 +149,31 @@ func (e *Engine) Run() error {
 			Info("http server try listen addr:%d", httpAddr)
 			inject.RegisterOrFail("httpServerRunAddr", httpAddr)
 		}
+		if openApi := Config("Server", "httpOpenApi").String(); openApi != "" {
+			Info("http server try serve openapi at:%s", openApi)
+			inject.RegisterOrFail("httpServerOpenApiPath", openApi)
+			inject.RegisterOrFail("httpServerOpenApiTitle", Config("Server", "serverName").String())
+		}
+		if Config("Server", "httpH2c").MustBool(false) {
+			Info("http server try serve h2c")
+			inject.RegisterOrFail("httpServerH2c", true)
+		}
+		certFile := Config("Server", "httpsCertFile").String()
+		keyFile := Config("Server", "httpsKeyFile").String()
+		if certFile != "" && keyFile != "" {
+			Info("http server try serve https with cert:%s", certFile)
+			inject.RegisterOrFail("httpServerUseHttps", true)
+			inject.RegisterOrFail("httpServerHttpsCertFile", certFile)
+			inject.RegisterOrFail("httpServerHttpsKeyFile", keyFile)
+		}
+		if http3Port := Config("Server", "httpHttp3Port").MustInt(0); http3Port > 0 {
+			Info("http server try listen http3 port:%d", http3Port)
+			inject.RegisterOrFail("httpServerHttp3Port", http3Port)
+		}
 		inject.RegisterOrFail("httpServer", e.HttpServer)
+		registerLimiter("HttpLimit", "httpLimiter")
+		registerHttpFilters()
+		registerHttpAuth()
 
 		if falconEnable {
 			pc.SetRunPort(httpPort)
@@ -162,6 +187,7 @@ func (e *Engine) Run() error {
 		inject.RegisterOrFail("grpcRunHost", grpcPort)
 		inject.RegisterOrFail("serviceName", Config("Server", "serverName").String())
 		inject.RegisterOrFail("grpcServer", e.GrpcServer)
+		registerLimiter("GrpcLimit", "grpcLimiter")
 
 		if falconEnable {
 			pc.SetRunPort(grpcPort)
@@ -178,17 +204,96 @@ func (e *Engine) Run() error {
 
 	// rpc server
 	rpcPort := Config("Server", "rpcPort").MustInt()
-	if rpcPort > 0 {
-		Info("rpc server try listen port:%d", rpcPort)
+	rpcNetwork := Config("Server", "rpcNetwork").String()
+	rpcSockAddr := Config("Server", "rpcSockAddr").String()
+	rpcSock := rpcSockAddr != "" && rpcNetwork != "" && rpcNetwork != dogrpc.NetworkTcp
+	if rpcPort > 0 || rpcSock {
+		if rpcSock {
+			Info("rpc server try listen %s:%s", rpcNetwork, rpcSockAddr)
+			inject.RegisterOrFail("rpcNetwork", rpcNetwork)
+			inject.RegisterOrFail("rpcSockAddr", rpcSockAddr)
+		} else {
+			Info("rpc server try listen port:%d", rpcPort)
+		}
 
 		inject.RegisterOrFail("rpcHost", rpcPort)
+		if handlerTimeout := Config("Server", "rpcHandlerTimeout").MustInt64(0); handlerTimeout > 0 {
+			inject.RegisterOrFail("rpcHandlerTimeout", time.Duration(handlerTimeout)*time.Millisecond)
+		}
+		if shutdownTimeout := Config("Server", "rpcShutdownTimeout").MustInt64(0); shutdownTimeout > 0 {
+			inject.RegisterOrFail("rpcShutdownTimeout", shutdownTimeout)
+		}
 		inject.RegisterOrFail("rpcServer", e.RpcServer)
+		registerLimiter("RpcLimit", "rpcLimiter")
+		registerRpcAuth()
 	}
 
 	Close()
 	return nil
 }
 
+// registerLimiter registers limiter with rules of section in conf.ini, if there is any.
+func registerLimiter(section, name string) {
+	sec := GetConfFile().Section(section)
+	if len(sec.Keys()) == 0 {
+		return
+	}
+
+	l, err := limiter.NewFromSection(name, sec)
+	if err != nil {
+		Crashf("conf section %s illegal, error:%s", section, err)
+	}
+
+	Info("%s try limit %d keys", name, len(sec.Keys()))
+	inject.RegisterOrFail(name, l)
+}
+
+// registerHttpFilters registers built-in filters of http server enabled in Server section of conf.ini, if there is any.
+func registerHttpFilters() {
+	f, err := dhttp.NewFilterConfFromSection(GetConfFile().Section("Server"))
+	if err != nil {
+		Crashf("conf section Server illegal, error:%s", err)
+	}
+	if f == nil {
+		return
+	}
+
+	Info("http server try use %d built-in filters", len(f.Handlers()))
+	inject.RegisterOrFail("httpServerFilters", f)
+}
+
+// registerHttpAuth registers jwt auth of http server with HttpAuth section of conf.ini, if there is any.
+func registerHttpAuth() {
+	sec := GetConfFile().Section("HttpAuth")
+	if len(sec.Keys()) == 0 {
+		return
+	}
+
+	a, err := dhttp.NewJwtAuthFromSection(sec)
+	if err != nil {
+		Crashf("conf section HttpAuth illegal, error:%s", err)
+	}
+
+	Info("http server try auth requests by jwt")
+	inject.RegisterOrFail("httpJwtAuth", a)
+}
+
+// registerRpcAuth registers auth of rpc server with credentials in RpcAuth section of conf.ini, if there is any.
+func registerRpcAuth() {
+	sec := GetConfFile().Section("RpcAuth")
+	if len(sec.Keys()) == 0 {
+		return
+	}
+
+	a, err := dogrpc.NewAuthFromSection(sec)
+	if err != nil {
+		Crashf("conf section RpcAuth illegal, error:%s", err)
+	}
+
+	Info("rpc server try auth requests")
+	inject.RegisterOrFail("rpcAuth", a)
+}
+
 func (e *Engine) initCPUAndMemory() error {
 	maxCPU := Config("Process", "maxCPU").MustInt()
 	numCpus := runtime.NumCPU()
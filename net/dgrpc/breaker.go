@@ -0,0 +1,167 @@
+/**
+ * Copyright 2020 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package dgrpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gdp-org/gd/runtime/breaker"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/serviceconfig"
+	"google.golang.org/grpc/status"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ * circuit breaker of grpc backends. GrpcClient with Breaker dials by BreakerBalancerName, which is
+ * round robin over ready backends whose breakers are not open, breakers are keyed by backend
+ * address like balancer of dogrpc. so a failing backend is skipped and calls go to the others,
+ * calls fail with codes.Unavailable only if breakers of all backends are open.
+ *
+ * the breaker group is passed to balancer by loadBalancingConfig of service config, see
+ * BreakerServiceConfig.
+ */
+
+// BreakerBalancerName is the name of round robin balancer with circuit breakers.
+const BreakerBalancerName = "gd_breaker_round_robin"
+
+// BreakerFailCodes are the codes counted as failure of backend, others are answers of server.
+var BreakerFailCodes = []codes.Code{codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal, codes.Unknown}
+
+// breakerGroups are breaker groups used by service config, which are referred by id.
+var breakerGroups sync.Map
+
+func init() {
+	balancer.Register(breakerBuilder{})
+}
+
+func breakerFailed(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	c := status.Code(err)
+	for _, v := range BreakerFailCodes {
+		if c == v {
+			return true
+		}
+	}
+	return false
+}
+
+// BreakerServiceConfig returns service config which balances calls by BreakerBalancerName with breakers of g.
+func BreakerServiceConfig(g *breaker.Group) string {
+	id := fmt.Sprintf("%p", g)
+	breakerGroups.Store(id, g)
+	return fmt.Sprintf(`{"loadBalancingConfig": [{"%s": {"group": "%s"}}]}`, BreakerBalancerName, id)
+}
+
+type breakerConfig struct {
+	serviceconfig.LoadBalancingConfig
+	Group string `json:"group"`
+}
+
+type breakerBuilder struct{}
+
+func (breakerBuilder) Name() string {
+	return BreakerBalancerName
+}
+
+func (breakerBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pb := &breakerPickerBuilder{}
+	return &breakerBalancer{
+		Balancer: base.NewBalancerBuilder(BreakerBalancerName, pb, base.Config{HealthCheck: true}).Build(cc, opts),
+		pb:       pb,
+	}
+}
+
+func (breakerBuilder) ParseConfig(c json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	cfg := &breakerConfig{}
+	if err := json.Unmarshal(c, cfg); err != nil {
+		return nil, fmt.Errorf("%s config %s illegal: %v", BreakerBalancerName, c, err)
+	}
+	if _, ok := breakerGroups.Load(cfg.Group); !ok {
+		return nil, fmt.Errorf("%s breaker group %s unknown", BreakerBalancerName, cfg.Group)
+	}
+	return cfg, nil
+}
+
+// breakerBalancer is the base balancer, which sets breaker group of config to picker builder.
+type breakerBalancer struct {
+	balancer.Balancer
+	pb *breakerPickerBuilder
+}
+
+func (b *breakerBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	if cfg, ok := s.BalancerConfig.(*breakerConfig); ok {
+		if g, ok := breakerGroups.Load(cfg.Group); ok {
+			b.pb.setGroup(g.(*breaker.Group))
+		}
+	}
+	return b.Balancer.UpdateClientConnState(s)
+}
+
+type breakerPickerBuilder struct {
+	lock sync.Mutex
+	g    *breaker.Group
+}
+
+func (pb *breakerPickerBuilder) setGroup(g *breaker.Group) {
+	pb.lock.Lock()
+	pb.g = g
+	pb.lock.Unlock()
+}
+
+func (pb *breakerPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	pb.lock.Lock()
+	p := &breakerPicker{g: pb.g}
+	pb.lock.Unlock()
+	for sc, sci := range info.ReadySCs {
+		p.scs = append(p.scs, sc)
+		p.addrs = append(p.addrs, sci.Address.Addr)
+	}
+	return p
+}
+
+type breakerPicker struct {
+	g     *breaker.Group
+	scs   []balancer.SubConn
+	addrs []string
+	next  uint32
+}
+
+// Pick picks ready backends in turn, and skips those whose breakers are open.
+func (p *breakerPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	n := uint32(len(p.scs))
+	start := atomic.AddUint32(&p.next, 1)
+	for i := uint32(0); i < n; i++ {
+		k := (start + i) % n
+		if p.g == nil {
+			return balancer.PickResult{SubConn: p.scs[k]}, nil
+		}
+
+		b := p.g.Get(p.addrs[k])
+		if !b.Allow() {
+			continue
+		}
+		st := time.Now()
+		return balancer.PickResult{
+			SubConn: p.scs[k],
+			Done: func(di balancer.DoneInfo) {
+				b.Done(!breakerFailed(di.Err), time.Now().Sub(st))
+			},
+		}, nil
+	}
+	return balancer.PickResult{}, status.Errorf(codes.Unavailable, "circuit breakers of all %d backends are open", n)
+}
@@ -10,6 +10,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"github.com/gdp-org/gd/runtime/breaker"
 	grpcMiddleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpcRetry "github.com/grpc-ecosystem/go-grpc-middleware/retry"
 	"google.golang.org/grpc"
@@ -35,6 +36,7 @@ type GrpcClient struct {
 	GrpcCaPemFile      string
 	GrpcClientKeyFile  string
 	GrpcClientPemFile  string
+	Breaker            *breaker.Group // skip backends of target which fail too much, nil disables it
 	startOnce          sync.Once
 	stopOnce           sync.Once
 	connect            *grpc.ClientConn
@@ -102,6 +104,11 @@ func (c *GrpcClient) DefaultClient() (*grpc.ClientConn, error) {
 
 	options := GetOptionHolder(ops...)
 
+	serviceConfig := fmt.Sprintf(`{"LoadBalancingPolicy": "%s"}`, roundrobin.Name)
+	if c.Breaker != nil {
+		serviceConfig = BreakerServiceConfig(c.Breaker)
+	}
+
 	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -132,7 +139,7 @@ func (c *GrpcClient) DefaultClient() (*grpc.ClientConn, error) {
 		cc, err = grpc.Dial(
 			c.Target,
 			grpc.WithTransportCredentials(cTls),
-			grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"LoadBalancingPolicy": "%s"}`, roundrobin.Name)),
+			grpc.WithDefaultServiceConfig(serviceConfig),
 			grpc.WithUnaryInterceptor(grpcMiddleware.ChainUnaryClient(
 				options.UnaryClientInterceptors...,
 			)),
@@ -144,7 +151,7 @@ func (c *GrpcClient) DefaultClient() (*grpc.ClientConn, error) {
 		cc, err = grpc.Dial(
 			c.Target,
 			grpc.WithInsecure(),
-			grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"LoadBalancingPolicy": "%s"}`, roundrobin.Name)),
+			grpc.WithDefaultServiceConfig(serviceConfig),
 			grpc.WithUnaryInterceptor(grpcMiddleware.ChainUnaryClient(
 				options.UnaryClientInterceptors...,
 			)),
@@ -0,0 +1,44 @@
+/**
+ * Copyright 2020 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package dgrpc
+
+import (
+	"context"
+	"github.com/gdp-org/gd/runtime/limiter"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// WithLimiterInterceptor rejects calls with codes.ResourceExhausted when limiter of full method is exceeded.
+func WithLimiterInterceptor(l *limiter.Limiter) InterceptorOption {
+	return func(h *OptionHolder) {
+		h.UnaryServerInterceptors = append(h.UnaryServerInterceptors, UnaryServerLimiterInterceptor(l))
+		h.StreamServerInterceptors = append(h.StreamServerInterceptors, StreamServerLimiterInterceptor(l))
+	}
+}
+
+func UnaryServerLimiterInterceptor(l *limiter.Limiter) func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		release, ok := l.Acquire(info.FullMethod)
+		if !ok {
+			return nil, status.Errorf(codes.ResourceExhausted, "%s is limited", info.FullMethod)
+		}
+		defer release()
+		return handler(ctx, req)
+	}
+}
+
+func StreamServerLimiterInterceptor(l *limiter.Limiter) func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		release, ok := l.Acquire(info.FullMethod)
+		if !ok {
+			return status.Errorf(codes.ResourceExhausted, "%s is limited", info.FullMethod)
+		}
+		defer release()
+		return handler(srv, ss)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"errors"
 	"fmt"
 	log "github.com/gdp-org/gd/dlog"
+	"github.com/gdp-org/gd/runtime/limiter"
 	grpcMiddleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
@@ -40,6 +41,7 @@ type GrpcServer struct {
 	GrpcRunHost     int              `inject:"grpcRunHost"`
 	RegisterHandler IRegisterHandler `inject:"registerHandler"`
 	ServiceName     string           `inject:"serviceName"`
+	Limiter         *limiter.Limiter `inject:"grpcLimiter" canNil:"true"`
 
 	UseTls            bool   `inject:"grpcUseTls" canNil:"true"`
 	GrpcCaPemFile     string `inject:"grpcCaPemFile" canNil:"true"`
@@ -103,6 +105,10 @@ func (s *GrpcServer) DefaultServer() (*grpc.Server, error) {
 		WithRecoveryInterceptor(nil),
 	}
 
+	if s.Limiter != nil {
+		ops = append(ops, WithLimiterInterceptor(s.Limiter))
+	}
+
 	options := GetOptionHolder(ops...)
 
 	if s.UseTls {
@@ -0,0 +1,438 @@
+/**
+ * Copyright 2019 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package dhttp
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"github.com/gdp-org/gd/dlog"
+	"github.com/gdp-org/gd/runtime/pc"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * response cache of GET handlers. CacheFilter should be used after GroupFilter, so that ret of
+ * handlers is cached before it is rendered, e.g.
+ *
+ * cache := &dhttp.ResponseCache{Store: dhttp.NewLruCacheStore(10000), TTL: time.Minute}
+ * r := g.Group("/api", dhttp.GroupFilter(), cache.Filter())
+ *
+ * key is KeyPrefix + path + sorted query, KeyFunc may add more, e.g. user of request. only ret with
+ * http status 200, no err and not render.Render is cached.
+ *
+ * Cache-Control of request: no-cache skips lookup, no-store skips lookup and store.
+ * Cache-Control of response: no-store or private skips store, s-maxage or max-age overrides TTL.
+ *
+ * concurrent misses of the same key are coalesced, only one of them calls handler and the others
+ * take its ret. hits and misses are counted by pc with key dhttp_cache,route=<route>,result=<hit|miss|coalesced>.
+ */
+
+const (
+	CacheHeader    = "X-Cache"
+	cacheHit       = "hit"
+	cacheMiss      = "miss"
+	cacheCoalesced = "coalesced"
+)
+
+// CacheStore stores rendered ret of handlers.
+type CacheStore interface {
+	// Get returns value of key, false if it does not exist or is expired.
+	Get(key string) ([]byte, bool, error)
+	Set(key string, value []byte, ttl time.Duration) error
+	// SetNX sets value of key only if it does not exist, it returns false if key exists.
+	SetNX(key string, value []byte, ttl time.Duration) (bool, error)
+	Del(key string) error
+}
+
+type ResponseCache struct {
+	Store     CacheStore
+	TTL       time.Duration
+	KeyPrefix string
+	// KeyFunc returns extra part of key, e.g. user of request
+	KeyFunc func(c *gin.Context) string
+
+	callLock sync.Mutex
+	calls    map[string]*cacheCall
+}
+
+// cacheCall is the handler call of a key which concurrent misses wait for.
+type cacheCall struct {
+	done  chan struct{}
+	value []byte
+}
+
+// Filter caches ret of handlers after it.
+func (rc *ResponseCache) Filter() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet {
+			c.Next()
+			return
+		}
+
+		noCache, noStore := cacheControl(c.Request.Header.Get("Cache-Control"))
+		if noStore {
+			c.Next()
+			return
+		}
+
+		key := rc.key(c)
+		route := c.FullPath()
+		if !noCache {
+			if value, ok := rc.get(key); ok {
+				rc.serve(c, route, value, cacheHit)
+				return
+			}
+		}
+
+		call, leader := rc.join(key)
+		if !leader {
+			<-call.done
+			if call.value != nil {
+				rc.serve(c, route, call.value, cacheCoalesced)
+				return
+			}
+			// ret of leader is not cacheable, so handler is called again
+			c.Next()
+			return
+		}
+
+		pc.Incr(fmt.Sprintf("dhttp_cache,route=%s,result=%s", route, cacheMiss), 1)
+		c.Header(CacheHeader, cacheMiss)
+		defer rc.leave(key, call)
+		c.Next()
+
+		value, ttl, ok := rc.cacheable(c)
+		if !ok {
+			return
+		}
+		call.value = value
+		if err := rc.Store.Set(key, value, ttl); err != nil {
+			dlog.Warn("response cache set %s occur error:%v", key, err)
+		}
+	}
+}
+
+// Purge deletes cached ret of path and query, whose extra part of key is extra.
+func (rc *ResponseCache) Purge(path, rawQuery, extra string) error {
+	return rc.Store.Del(rc.KeyPrefix + path + "?" + normalizeQuery(rawQuery) + "#" + extra)
+}
+
+func (rc *ResponseCache) key(c *gin.Context) string {
+	var extra string
+	if rc.KeyFunc != nil {
+		extra = rc.KeyFunc(c)
+	}
+	return rc.KeyPrefix + c.Request.URL.Path + "?" + normalizeQuery(c.Request.URL.RawQuery) + "#" + extra
+}
+
+func (rc *ResponseCache) get(key string) ([]byte, bool) {
+	value, ok, err := rc.Store.Get(key)
+	if err != nil {
+		dlog.Warn("response cache get %s occur error:%v", key, err)
+		return nil, false
+	}
+	return value, ok
+}
+
+func (rc *ResponseCache) serve(c *gin.Context, route string, value []byte, result string) {
+	pc.Incr(fmt.Sprintf("dhttp_cache,route=%s,result=%s", route, result), 1)
+	c.Header(CacheHeader, result)
+	c.Set(Ret, json.RawMessage(value))
+	c.Set(Code, http.StatusOK)
+	c.Abort()
+}
+
+// join returns call of key, and whether the caller is the leader which calls handler.
+func (rc *ResponseCache) join(key string) (*cacheCall, bool) {
+	rc.callLock.Lock()
+	defer rc.callLock.Unlock()
+	if rc.calls == nil {
+		rc.calls = make(map[string]*cacheCall)
+	}
+	if call, ok := rc.calls[key]; ok {
+		return call, false
+	}
+	call := &cacheCall{done: make(chan struct{})}
+	rc.calls[key] = call
+	return call, true
+}
+
+func (rc *ResponseCache) leave(key string, call *cacheCall) {
+	rc.callLock.Lock()
+	delete(rc.calls, key)
+	rc.callLock.Unlock()
+	close(call.done)
+}
+
+// cacheable returns rendered ret and its ttl, false if it should not be cached.
+func (rc *ResponseCache) cacheable(c *gin.Context) ([]byte, time.Duration, bool) {
+	if c.GetBool(Stream) || c.GetInt(Code) != http.StatusOK {
+		return nil, 0, false
+	}
+	if err, ok := c.Get(Err); ok && err != nil {
+		return nil, 0, false
+	}
+	ret, ok := c.Get(Ret)
+	if !ok || ret == nil {
+		return nil, 0, false
+	}
+	if _, ok := ret.(render.Render); ok {
+		return nil, 0, false
+	}
+
+	ttl, ok := responseTtl(c.Writer.Header().Get("Cache-Control"), rc.TTL)
+	if !ok || ttl <= 0 {
+		return nil, 0, false
+	}
+
+	value, err := json.Marshal(ret)
+	if err != nil {
+		dlog.Warn("response cache marshal ret of %s occur error:%v", c.Request.URL.Path, err)
+		return nil, 0, false
+	}
+	return value, ttl, true
+}
+
+// cacheControl returns whether no-cache or no-store is in Cache-Control of request.
+func cacheControl(header string) (noCache, noStore bool) {
+	for _, d := range strings.Split(header, ",") {
+		switch strings.ToLower(strings.TrimSpace(d)) {
+		case "no-cache":
+			noCache = true
+		case "no-store":
+			noStore = true
+		}
+	}
+	return
+}
+
+// responseTtl returns ttl by Cache-Control of response, false if it must not be stored.
+func responseTtl(header string, ttl time.Duration) (time.Duration, bool) {
+	var maxAge, sMaxAge = -1, -1
+	for _, d := range strings.Split(header, ",") {
+		d = strings.ToLower(strings.TrimSpace(d))
+		switch {
+		case d == "no-store" || d == "private" || d == "no-cache":
+			return 0, false
+		case strings.HasPrefix(d, "s-maxage="):
+			if n, err := strconv.Atoi(strings.TrimPrefix(d, "s-maxage=")); err == nil {
+				sMaxAge = n
+			}
+		case strings.HasPrefix(d, "max-age="):
+			if n, err := strconv.Atoi(strings.TrimPrefix(d, "max-age=")); err == nil {
+				maxAge = n
+			}
+		}
+	}
+
+	if sMaxAge >= 0 {
+		return time.Duration(sMaxAge) * time.Second, true
+	}
+	if maxAge >= 0 {
+		return time.Duration(maxAge) * time.Second, true
+	}
+	return ttl, true
+}
+
+// normalizeQuery sorts query by key, so that the same query in different orders has the same key.
+func normalizeQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return rawQuery
+	}
+	return values.Encode()
+}
+
+// LruCacheStore is in-process CacheStore, least recently used entries are evicted when it is full.
+type LruCacheStore struct {
+	lock    sync.Mutex
+	size    int
+	ll      *list.List
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	key    string
+	value  []byte
+	expire time.Time
+}
+
+func NewLruCacheStore(size int) *LruCacheStore {
+	return &LruCacheStore{
+		size:    size,
+		ll:      list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (s *LruCacheStore) Get(key string) ([]byte, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := e.Value.(*lruEntry)
+	if time.Now().After(entry.expire) {
+		s.remove(e)
+		return nil, false, nil
+	}
+	s.ll.MoveToFront(e)
+	return entry.value, true, nil
+}
+
+func (s *LruCacheStore) Set(key string, value []byte, ttl time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.set(key, value, ttl)
+	return nil
+}
+
+// set stores value of key, s.lock must be held.
+func (s *LruCacheStore) set(key string, value []byte, ttl time.Duration) {
+	expire := time.Now().Add(ttl)
+	if e, ok := s.entries[key]; ok {
+		entry := e.Value.(*lruEntry)
+		entry.value, entry.expire = value, expire
+		s.ll.MoveToFront(e)
+		return
+	}
+
+	s.entries[key] = s.ll.PushFront(&lruEntry{key: key, value: value, expire: expire})
+	for s.size > 0 && s.ll.Len() > s.size {
+		s.remove(s.ll.Back())
+	}
+}
+
+func (s *LruCacheStore) SetNX(key string, value []byte, ttl time.Duration) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if e, ok := s.entries[key]; ok && time.Now().Before(e.Value.(*lruEntry).expire) {
+		return false, nil
+	}
+	s.set(key, value, ttl)
+	return true, nil
+}
+
+func (s *LruCacheStore) Del(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if e, ok := s.entries[key]; ok {
+		s.remove(e)
+	}
+	return nil
+}
+
+func (s *LruCacheStore) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.ll.Len()
+}
+
+func (s *LruCacheStore) remove(e *list.Element) {
+	s.ll.Remove(e)
+	delete(s.entries, e.Value.(*lruEntry).key)
+}
+
+// RedisPoolCmd is implemented by redisdb.RedisPoolClient.
+type RedisPoolCmd interface {
+	MGet(keys []string) ([]interface{}, error)
+	SetEx(key string, expire int64, value string) error
+	SetNX(key, value string, expire int) (interface{}, error)
+	Del(key string) error
+}
+
+// RedisClusterCmd is implemented by redisdb.RedisClusterClient.
+type RedisClusterCmd interface {
+	MGet(keys []string) ([]interface{}, error)
+	Set(key string, value string, expire time.Duration) (string, error)
+	SetNX(key string, value string, expire time.Duration) (bool, error)
+	Del(key string) (int64, error)
+}
+
+// NewRedisPoolCacheStore returns CacheStore of redis pool, ttl is rounded up to seconds.
+func NewRedisPoolCacheStore(p RedisPoolCmd) CacheStore {
+	return &redisPoolCacheStore{p: p}
+}
+
+// NewRedisClusterCacheStore returns CacheStore of redis cluster.
+func NewRedisClusterCacheStore(c RedisClusterCmd) CacheStore {
+	return &redisClusterCacheStore{c: c}
+}
+
+type redisPoolCacheStore struct {
+	p RedisPoolCmd
+}
+
+func (s *redisPoolCacheStore) Get(key string) ([]byte, bool, error) {
+	return redisValue(s.p.MGet([]string{key}))
+}
+
+func (s *redisPoolCacheStore) Set(key string, value []byte, ttl time.Duration) error {
+	return s.p.SetEx(key, int64(seconds(ttl)), string(value))
+}
+
+func (s *redisPoolCacheStore) SetNX(key string, value []byte, ttl time.Duration) (bool, error) {
+	// reply is nil if key exists
+	reply, err := s.p.SetNX(key, string(value), seconds(ttl))
+	return err == nil && reply != nil, err
+}
+
+func (s *redisPoolCacheStore) Del(key string) error {
+	return s.p.Del(key)
+}
+
+type redisClusterCacheStore struct {
+	c RedisClusterCmd
+}
+
+func (s *redisClusterCacheStore) Get(key string) ([]byte, bool, error) {
+	return redisValue(s.c.MGet([]string{key}))
+}
+
+func (s *redisClusterCacheStore) Set(key string, value []byte, ttl time.Duration) error {
+	_, err := s.c.Set(key, string(value), ttl)
+	return err
+}
+
+func (s *redisClusterCacheStore) SetNX(key string, value []byte, ttl time.Duration) (bool, error) {
+	return s.c.SetNX(key, string(value), ttl)
+}
+
+func (s *redisClusterCacheStore) Del(key string) error {
+	_, err := s.c.Del(key)
+	return err
+}
+
+// seconds rounds ttl up to seconds, which is expire of redis pool.
+func seconds(ttl time.Duration) int {
+	return int((ttl + time.Second - 1) / time.Second)
+}
+
+// redisValue returns the only value of MGet, which is nil if key does not exist.
+func redisValue(values []interface{}, err error) ([]byte, bool, error) {
+	if err != nil || len(values) == 0 || values[0] == nil {
+		return nil, false, err
+	}
+	switch v := values[0].(type) {
+	case []byte:
+		return v, true, nil
+	case string:
+		return []byte(v), true, nil
+	}
+	return nil, false, fmt.Errorf("redis value type %T unknown", values[0])
+}
@@ -0,0 +1,188 @@
+/**
+ * Copyright 2019 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package dhttp
+
+import (
+	"github.com/gdp-org/gd/databases/redisdb"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var (
+	_ RedisPoolCmd    = (*redisdb.RedisPoolClient)(nil)
+	_ RedisClusterCmd = (*redisdb.RedisClusterClient)(nil)
+)
+
+type cacheTestReq struct {
+	Id int `form:"id"`
+}
+
+func cacheTestServe(rc *ResponseCache, calls *int32, wait chan struct{}) *gin.Engine {
+	g := gin.New()
+	r := g.Group("/", GroupFilter(), rc.Filter())
+	r.GET("/user", Wrap(func(c *gin.Context, req *cacheTestReq) (int, string, error, map[string]int) {
+		n := atomic.AddInt32(calls, 1)
+		if wait != nil {
+			<-wait
+		}
+		if req.Id < 0 {
+			return http.StatusNotFound, "not found", nil, nil
+		}
+		if req.Id == 0 {
+			c.Header("Cache-Control", "private")
+		}
+		return http.StatusOK, "ok", nil, map[string]int{"id": req.Id, "calls": int(n)}
+	}))
+	return g
+}
+
+func TestLruCacheStore(t *testing.T) {
+	s := NewLruCacheStore(2)
+	s.Set("a", []byte("1"), time.Minute)
+	s.Set("b", []byte("2"), time.Minute)
+	s.Get("a")
+	s.Set("c", []byte("3"), time.Minute)
+	if _, ok, _ := s.Get("b"); ok {
+		t.Fatal("b should be evicted")
+	}
+	if v, ok, _ := s.Get("a"); !ok || string(v) != "1" {
+		t.Fatalf("unexpected a %s", v)
+	}
+
+	s.Set("d", []byte("4"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok, _ := s.Get("d"); ok {
+		t.Fatal("d should be expired")
+	}
+	s.Del("a")
+	if s.Len() != 0 {
+		t.Fatalf("unexpected len %d", s.Len())
+	}
+}
+
+func TestLruCacheStoreSetNX(t *testing.T) {
+	s := NewLruCacheStore(0)
+	var set int32
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _ := s.SetNX("a", []byte("1"), time.Minute); ok {
+				atomic.AddInt32(&set, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if set != 1 {
+		t.Fatalf("key is set %d times", set)
+	}
+
+	s.Set("b", []byte("2"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if ok, _ := s.SetNX("b", []byte("3"), time.Minute); !ok {
+		t.Fatal("expect expired b set")
+	}
+}
+
+func TestCacheFilter(t *testing.T) {
+	var calls int32
+	rc := &ResponseCache{Store: NewLruCacheStore(100), TTL: time.Minute}
+	g := cacheTestServe(rc, &calls, nil)
+
+	w := testServe(g, http.MethodGet, "/user?id=1&x=a", "")
+	if w.Code != http.StatusOK || w.Header().Get(CacheHeader) != cacheMiss {
+		t.Fatalf("unexpected miss %d %s", w.Code, w.Header().Get(CacheHeader))
+	}
+	body := w.Body.String()
+
+	// the same query in another order
+	w = testServe(g, http.MethodGet, "/user?x=a&id=1", "")
+	if w.Header().Get(CacheHeader) != cacheHit || w.Body.String() != body {
+		t.Fatalf("unexpected hit %s %s", w.Header().Get(CacheHeader), w.Body.String())
+	}
+	if calls != 1 {
+		t.Fatalf("unexpected calls %d", calls)
+	}
+
+	testServe(g, http.MethodGet, "/user?id=1&x=a", "", "Cache-Control", "no-cache")
+	if calls != 2 {
+		t.Fatalf("no-cache should call handler, calls %d", calls)
+	}
+	testServe(g, http.MethodGet, "/user?id=1&x=a", "", "Cache-Control", "no-store")
+	if w = testServe(g, http.MethodGet, "/user?id=1&x=a", ""); w.Header().Get(CacheHeader) != cacheHit || calls != 3 {
+		t.Fatalf("unexpected calls %d", calls)
+	}
+
+	// private response and error are not cached
+	testServe(g, http.MethodGet, "/user?id=0", "")
+	testServe(g, http.MethodGet, "/user?id=0", "")
+	testServe(g, http.MethodGet, "/user?id=-1", "")
+	testServe(g, http.MethodGet, "/user?id=-1", "")
+	if calls != 7 {
+		t.Fatalf("unexpected calls %d", calls)
+	}
+
+	if err := rc.Purge("/user", "x=a&id=1", ""); err != nil {
+		t.Fatalf("purge occur error:%s", err)
+	}
+	if w = testServe(g, http.MethodGet, "/user?id=1&x=a", ""); w.Header().Get(CacheHeader) != cacheMiss {
+		t.Fatal("purged response should miss")
+	}
+}
+
+func TestCacheFilterCoalesce(t *testing.T) {
+	var calls int32
+	wait := make(chan struct{})
+	rc := &ResponseCache{Store: NewLruCacheStore(100), TTL: time.Minute}
+	g := cacheTestServe(rc, &calls, wait)
+
+	var wg sync.WaitGroup
+	results := make([]*httptest.ResponseRecorder, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = testServe(g, http.MethodGet, "/user?id=2", "")
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(wait)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatalf("unexpected calls %d", calls)
+	}
+	for _, w := range results {
+		if w.Code != http.StatusOK || w.Body.String() != results[0].Body.String() {
+			t.Fatalf("unexpected response %d %s", w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestResponseTtl(t *testing.T) {
+	cases := []struct {
+		header string
+		ttl    time.Duration
+		ok     bool
+	}{
+		{"", time.Minute, true},
+		{"public, max-age=10", 10 * time.Second, true},
+		{"max-age=10, s-maxage=20", 20 * time.Second, true},
+		{"no-store", 0, false},
+		{"private, max-age=10", 0, false},
+	}
+	for _, c := range cases {
+		if ttl, ok := responseTtl(c.header, time.Minute); ttl != c.ttl || ok != c.ok {
+			t.Fatalf("unexpected ttl of %q: %v %v", c.header, ttl, ok)
+		}
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/gdp-org/gd/dlog"
+	"github.com/gdp-org/gd/runtime/breaker"
 	"github.com/gdp-org/gd/runtime/gl"
 	"github.com/gdp-org/gd/runtime/pc"
 	"github.com/gdp-org/gd/utls"
@@ -110,6 +111,7 @@ type HttpClient struct {
 	CurlCommand          bool
 	Retryable            HttpClientRetryable
 	DoNotClearHttpClient bool
+	Breaker              *breaker.Group
 	isClone              bool
 }
 
@@ -283,6 +285,7 @@ func (dhc *HttpClient) Clone() *HttpClient {
 		CurlCommand:          dhc.CurlCommand,
 		Retryable:            copyRetryable(dhc.Retryable),
 		DoNotClearHttpClient: true,
+		Breaker:              dhc.Breaker,
 		isClone:              true,
 	}
 	return clone
@@ -306,6 +309,13 @@ func (dhc *HttpClient) SetDoNotClearHttpClient(enable bool) *HttpClient {
 	return dhc
 }
 
+// Enable the circuit breaker of hosts, requests to host whose breaker is open fail without sending.
+// network errors and 5xx responses are counted as failure.
+func (dhc *HttpClient) SetBreaker(g *breaker.Group) *HttpClient {
+	dhc.Breaker = g
+	return dhc
+}
+
 // Clear HttpClient data for another new request.
 func (dhc *HttpClient) ClearHttpClient() {
 	if dhc.DoNotClearHttpClient {
@@ -1300,8 +1310,21 @@ func (dhc *HttpClient) getResponseBytes() (Response, []byte, error) {
 		}
 	}
 
+	var br *breaker.Breaker
+	if dhc.Breaker != nil {
+		br = dhc.Breaker.Get(req.URL.Host)
+		if !br.Allow() {
+			err = fmt.Errorf("circuit breaker of %s is open", req.URL.Host)
+			dhc.Errors = append(dhc.Errors, err)
+			return nil, nil, dhc.marshalErrors()
+		}
+	}
+
 	// Send request
 	resp, err = dhc.Client.Do(req)
+	if br != nil {
+		br.Done(err == nil && resp.StatusCode < http.StatusInternalServerError, time.Now().Sub(sTime))
+	}
 	if err != nil {
 		dhc.Errors = append(dhc.Errors, err)
 		return nil, nil, dhc.marshalErrors()
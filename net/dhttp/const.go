@@ -18,4 +18,5 @@ const (
 	RedirectUrl     = "redirect_url"
 	TraceID         = "trace_id"
 	GdToken         = "gd_token"
+	Stream          = "stream"
 )
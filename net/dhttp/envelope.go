@@ -0,0 +1,242 @@
+/**
+ * Copyright 2019 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package dhttp
+
+import (
+	"encoding/json"
+	de "github.com/gdp-org/gd/derror"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+	"net/http"
+	"strconv"
+)
+
+/*
+ * envelope builds the response of wrapped handlers from code, message, err and result returned by
+ * them. Return sets the body as ret and the http status as code, then GroupFilter renders it.
+ *
+ * JsonEnvelope:    {"code": code, "message": message, "result": result}, field names are configurable
+ * ProblemEnvelope: result if status < 400, or RFC 7807 application/problem+json
+ * RawEnvelope:     result itself
+ *
+ * the envelope is DefaultEnvelope, or set for routes by UseEnvelope or HttpServer.Envelope. http
+ * status is code itself by default, set StatusMapper of envelope to MapStatus or ErrorStatus to
+ * map business codes to http status.
+ *
+ * results which are render.Render are written as they are, e.g. render.XML, render.ProtoBuf and
+ * render.Reader of streamed files.
+ */
+
+const (
+	envelopeKey = "dhttp_envelope"
+
+	ProblemContentType = "application/problem+json"
+)
+
+type Envelope interface {
+	// Status returns http status of response.
+	Status(code int, err error) int
+	// Body returns response body, which is set as ret.
+	Body(code int, message string, err error, result interface{}) interface{}
+	// Render returns render of body.
+	Render(body interface{}) render.Render
+}
+
+// StatusMapper returns http status of response by code and err of handler.
+type StatusMapper func(code int, err error) int
+
+var (
+	// DefaultEnvelope is used if no envelope is set for routes.
+	DefaultEnvelope Envelope = &JsonEnvelope{}
+
+	// DefaultStatusMap maps codes of derror which are not http status, it is used by MapStatus.
+	DefaultStatusMap = map[int]int{
+		de.RpcSuccess:             http.StatusOK,
+		de.ParameterError:         http.StatusBadRequest,
+		de.DBError:                http.StatusInternalServerError,
+		de.CacheError:             http.StatusInternalServerError,
+		de.RpcTimeout:             http.StatusGatewayTimeout,
+		de.RpcOverflow:            http.StatusServiceUnavailable,
+		de.RpcInternalServerError: http.StatusInternalServerError,
+		de.RpcInvalidParam:        http.StatusBadRequest,
+	}
+)
+
+// CodeStatus is the default StatusMapper, code is http status itself.
+func CodeStatus(code int, err error) int {
+	return code
+}
+
+// MapStatus is StatusMapper which returns code if it is valid http status, or maps it by
+// DefaultStatusMap, or 500.
+func MapStatus(code int, err error) int {
+	if code >= 100 && code < 600 {
+		return code
+	}
+	if status, ok := DefaultStatusMap[code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// ErrorStatus returns StatusMapper which maps code of CodeError err by m, code of handler is
+// the business code only. codes not in m are mapped by MapStatus.
+func ErrorStatus(m map[int]int) StatusMapper {
+	return func(code int, err error) int {
+		if ce, ok := err.(*de.CodeError); ok {
+			code = ce.Code()
+		}
+		if status, ok := m[code]; ok {
+			return status
+		}
+		return MapStatus(code, err)
+	}
+}
+
+// UseEnvelope sets envelope of routes.
+func UseEnvelope(e Envelope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(envelopeKey, e)
+		c.Next()
+	}
+}
+
+func envelopeOf(c *gin.Context) Envelope {
+	if e, ok := c.Get(envelopeKey); ok {
+		return e.(Envelope)
+	}
+	return DefaultEnvelope
+}
+
+// abortWith renders message of status by envelope of routes, it is used by filters before GroupFilter.
+func abortWith(c *gin.Context, status int, message string) {
+	e := envelopeOf(c)
+	c.Set(Code, status)
+	c.Render(status, e.Render(e.Body(status, message, nil, nil)))
+	c.Abort()
+}
+
+func status(mapper StatusMapper, code int, err error) int {
+	if mapper == nil {
+		return CodeStatus(code, err)
+	}
+	return mapper(code, err)
+}
+
+type JsonEnvelope struct {
+	CodeField    string
+	MessageField string
+	ResultField  string
+	StatusMapper StatusMapper
+}
+
+func (e *JsonEnvelope) Status(code int, err error) int {
+	return status(e.StatusMapper, code, err)
+}
+
+func (e *JsonEnvelope) Body(code int, message string, err error, result interface{}) interface{} {
+	ret := make(map[string]interface{})
+	ret[fieldOr(e.CodeField, "code")] = code
+	ret[fieldOr(e.ResultField, "result")] = result
+	ret[fieldOr(e.MessageField, "message")] = message
+	return ret
+}
+
+func (e *JsonEnvelope) Render(body interface{}) render.Render {
+	return render.JSON{Data: body}
+}
+
+func fieldOr(field, def string) string {
+	if field == "" {
+		return def
+	}
+	return field
+}
+
+// Problem is the RFC 7807 problem details, Code is the business code and Result is returned by handler.
+type Problem struct {
+	Type     string      `json:"type"`
+	Title    string      `json:"title"`
+	Status   int         `json:"status"`
+	Detail   string      `json:"detail,omitempty"`
+	Instance string      `json:"instance,omitempty"`
+	Code     int         `json:"code"`
+	Result   interface{} `json:"result,omitempty"`
+}
+
+type ProblemEnvelope struct {
+	// TypeBase is prefix of problem type, which is followed by code, type is about:blank if it is empty
+	TypeBase     string
+	StatusMapper StatusMapper
+}
+
+func (e *ProblemEnvelope) Status(code int, err error) int {
+	return status(e.StatusMapper, code, err)
+}
+
+func (e *ProblemEnvelope) Body(code int, message string, err error, result interface{}) interface{} {
+	s := e.Status(code, err)
+	if s < http.StatusBadRequest {
+		return result
+	}
+
+	p := &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(s),
+		Status: s,
+		Detail: message,
+		Code:   code,
+		Result: result,
+	}
+	if e.TypeBase != "" {
+		p.Type = e.TypeBase + strconv.Itoa(code)
+	}
+	if ce, ok := err.(*de.CodeError); ok && ce.Type() != "" {
+		p.Title = ce.Type()
+	}
+	if p.Detail == "" && err != nil {
+		p.Detail = err.Error()
+	}
+	return p
+}
+
+func (e *ProblemEnvelope) Render(body interface{}) render.Render {
+	if p, ok := body.(*Problem); ok {
+		return problemRender{p}
+	}
+	return render.JSON{Data: body}
+}
+
+type problemRender struct {
+	problem *Problem
+}
+
+func (r problemRender) Render(w http.ResponseWriter) error {
+	r.WriteContentType(w)
+	return json.NewEncoder(w).Encode(r.problem)
+}
+
+func (r problemRender) WriteContentType(w http.ResponseWriter) {
+	if header := w.Header(); len(header["Content-Type"]) == 0 {
+		header["Content-Type"] = []string{ProblemContentType}
+	}
+}
+
+type RawEnvelope struct {
+	StatusMapper StatusMapper
+}
+
+func (e *RawEnvelope) Status(code int, err error) int {
+	return status(e.StatusMapper, code, err)
+}
+
+func (e *RawEnvelope) Body(code int, message string, err error, result interface{}) interface{} {
+	return result
+}
+
+func (e *RawEnvelope) Render(body interface{}) render.Render {
+	return render.JSON{Data: body}
+}
@@ -0,0 +1,123 @@
+/**
+ * Copyright 2019 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package dhttp
+
+import (
+	"encoding/json"
+	de "github.com/gdp-org/gd/derror"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type envelopeTestReq struct {
+	Fail bool `form:"fail"`
+}
+
+type envelopeTestResp struct {
+	Name string `json:"name" xml:"name"`
+}
+
+func envelopeTestHandler(c *gin.Context, req *envelopeTestReq) (int, string, error, *envelopeTestResp) {
+	if req.Fail {
+		return 1001, "user not found", de.NewCodeError(1001, "no user"), nil
+	}
+	return de.RpcSuccess, "ok", nil, &envelopeTestResp{Name: "gd"}
+}
+
+func envelopeTestServe(e Envelope) *gin.Engine {
+	g := gin.New()
+	if e != nil {
+		g.Use(UseEnvelope(e))
+	}
+	r := g.Group("/")
+	r.Use(GroupFilter())
+	r.GET("/user", Wrap(envelopeTestHandler))
+	r.GET("/xml", Wrap(func(c *gin.Context, req *envelopeTestReq) (int, string, error, render.Render) {
+		return http.StatusOK, "", nil, render.XML{Data: &envelopeTestResp{Name: "gd"}}
+	}))
+	return g
+}
+
+func TestCodeStatus(t *testing.T) {
+	for _, code := range []int{de.RpcSuccess, http.StatusCreated, 600, 1001} {
+		if s := CodeStatus(code, nil); s != code {
+			t.Fatalf("unexpected status %d of code %d", s, code)
+		}
+	}
+
+	cases := map[int]int{
+		de.RpcSuccess:      http.StatusOK,
+		http.StatusCreated: http.StatusCreated,
+		de.ParameterError:  http.StatusBadRequest,
+		de.RpcTimeout:      http.StatusGatewayTimeout,
+		1001:               http.StatusInternalServerError,
+	}
+	for code, status := range cases {
+		if s := MapStatus(code, nil); s != status {
+			t.Fatalf("unexpected mapped status %d of code %d", s, code)
+		}
+	}
+
+	mapper := ErrorStatus(map[int]int{1001: http.StatusNotFound})
+	if s := mapper(de.RpcSuccess, de.NewCodeError(1001, "no user")); s != http.StatusNotFound {
+		t.Fatalf("unexpected status %d of code error", s)
+	}
+	if s := mapper(de.RpcSuccess, nil); s != http.StatusOK {
+		t.Fatalf("unexpected status %d of success", s)
+	}
+}
+
+func TestJsonEnvelope(t *testing.T) {
+	w := testServe(envelopeTestServe(nil), http.MethodGet, "/user", "")
+	if w.Code != http.StatusOK || w.Body.String() != `{"code":0,"message":"ok","result":{"name":"gd"}}` {
+		t.Fatalf("unexpected rsp %d %s", w.Code, w.Body.String())
+	}
+
+	e := &JsonEnvelope{CodeField: "errno", MessageField: "errmsg", ResultField: "data", StatusMapper: ErrorStatus(map[int]int{1001: http.StatusNotFound})}
+	w = testServe(envelopeTestServe(e), http.MethodGet, "/user?fail=true", "")
+	if w.Code != http.StatusNotFound || w.Body.String() != `{"data":null,"errmsg":"user not found","errno":1001}` {
+		t.Fatalf("unexpected rsp %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestProblemEnvelope(t *testing.T) {
+	e := &ProblemEnvelope{TypeBase: "https://example.com/errors/", StatusMapper: ErrorStatus(map[int]int{1001: http.StatusNotFound})}
+
+	w := testServe(envelopeTestServe(e), http.MethodGet, "/user", "")
+	if w.Code != http.StatusOK || w.Body.String() != `{"name":"gd"}` {
+		t.Fatalf("unexpected rsp %d %s", w.Code, w.Body.String())
+	}
+
+	w = testServe(envelopeTestServe(e), http.MethodGet, "/user?fail=true", "")
+	if w.Code != http.StatusNotFound || w.Header().Get("Content-Type") != ProblemContentType {
+		t.Fatalf("unexpected rsp %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var p Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatalf("unmarshal problem occur error:%s", err)
+	}
+	if p.Type != "https://example.com/errors/1001" || p.Status != http.StatusNotFound || p.Code != 1001 || p.Detail != "user not found" {
+		t.Fatalf("unexpected problem %+v", p)
+	}
+}
+
+func TestRawEnvelope(t *testing.T) {
+	w := testServe(envelopeTestServe(&RawEnvelope{}), http.MethodGet, "/user", "")
+	if w.Code != http.StatusOK || w.Body.String() != `{"name":"gd"}` {
+		t.Fatalf("unexpected rsp %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRenderResult(t *testing.T) {
+	w := testServe(envelopeTestServe(nil), http.MethodGet, "/xml", "")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/xml") ||
+		!strings.Contains(w.Body.String(), "<name>gd</name>") {
+		t.Fatalf("unexpected rsp %d %s", w.Code, w.Body.String())
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/gdp-org/gd/utls"
 	"github.com/gdp-org/gd/utls/network"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
 	"net/http"
 	"strconv"
 	"strings"
@@ -26,10 +27,18 @@ import (
 func GroupFilter() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Next()
+		if c.GetBool(Stream) {
+			// sse and websocket write responses themselves
+			return
+		}
 		ret, _ := ParseRet(c)
 		httpStatusInterface, _ := c.Get(Code)
 		httpStatus := httpStatusInterface.(int)
-		c.JSON(httpStatus, ret)
+		if r, ok := ret.(render.Render); ok {
+			c.Render(httpStatus, r)
+			return
+		}
+		c.Render(httpStatus, envelopeOf(c).Render(ret))
 	}
 }
 
@@ -106,6 +115,10 @@ func Logger(pk string) gin.HandlerFunc {
 		r, ok := gl.Get(gl.HideRet)
 		if (ok && !r.(bool)) || !ok {
 			ret, _ = c.Get(Ret)
+			if _, ok := ret.(render.Render); ok {
+				// body of render may be large or not json, e.g. file
+				ret = fmt.Sprintf("%T", ret)
+			}
 		}
 
 		httpStatusInterface, _ := c.Get(Code)
@@ -159,6 +172,10 @@ func Logger(pk string) gin.HandlerFunc {
 			dlog.Error("json marshal occur error:%v", jsonErr)
 		}
 
+		if c.GetBool(Stream) {
+			dlog.InfoT("SESSION_STREAM", fmt.Sprintf("%s %s %s %s", pk, c.Request.Method, path, string(mj)))
+			return
+		}
 		if cost > 100 {
 			dlog.WarnT("SESSION_SLOW", fmt.Sprintf("%s %s %s %s", pk, c.Request.Method, path, string(mj)))
 			return
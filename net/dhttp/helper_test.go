@@ -0,0 +1,33 @@
+/**
+ * Copyright 2019 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package dhttp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+// testDo serves req by h and returns the recorded response.
+func testDo(h http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// testServe serves request of method, url and body by h, header is pairs of key and value.
+func testServe(h http.Handler, method, url, body string, header ...string) *httptest.ResponseRecorder {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, url, r)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	return testDo(h, req)
+}
@@ -0,0 +1,317 @@
+/**
+ * Copyright 2019 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package dhttp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gdp-org/gd/dlog"
+	"github.com/gdp-org/gd/runtime/pc"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+ * idempotency of unsafe handlers, e.g. POST. IdempotencyFilter should be used before GroupFilter,
+ * so that the rendered response is stored, e.g.
+ *
+ * idem := &dhttp.Idempotency{Store: dhttp.NewRedisPoolCacheStore(pool), Retention: 24 * time.Hour}
+ * r := g.Group("/api", idem.Filter(), dhttp.GroupFilter())
+ *
+ * when request has Idempotency-Key header, the first response of the key is stored for Retention,
+ * and duplicates are replayed with Idempotent-Replayed header without calling handler. key is
+ * scoped by method, path and KeyFunc, e.g. user of request.
+ *
+ * the key is locked while handler is running, duplicates wait for it until LockTimeout, then 409
+ * is returned. duplicates whose body differ from the first one are rejected with 422. responses
+ * of 5xx are not stored, so that the request can be retried.
+ *
+ * requests without the header, or of GET, HEAD and OPTIONS are not affected. if Store fails,
+ * requests are served without idempotency. results are counted by pc with key
+ * dhttp_idempotency,route=<route>,result=<stored|replayed|conflict|mismatch>.
+ */
+
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLen = 255
+
+	defaultIdempotencyRetention   = 24 * time.Hour
+	defaultIdempotencyLockTimeout = 30 * time.Second
+	defaultIdempotencyPoll        = 100 * time.Millisecond
+)
+
+type Idempotency struct {
+	Store     CacheStore
+	KeyPrefix string
+	// KeyFunc returns scope of key, e.g. user of request
+	KeyFunc func(c *gin.Context) string
+	// Retention is how long responses are stored, default 24h
+	Retention time.Duration
+	// LockTimeout is the longest time handler runs, and duplicates wait for it, default 30s
+	LockTimeout time.Duration
+	// PollInterval is interval of duplicates checking the lock of other servers, default 100ms
+	PollInterval time.Duration
+
+	callLock sync.Mutex
+	calls    map[string]chan struct{}
+}
+
+type idempotencyRecord struct {
+	Done        bool        `json:"done"`
+	Fingerprint string      `json:"fingerprint"`
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+}
+
+// idempotencyWriter keeps body written by handler.
+type idempotencyWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *idempotencyWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *idempotencyWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Filter makes handlers after it idempotent by Idempotency-Key header.
+func (i *Idempotency) Filter() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idemKey := c.GetHeader(IdempotencyKeyHeader)
+		if idemKey == "" || isSafeMethod(c.Request.Method) {
+			c.Next()
+			return
+		}
+		if len(idemKey) > maxIdempotencyKeyLen {
+			abortWith(c, http.StatusBadRequest, fmt.Sprintf("%s is longer than %d", IdempotencyKeyHeader, maxIdempotencyKeyLen))
+			return
+		}
+
+		fingerprint, err := requestFingerprint(c.Request)
+		if err != nil {
+			abortWith(c, http.StatusBadRequest, "read body occur error:"+err.Error())
+			return
+		}
+
+		key := i.key(c, idemKey)
+		route := c.FullPath()
+		deadline := time.Now().Add(i.lockTimeout())
+		if !i.lockLocal(key, deadline) {
+			i.count(route, "conflict")
+			abortWith(c, http.StatusConflict, "request of "+IdempotencyKeyHeader+" is in progress")
+			return
+		}
+		defer i.unlockLocal(key)
+
+		rec, locked, err := i.acquire(key, fingerprint, deadline)
+		if err != nil {
+			dlog.Warn("idempotency of %s occur error:%v, serve without it", key, err)
+			c.Next()
+			return
+		}
+
+		switch {
+		case locked:
+			i.serve(c, key, fingerprint, route)
+		case rec == nil:
+			i.count(route, "conflict")
+			abortWith(c, http.StatusConflict, "request of "+IdempotencyKeyHeader+" is in progress")
+		case rec.Fingerprint != fingerprint:
+			i.count(route, "mismatch")
+			abortWith(c, http.StatusUnprocessableEntity, IdempotencyKeyHeader+" is used by another request")
+		default:
+			i.count(route, "replayed")
+			i.replay(c, rec)
+		}
+	}
+}
+
+func (i *Idempotency) key(c *gin.Context, idemKey string) string {
+	var scope string
+	if i.KeyFunc != nil {
+		scope = i.KeyFunc(c)
+	}
+	return i.KeyPrefix + c.Request.Method + " " + c.Request.URL.Path + "#" + scope + "#" + idemKey
+}
+
+// acquire locks key, or returns the stored record. record is nil if key is not released by
+// other servers until deadline.
+func (i *Idempotency) acquire(key, fingerprint string, deadline time.Time) (*idempotencyRecord, bool, error) {
+	pending, err := json.Marshal(&idempotencyRecord{Fingerprint: fingerprint})
+	if err != nil {
+		return nil, false, err
+	}
+
+	for {
+		locked, err := i.Store.SetNX(key, pending, i.lockTimeout())
+		if err != nil || locked {
+			return nil, locked, err
+		}
+
+		value, ok, err := i.Store.Get(key)
+		if err != nil {
+			return nil, false, err
+		}
+		if ok {
+			rec := &idempotencyRecord{}
+			if err := json.Unmarshal(value, rec); err != nil {
+				return nil, false, err
+			}
+			if rec.Done || rec.Fingerprint != fingerprint {
+				return rec, false, nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return nil, false, nil
+		}
+		time.Sleep(i.pollInterval())
+	}
+}
+
+// serve calls handler, and stores the response unless it is 5xx.
+func (i *Idempotency) serve(c *gin.Context, key, fingerprint, route string) {
+	w := &idempotencyWriter{ResponseWriter: c.Writer}
+	c.Writer = w
+	stored := false
+	defer func() {
+		c.Writer = w.ResponseWriter
+		if !stored {
+			// release the lock, so that the request can be retried
+			if err := i.Store.Del(key); err != nil {
+				dlog.Warn("idempotency release %s occur error:%v", key, err)
+			}
+		}
+	}()
+
+	c.Next()
+
+	status := w.Status()
+	if status >= http.StatusInternalServerError || c.GetBool(Stream) {
+		return
+	}
+	value, err := json.Marshal(&idempotencyRecord{
+		Done:        true,
+		Fingerprint: fingerprint,
+		Status:      status,
+		Header:      w.Header().Clone(),
+		Body:        w.body.Bytes(),
+	})
+	if err != nil {
+		dlog.Warn("idempotency marshal %s occur error:%v", key, err)
+		return
+	}
+	if err := i.Store.Set(key, value, i.retention()); err != nil {
+		dlog.Warn("idempotency store %s occur error:%v", key, err)
+		return
+	}
+	stored = true
+	i.count(route, "stored")
+}
+
+func (i *Idempotency) replay(c *gin.Context, rec *idempotencyRecord) {
+	header := c.Writer.Header()
+	for k, v := range rec.Header {
+		header[k] = v
+	}
+	header.Set(IdempotentReplayedHeader, "true")
+	c.Set(Code, rec.Status)
+	c.Status(rec.Status)
+	c.Writer.Write(rec.Body)
+	c.Abort()
+}
+
+func (i *Idempotency) count(route, result string) {
+	pc.Incr(fmt.Sprintf("dhttp_idempotency,route=%s,result=%s", route, result), 1)
+}
+
+// lockLocal serializes duplicates in this server, so that they do not poll the store. it returns
+// false if key is not unlocked until deadline.
+func (i *Idempotency) lockLocal(key string, deadline time.Time) bool {
+	t := time.NewTimer(time.Until(deadline))
+	defer t.Stop()
+	for {
+		i.callLock.Lock()
+		if i.calls == nil {
+			i.calls = make(map[string]chan struct{})
+		}
+		done, ok := i.calls[key]
+		if !ok {
+			i.calls[key] = make(chan struct{})
+			i.callLock.Unlock()
+			return true
+		}
+		i.callLock.Unlock()
+
+		select {
+		case <-done:
+		case <-t.C:
+			return false
+		}
+	}
+}
+
+func (i *Idempotency) unlockLocal(key string) {
+	i.callLock.Lock()
+	done := i.calls[key]
+	delete(i.calls, key)
+	i.callLock.Unlock()
+	close(done)
+}
+
+func (i *Idempotency) retention() time.Duration {
+	if i.Retention <= 0 {
+		return defaultIdempotencyRetention
+	}
+	return i.Retention
+}
+
+func (i *Idempotency) lockTimeout() time.Duration {
+	if i.LockTimeout <= 0 {
+		return defaultIdempotencyLockTimeout
+	}
+	return i.LockTimeout
+}
+
+func (i *Idempotency) pollInterval() time.Duration {
+	if i.PollInterval <= 0 {
+		return defaultIdempotencyPoll
+	}
+	return i.PollInterval
+}
+
+// requestFingerprint returns hash of body, and body of request is restored.
+func requestFingerprint(r *http.Request) (string, error) {
+	h := sha256.New()
+	if r.Body != nil {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return "", err
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		h.Write(body)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
@@ -0,0 +1,142 @@
+/**
+ * Copyright 2019 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package dhttp
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type idempotencyTestReq struct {
+	Amount int `json:"amount"`
+}
+
+func idempotencyTestServe(i *Idempotency, calls *int32, wait time.Duration) *gin.Engine {
+	g := gin.New()
+	r := g.Group("/", i.Filter(), GroupFilter())
+	r.POST("/pay", Wrap(func(c *gin.Context, req *idempotencyTestReq) (int, string, error, map[string]int) {
+		n := atomic.AddInt32(calls, 1)
+		time.Sleep(wait)
+		if req.Amount < 0 {
+			return http.StatusInternalServerError, "failed", nil, nil
+		}
+		return http.StatusCreated, "ok", nil, map[string]int{"amount": req.Amount, "calls": int(n)}
+	}))
+	return g
+}
+
+func idempotencyTestPost(g *gin.Engine, key, body string) *httptest.ResponseRecorder {
+	return testServe(g, http.MethodPost, "/pay", body, "Content-Type", "application/json", IdempotencyKeyHeader, key)
+}
+
+func TestIdempotency(t *testing.T) {
+	var calls int32
+	i := &Idempotency{Store: NewLruCacheStore(100)}
+	g := idempotencyTestServe(i, &calls, 0)
+
+	first := idempotencyTestPost(g, "k1", `{"amount":1}`)
+	if first.Code != http.StatusCreated || first.Header().Get(IdempotentReplayedHeader) != "" {
+		t.Fatalf("unexpected first response %d", first.Code)
+	}
+	w := idempotencyTestPost(g, "k1", `{"amount":1}`)
+	if w.Code != http.StatusCreated || w.Body.String() != first.Body.String() || w.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Fatalf("unexpected replay %d %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Content-Type") != first.Header().Get("Content-Type") {
+		t.Fatalf("unexpected content type %s", w.Header().Get("Content-Type"))
+	}
+	if calls != 1 {
+		t.Fatalf("unexpected calls %d", calls)
+	}
+
+	if w = idempotencyTestPost(g, "k1", `{"amount":2}`); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("unexpected mismatch response %d", w.Code)
+	}
+
+	// without key and 5xx are not stored
+	idempotencyTestPost(g, "", `{"amount":1}`)
+	idempotencyTestPost(g, "", `{"amount":1}`)
+	idempotencyTestPost(g, "k2", `{"amount":-1}`)
+	idempotencyTestPost(g, "k2", `{"amount":-1}`)
+	if calls != 5 {
+		t.Fatalf("unexpected calls %d", calls)
+	}
+}
+
+func TestIdempotencyConcurrent(t *testing.T) {
+	var calls int32
+	i := &Idempotency{Store: NewLruCacheStore(100)}
+	g := idempotencyTestServe(i, &calls, 50*time.Millisecond)
+
+	var wg sync.WaitGroup
+	results := make([]*httptest.ResponseRecorder, 5)
+	for n := range results {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			results[n] = idempotencyTestPost(g, "k", `{"amount":3}`)
+		}(n)
+	}
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatalf("unexpected calls %d", calls)
+	}
+	for _, w := range results {
+		if w.Code != http.StatusCreated || w.Body.String() != results[0].Body.String() {
+			t.Fatalf("unexpected response %d %s", w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestIdempotencyLockTimeout(t *testing.T) {
+	var calls int32
+	store := NewLruCacheStore(100)
+	i := &Idempotency{Store: store, LockTimeout: 50 * time.Millisecond, PollInterval: 10 * time.Millisecond}
+	g := idempotencyTestServe(i, &calls, 0)
+
+	// locked by another server
+	fingerprint, _ := requestFingerprint(httptest.NewRequest(http.MethodPost, "/pay", strings.NewReader(`{"amount":1}`)))
+	pending, _ := json.Marshal(&idempotencyRecord{Fingerprint: fingerprint})
+	store.Set("POST /pay##k", pending, 150*time.Millisecond)
+	if w := idempotencyTestPost(g, "k", `{"amount":1}`); w.Code != http.StatusConflict || calls != 0 {
+		t.Fatalf("unexpected response %d", w.Code)
+	}
+
+	// lock expired
+	time.Sleep(100 * time.Millisecond)
+	if w := idempotencyTestPost(g, "k", `{"amount":1}`); w.Code != http.StatusCreated || calls != 1 {
+		t.Fatalf("unexpected response %d, calls %d", w.Code, calls)
+	}
+
+	// locked by a request of this server
+	calls = 0
+	g = idempotencyTestServe(i, &calls, 200*time.Millisecond)
+	first := make(chan *httptest.ResponseRecorder)
+	go func() {
+		first <- idempotencyTestPost(g, "k2", `{"amount":1}`)
+	}()
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	st := time.Now()
+	if w := idempotencyTestPost(g, "k2", `{"amount":1}`); w.Code != http.StatusConflict {
+		t.Fatalf("unexpected response %d", w.Code)
+	}
+	if d := time.Since(st); d > 150*time.Millisecond {
+		t.Fatalf("duplicate waits %v", d)
+	}
+	if w := <-first; w.Code != http.StatusCreated {
+		t.Fatalf("unexpected response %d", w.Code)
+	}
+}
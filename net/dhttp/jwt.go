@@ -0,0 +1,490 @@
+/**
+ * Copyright 2019 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package dhttp
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gdp-org/gd/dlog"
+	"github.com/gdp-org/gd/runtime/gl"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/ini.v1"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * bearer authentication of jwt, which is issued by OAuth2 server or services. tokens are signed
+ * by HS256 with HmacSecret, or RS256 with keys of JwksFile or JwksUrl, which are cached and
+ * reloaded every JwksRefresh or when kid of token is unknown.
+ *
+ * exp is required, iss and aud are checked if Issuer and Audience are set. claims are set in
+ * gin context, and sub and claims in gl for logging, which is initialized by Filter for the request
+ * if GlFilter is not used before it. routes require roles or scopes by filters, e.g.
+ *
+ * r := g.Group("/api", auth.Filter(), dhttp.GroupFilter())
+ * h.POST(r, "/users", createUser, dhttp.RequireScopes("user:write"))
+ * h.DELETE(r, "/users", deleteUser, dhttp.RequireRoles("admin"))
+ *
+ * HttpServer.JwtAuth is configured in HttpAuth section of conf.ini, e.g.
+ *
+ * [HttpAuth]
+ * issuer      = "https://auth.example.com"
+ * audience    = "gd"
+ * jwksUrl     = "https://auth.example.com/.well-known/jwks.json"
+ * jwksRefresh = 600
+ * leeway      = 30
+ *
+ * failures are 401 with WWW-Authenticate, or 403 if roles or scopes are insufficient.
+ */
+
+const (
+	ClaimsKey = "dhttp_claims"
+
+	DefaultJwksRefresh = 10 * time.Minute
+	DefaultJwtLeeway   = 30 * time.Second
+
+	// unknown kid reloads jwks at most once in jwksMinReload
+	jwksMinReload = time.Minute
+)
+
+var (
+	ErrTokenMissing   = errors.New("bearer token missing")
+	ErrTokenMalformed = errors.New("token malformed")
+	ErrTokenAlg       = errors.New("token alg not supported")
+	ErrTokenSign      = errors.New("token signature invalid")
+	ErrTokenExpired   = errors.New("token expired")
+	ErrTokenClaims    = errors.New("token claims invalid")
+)
+
+// Claims of jwt, numbers are float64.
+type Claims map[string]interface{}
+
+func (c Claims) Subject() string {
+	s, _ := c["sub"].(string)
+	return s
+}
+
+// Roles returns claim roles, which is array of string.
+func (c Claims) Roles() []string {
+	return c.strings("roles")
+}
+
+// Scopes returns claim scope, which is space separated string of OAuth2, or scp which is array.
+func (c Claims) Scopes() []string {
+	if s, ok := c["scope"].(string); ok {
+		return strings.Fields(s)
+	}
+	return c.strings("scp")
+}
+
+func (c Claims) strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		ret := make([]string, 0, len(v))
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				ret = append(ret, s)
+			}
+		}
+		return ret
+	}
+	return nil
+}
+
+func (c Claims) time(name string) (time.Time, bool) {
+	v, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(v), 0), true
+}
+
+// GetClaims returns claims of request authenticated by JwtAuth.
+func GetClaims(c *gin.Context) Claims {
+	if v, ok := c.Get(ClaimsKey); ok {
+		return v.(Claims)
+	}
+	return nil
+}
+
+type JwtAuth struct {
+	Issuer     string
+	Audience   string
+	HmacSecret []byte
+	JwksFile   string
+	JwksUrl    string
+	// JwksRefresh is interval of reloading jwks, default 10m
+	JwksRefresh time.Duration
+	// Leeway is clock skew allowed of exp and nbf, default 30s
+	Leeway time.Duration
+	// Client fetches JwksUrl, http.DefaultClient with timeout 5s if it is nil
+	Client *http.Client
+
+	lock       sync.RWMutex
+	keys       map[string]*rsa.PublicKey
+	loadedAt   time.Time
+	reloadedAt time.Time
+	reloading  chan struct{}
+}
+
+// NewJwtAuthFromSection creates JwtAuth with keys of sec, see the comment of JwtAuth.
+func NewJwtAuthFromSection(sec *ini.Section) (*JwtAuth, error) {
+	a := &JwtAuth{}
+	for _, k := range sec.Keys() {
+		var (
+			n   int
+			err error
+		)
+		switch k.Name() {
+		case "issuer":
+			a.Issuer = k.String()
+		case "audience":
+			a.Audience = k.String()
+		case "hmacSecret":
+			a.HmacSecret = []byte(k.String())
+		case "jwksFile":
+			a.JwksFile = k.String()
+		case "jwksUrl":
+			a.JwksUrl = k.String()
+		case "jwksRefresh":
+			n, err = k.Int()
+			a.JwksRefresh = time.Duration(n) * time.Second
+		case "leeway":
+			n, err = k.Int()
+			a.Leeway = time.Duration(n) * time.Second
+		default:
+			return nil, fmt.Errorf("http auth key %s unknown", k.Name())
+		}
+		if err != nil {
+			return nil, fmt.Errorf("http auth key %s %s illegal", k.Name(), k.String())
+		}
+	}
+
+	if len(a.HmacSecret) == 0 && a.JwksFile == "" && a.JwksUrl == "" {
+		return nil, errors.New("http auth needs hmacSecret, jwksFile or jwksUrl")
+	}
+	return a, nil
+}
+
+// Filter rejects requests without valid bearer token.
+func (a *JwtAuth) Filter() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := bearerToken(c.GetHeader("Authorization"))
+		if !ok {
+			unauthorized(c, ErrTokenMissing)
+			return
+		}
+
+		claims, err := a.Verify(token)
+		if err != nil {
+			dlog.Warn("jwt auth %s %s fail:%v", c.Request.Method, c.Request.URL.Path, err)
+			unauthorized(c, err)
+			return
+		}
+
+		// gl is used by GlFilter before, or only in this request
+		if !gl.Exist() {
+			gl.Init()
+			defer gl.Close()
+		}
+		c.Set(ClaimsKey, claims)
+		gl.Set(gl.AuthSubject, claims.Subject())
+		gl.Set(gl.AuthClaims, map[string]interface{}(claims))
+		c.Next()
+	}
+}
+
+// Verify returns claims of token if its signature and claims are valid.
+func (a *JwtAuth) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenMalformed
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	sign, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	switch header.Alg {
+	case "HS256":
+		if len(a.HmacSecret) == 0 {
+			return nil, ErrTokenAlg
+		}
+		mac := hmac.New(sha256.New, a.HmacSecret)
+		mac.Write(signed)
+		if !hmac.Equal(sign, mac.Sum(nil)) {
+			return nil, ErrTokenSign
+		}
+	case "RS256":
+		key, err := a.key(header.Kid)
+		if err != nil {
+			return nil, err
+		}
+		digest := sha256.Sum256(signed)
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sign) != nil {
+			return nil, ErrTokenSign
+		}
+	default:
+		return nil, ErrTokenAlg
+	}
+
+	claims := Claims{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	if err := a.check(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (a *JwtAuth) check(claims Claims) error {
+	leeway := a.Leeway
+	if leeway <= 0 {
+		leeway = DefaultJwtLeeway
+	}
+	now := time.Now()
+
+	exp, ok := claims.time("exp")
+	if !ok {
+		return fmt.Errorf("%w: exp missing", ErrTokenClaims)
+	}
+	if now.After(exp.Add(leeway)) {
+		return ErrTokenExpired
+	}
+	if nbf, ok := claims.time("nbf"); ok && now.Add(leeway).Before(nbf) {
+		return fmt.Errorf("%w: not valid before %v", ErrTokenClaims, nbf)
+	}
+	if a.Issuer != "" && claims["iss"] != a.Issuer {
+		return fmt.Errorf("%w: iss %v", ErrTokenClaims, claims["iss"])
+	}
+	if a.Audience != "" && !containsString(claims.strings("aud"), a.Audience) {
+		return fmt.Errorf("%w: aud %v", ErrTokenClaims, claims["aud"])
+	}
+	return nil
+}
+
+// key returns rsa key of kid, jwks is reloaded if it is expired or kid is unknown.
+func (a *JwtAuth) key(kid string) (*rsa.PublicKey, error) {
+	refresh := a.JwksRefresh
+	if refresh <= 0 {
+		refresh = DefaultJwksRefresh
+	}
+
+	a.lock.Lock()
+	key, ok := a.keys[kid]
+	if ok && time.Since(a.loadedAt) <= refresh {
+		a.lock.Unlock()
+		return key, nil
+	}
+
+	// jwks is reloaded by one request at a time, others use the cached key or wait for it
+	reloading := a.reloading
+	if reloading == nil {
+		if time.Since(a.reloadedAt) <= jwksMinReload {
+			a.lock.Unlock()
+			if ok {
+				return key, nil
+			}
+			return nil, fmt.Errorf("%w: kid %s unknown", ErrTokenSign, kid)
+		}
+
+		reloading = make(chan struct{})
+		a.reloading = reloading
+		a.lock.Unlock()
+
+		err := a.LoadJwks()
+		a.lock.Lock()
+		a.reloading = nil
+		a.lock.Unlock()
+		close(reloading)
+		if err != nil {
+			dlog.Error("jwt auth load jwks occur error:%v", err)
+			if ok {
+				// the cached key is used until jwks is available
+				return key, nil
+			}
+			return nil, fmt.Errorf("%w: load jwks fail", ErrTokenSign)
+		}
+	} else {
+		a.lock.Unlock()
+		if ok {
+			return key, nil
+		}
+		<-reloading
+	}
+
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	if key, ok = a.keys[kid]; !ok {
+		return nil, fmt.Errorf("%w: kid %s unknown", ErrTokenSign, kid)
+	}
+	return key, nil
+}
+
+// LoadJwks loads rsa keys of JwksFile or JwksUrl.
+func (a *JwtAuth) LoadJwks() error {
+	a.lock.Lock()
+	a.reloadedAt = time.Now()
+	a.lock.Unlock()
+
+	var (
+		data []byte
+		err  error
+	)
+	switch {
+	case a.JwksFile != "":
+		data, err = os.ReadFile(a.JwksFile)
+	case a.JwksUrl != "":
+		data, err = a.fetchJwks()
+	default:
+		return errors.New("jwks file or url not set")
+	}
+	if err != nil {
+		return err
+	}
+
+	keys, err := parseJwks(data)
+	if err != nil {
+		return err
+	}
+
+	a.lock.Lock()
+	a.keys = keys
+	a.loadedAt = time.Now()
+	a.lock.Unlock()
+	dlog.Info("jwt auth load %d keys of jwks", len(keys))
+	return nil
+}
+
+func (a *JwtAuth) fetchJwks() ([]byte, error) {
+	client := a.Client
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	rsp, err := client.Get(a.JwksUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get jwks %s status %d", a.JwksUrl, rsp.StatusCode)
+	}
+	return io.ReadAll(rsp.Body)
+}
+
+// parseJwks returns rsa keys of jwks by kid, keys of other types are ignored.
+func parseJwks(data []byte) (map[string]*rsa.PublicKey, error) {
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("jwks key %s n illegal", k.Kid)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("jwks key %s e illegal", k.Kid)
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, nil
+}
+
+// RequireRoles rejects requests whose claims have none of roles.
+func RequireRoles(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, r := range GetClaims(c).Roles() {
+			if containsString(roles, r) {
+				c.Next()
+				return
+			}
+		}
+		forbidden(c, "roles "+strings.Join(roles, ",")+" required")
+	}
+}
+
+// RequireScopes rejects requests whose claims have not all of scopes.
+func RequireScopes(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		granted := GetClaims(c).Scopes()
+		for _, s := range scopes {
+			if !containsString(granted, s) {
+				forbidden(c, "scope "+s+" required")
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+func unauthorized(c *gin.Context, err error) {
+	if err == ErrTokenMissing {
+		c.Header("WWW-Authenticate", "Bearer")
+	} else {
+		c.Header("WWW-Authenticate", fmt.Sprintf("Bearer error=\"invalid_token\", error_description=%q", err.Error()))
+	}
+	abortWith(c, http.StatusUnauthorized, err.Error())
+}
+
+func forbidden(c *gin.Context, message string) {
+	c.Header("WWW-Authenticate", fmt.Sprintf("Bearer error=\"insufficient_scope\", error_description=%q", message))
+	abortWith(c, http.StatusForbidden, message)
+}
+
+func bearerToken(header string) (string, bool) {
+	const prefix = "bearer "
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(header[len(prefix):]), true
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
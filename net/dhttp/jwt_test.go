@@ -0,0 +1,261 @@
+/**
+ * Copyright 2019 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package dhttp
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gdp-org/gd/runtime/gl"
+	"github.com/gin-gonic/gin"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func jwtTestSign(t *testing.T, header, claims map[string]interface{}, hs []byte, key *rsa.PrivateKey) string {
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+
+	var sign []byte
+	if key != nil {
+		digest := sha256.Sum256([]byte(signed))
+		var err error
+		if sign, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:]); err != nil {
+			t.Fatalf("sign occur error:%s", err)
+		}
+	} else {
+		mac := hmac.New(sha256.New, hs)
+		mac.Write([]byte(signed))
+		sign = mac.Sum(nil)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign)
+}
+
+func jwtTestJwks(kid string, key *rsa.PublicKey) string {
+	e := base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	n := base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+	return fmt.Sprintf(`{"keys":[{"kty":"RSA","kid":%q,"use":"sig","n":%q,"e":%q}]}`, kid, n, e)
+}
+
+func TestJwtVerify(t *testing.T) {
+	secret := []byte("secret")
+	a := &JwtAuth{Issuer: "https://auth", Audience: "gd", HmacSecret: secret}
+	exp := float64(time.Now().Add(time.Hour).Unix())
+	hs := map[string]interface{}{"alg": "HS256", "typ": "JWT"}
+
+	claims, err := a.Verify(jwtTestSign(t, hs, map[string]interface{}{"sub": "u1", "iss": "https://auth", "aud": []string{"x", "gd"}, "exp": exp}, secret, nil))
+	if err != nil || claims.Subject() != "u1" {
+		t.Fatalf("unexpected claims %v, err=%v", claims, err)
+	}
+
+	cases := []struct {
+		header map[string]interface{}
+		claims map[string]interface{}
+		secret []byte
+		err    error
+	}{
+		{hs, map[string]interface{}{"iss": "https://auth", "aud": "gd", "exp": exp}, []byte("other"), ErrTokenSign},
+		{map[string]interface{}{"alg": "none"}, map[string]interface{}{"iss": "https://auth", "aud": "gd", "exp": exp}, secret, ErrTokenAlg},
+		{hs, map[string]interface{}{"iss": "https://auth", "aud": "gd", "exp": float64(time.Now().Add(-time.Hour).Unix())}, secret, ErrTokenExpired},
+		{hs, map[string]interface{}{"iss": "https://auth", "aud": "gd"}, secret, ErrTokenClaims},
+		{hs, map[string]interface{}{"iss": "https://other", "aud": "gd", "exp": exp}, secret, ErrTokenClaims},
+		{hs, map[string]interface{}{"iss": "https://auth", "aud": "other", "exp": exp}, secret, ErrTokenClaims},
+	}
+	for i, c := range cases {
+		if _, err := a.Verify(jwtTestSign(t, c.header, c.claims, c.secret, nil)); !errors.Is(err, c.err) {
+			t.Fatalf("case %d unexpected err %v, expect %v", i, err, c.err)
+		}
+	}
+	if _, err := a.Verify("a.b"); err != ErrTokenMalformed {
+		t.Fatalf("unexpected err %v", err)
+	}
+}
+
+func TestJwtJwks(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key occur error:%s", err)
+	}
+
+	var fetches int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.Write([]byte(jwtTestJwks("k1", &key.PublicKey)))
+	}))
+	defer ts.Close()
+
+	a := &JwtAuth{JwksUrl: ts.URL}
+	claims := map[string]interface{}{"sub": "u1", "exp": float64(time.Now().Add(time.Hour).Unix())}
+	token := jwtTestSign(t, map[string]interface{}{"alg": "RS256", "kid": "k1"}, claims, nil, key)
+	for i := 0; i < 3; i++ {
+		if _, err := a.Verify(token); err != nil {
+			t.Fatalf("verify occur error:%s", err)
+		}
+	}
+
+	// unknown kid does not reload jwks again in jwksMinReload
+	if _, err := a.Verify(jwtTestSign(t, map[string]interface{}{"alg": "RS256", "kid": "k2"}, claims, nil, key)); !errors.Is(err, ErrTokenSign) {
+		t.Fatalf("unexpected err %v", err)
+	}
+	if fetches != 1 {
+		t.Fatalf("unexpected fetches %d", fetches)
+	}
+
+	// hs256 is not accepted without secret
+	if _, err := a.Verify(jwtTestSign(t, map[string]interface{}{"alg": "HS256"}, claims, []byte(""), nil)); err != ErrTokenAlg {
+		t.Fatalf("unexpected err %v", err)
+	}
+}
+
+func TestJwtJwksReload(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key occur error:%s", err)
+	}
+
+	var fetches int32
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			<-release
+		}
+		w.Write([]byte(jwtTestJwks("k1", &key.PublicKey)))
+	}))
+	defer ts.Close()
+
+	a := &JwtAuth{JwksUrl: ts.URL}
+	claims := map[string]interface{}{"sub": "u1", "exp": float64(time.Now().Add(time.Hour).Unix())}
+	token := jwtTestSign(t, map[string]interface{}{"alg": "RS256", "kid": "k1"}, claims, nil, key)
+
+	// concurrent requests of unknown kid load jwks once
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := a.Verify(token); err != nil {
+				t.Errorf("verify occur error:%s", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("unexpected fetches %d", n)
+	}
+
+	// cached key is used while expired jwks is reloaded by another request
+	a.lock.Lock()
+	a.loadedAt, a.reloadedAt = time.Now().Add(-time.Hour), time.Now().Add(-time.Hour)
+	a.lock.Unlock()
+	done := make(chan error, 1)
+	go func() {
+		_, err := a.Verify(token)
+		done <- err
+	}()
+	for atomic.LoadInt32(&fetches) != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 8; i++ {
+		if _, err := a.Verify(token); err != nil {
+			t.Fatalf("verify during reload occur error:%s", err)
+		}
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("verify with reload occur error:%s", err)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Fatalf("unexpected fetches %d", n)
+	}
+}
+
+func TestJwtFilter(t *testing.T) {
+	secret := []byte("secret")
+	a := &JwtAuth{HmacSecret: secret}
+	h := &HttpServer{}
+	g := gin.New()
+	r := g.Group("/", a.Filter(), GroupFilter())
+	h.GET(r, "/me", func(c *gin.Context, req *struct{}) (int, string, error, string) {
+		return http.StatusOK, "", nil, GetClaims(c).Subject()
+	})
+	h.DELETE(r, "/user", func(c *gin.Context, req *struct{}) (int, string, error, string) {
+		return http.StatusOK, "", nil, "deleted"
+	}, RequireRoles("admin"))
+	h.GET(r, "/orders", func(c *gin.Context, req *struct{}) (int, string, error, string) {
+		return http.StatusOK, "", nil, "orders"
+	}, RequireScopes("order:read"))
+	for _, register := range []func(*gin.RouterGroup, string, interface{}, ...gin.HandlerFunc){h.PATCH, h.PUT, h.OPTIONS} {
+		register(r, "/user", func(c *gin.Context, req *struct{}) (int, string, error, string) {
+			return http.StatusOK, "", nil, c.Request.Method
+		}, RequireRoles("user"))
+	}
+	h.GET(r, "/gl", func(c *gin.Context, req *struct{}) (int, string, error, interface{}) {
+		sub, _ := gl.Get(gl.AuthSubject)
+		return http.StatusOK, "", nil, sub
+	})
+
+	exp := float64(time.Now().Add(time.Hour).Unix())
+	token := jwtTestSign(t, map[string]interface{}{"alg": "HS256"},
+		map[string]interface{}{"sub": "u1", "exp": exp, "roles": []string{"user"}, "scope": "order:read profile"}, secret, nil)
+
+	do := func(method, path, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do(http.MethodGet, "/me", ""); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Fatalf("unexpected response %d %v", w.Code, w.Header())
+	}
+	if w := do(http.MethodGet, "/me", "Bearer x.y.z"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("unexpected response %d", w.Code)
+	}
+	w := do(http.MethodGet, "/me", "Bearer "+token)
+	var ret map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &ret)
+	if w.Code != http.StatusOK || ret["result"] != "u1" {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodDelete, "/user", "Bearer "+token); w.Code != http.StatusForbidden {
+		t.Fatalf("unexpected response %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/orders", "bearer "+token); w.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d", w.Code)
+	}
+
+	for _, method := range []string{http.MethodPatch, http.MethodPut, http.MethodOptions} {
+		w = do(method, "/user", "Bearer "+token)
+		ret = nil
+		json.Unmarshal(w.Body.Bytes(), &ret)
+		if w.Code != http.StatusOK || ret["result"] != method {
+			t.Fatalf("unexpected %s response %d %s", method, w.Code, w.Body.String())
+		}
+	}
+
+	// subject is set in gl without GlFilter
+	w = do(http.MethodGet, "/gl", "Bearer "+token)
+	ret = nil
+	json.Unmarshal(w.Body.Bytes(), &ret)
+	if w.Code != http.StatusOK || ret["result"] != "u1" {
+		t.Fatalf("unexpected gl response %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,391 @@
+/**
+ * Copyright 2019 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package dhttp
+
+import (
+	"github.com/gdp-org/gd/utls/validate"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * OpenAPI 3 document of handlers registered by HttpServer.Handle, GET, POST, etc. request
+ * schema is reflected from the second param of handler, query params of GET by form tags and
+ * json body of others by json tags, and response schema is the {code, message, result} of
+ * JsonEnvelope, or the result of other envelopes, which is reflected from the fourth return
+ * value of handler.
+ * fields with binding:"required" or validate:"required" are required. operationId is the name of
+ * handler, or method and path of route, e.g. getApiUserById, if handler is anonymous or its name is
+ * used by another route.
+ *
+ * the document is served at OpenApiPath if it is set.
+ */
+
+const (
+	DefaultOpenApiTitle   = "gd"
+	DefaultOpenApiVersion = "1.0.0"
+)
+
+type OpenApiDoc struct {
+	OpenApi    string                                  `json:"openapi"`
+	Info       OpenApiInfo                             `json:"info"`
+	Paths      map[string]map[string]*OpenApiOperation `json:"paths"`
+	Components OpenApiComponents                       `json:"components"`
+}
+
+type OpenApiInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type OpenApiComponents struct {
+	Schemas map[string]*OpenApiSchema `json:"schemas,omitempty"`
+}
+
+type OpenApiOperation struct {
+	OperationId string                      `json:"operationId,omitempty"`
+	Parameters  []*OpenApiParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenApiRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*OpenApiResponse `json:"responses"`
+}
+
+type OpenApiParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required,omitempty"`
+	Schema   *OpenApiSchema `json:"schema"`
+}
+
+type OpenApiRequestBody struct {
+	Required bool                         `json:"required,omitempty"`
+	Content  map[string]*OpenApiMediaType `json:"content"`
+}
+
+type OpenApiResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*OpenApiMediaType `json:"content,omitempty"`
+}
+
+type OpenApiMediaType struct {
+	Schema *OpenApiSchema `json:"schema"`
+}
+
+type OpenApiSchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Properties           map[string]*OpenApiSchema `json:"properties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	Items                *OpenApiSchema            `json:"items,omitempty"`
+	AdditionalProperties *OpenApiSchema            `json:"additionalProperties,omitempty"`
+}
+
+// route is a handler registered by HttpServer.
+type route struct {
+	method  string
+	path    string
+	handler interface{}
+}
+
+var (
+	ginParam      = regexp.MustCompile(`[:*]([^/]+)`)
+	componentName = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+	operationName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	anonymousName = regexp.MustCompile(`^func[0-9]+$`)
+	nonAlnum      = regexp.MustCompile(`[^a-zA-Z0-9]`)
+	timeType      = reflect.TypeOf(time.Time{})
+	bytesType     = reflect.TypeOf([]byte(nil))
+)
+
+// OpenApi returns the document of registered handlers.
+func (h *HttpServer) OpenApi() *OpenApiDoc {
+	title := h.OpenApiTitle
+	if title == "" {
+		title = DefaultOpenApiTitle
+	}
+
+	doc := &OpenApiDoc{
+		OpenApi:    "3.0.3",
+		Info:       OpenApiInfo{Title: title, Version: DefaultOpenApiVersion},
+		Paths:      make(map[string]map[string]*OpenApiOperation),
+		Components: OpenApiComponents{Schemas: make(map[string]*OpenApiSchema)},
+	}
+
+	g := &schemaGenerator{schemas: doc.Components.Schemas, names: make(map[reflect.Type]string), ids: make(map[string]bool), envelope: h.Envelope}
+	for _, r := range h.routes {
+		if CheckWrap(r.handler) != nil {
+			continue
+		}
+
+		p := ginParam.ReplaceAllString(r.path, "{$1}")
+		if doc.Paths[p] == nil {
+			doc.Paths[p] = make(map[string]*OpenApiOperation)
+		}
+		doc.Paths[p][strings.ToLower(r.method)] = g.operation(r)
+	}
+	return doc
+}
+
+func (h *HttpServer) serveOpenApi(c *gin.Context) {
+	c.JSON(http.StatusOK, h.OpenApi())
+}
+
+// addRoute records handler registered on group.
+func (h *HttpServer) addRoute(group *gin.RouterGroup, method, relativePath string, handler interface{}) {
+	h.routes = append(h.routes, route{method: method, path: routePath(group, relativePath), handler: handler})
+}
+
+// routePath returns full path of route on group, which is joined like gin.
+func routePath(group *gin.RouterGroup, relativePath string) string {
+	p := path.Join(group.BasePath(), relativePath)
+	if strings.HasSuffix(relativePath, "/") && !strings.HasSuffix(p, "/") {
+		p += "/"
+	}
+	return p
+}
+
+type schemaGenerator struct {
+	schemas  map[string]*OpenApiSchema
+	names    map[reflect.Type]string
+	ids      map[string]bool
+	envelope Envelope
+}
+
+func (g *schemaGenerator) operation(r route) *OpenApiOperation {
+	wt := reflect.TypeOf(r.handler)
+	op := &OpenApiOperation{
+		OperationId: g.operationId(r),
+		Responses: map[string]*OpenApiResponse{
+			"200": {
+				Description: "OK",
+				Content: map[string]*OpenApiMediaType{
+					"application/json": {Schema: g.response(wt.Out(3))},
+				},
+			},
+		},
+	}
+
+	for _, m := range ginParam.FindAllStringSubmatch(r.path, -1) {
+		op.Parameters = append(op.Parameters, &OpenApiParameter{Name: m[1], In: "path", Required: true, Schema: &OpenApiSchema{Type: "string"}})
+	}
+
+	in := wt.In(1)
+	if r.method == http.MethodGet {
+		op.Parameters = append(op.Parameters, g.queryParameters(in)...)
+	} else {
+		op.RequestBody = &OpenApiRequestBody{
+			Required: true,
+			Content:  map[string]*OpenApiMediaType{"application/json": {Schema: g.schema(in)}},
+		}
+	}
+	return op
+}
+
+// response returns schema of body built by envelope, it is result itself if envelope is not JsonEnvelope.
+func (g *schemaGenerator) response(result reflect.Type) *OpenApiSchema {
+	e := g.envelope
+	if e == nil {
+		e = DefaultEnvelope
+	}
+	je, ok := e.(*JsonEnvelope)
+	if !ok {
+		return g.schema(result)
+	}
+
+	return &OpenApiSchema{
+		Type: "object",
+		Properties: map[string]*OpenApiSchema{
+			fieldOr(je.CodeField, "code"):       {Type: "integer"},
+			fieldOr(je.MessageField, "message"): {Type: "string"},
+			fieldOr(je.ResultField, "result"):   g.schema(result),
+		},
+	}
+}
+
+// queryParameters returns params of fields of struct t by form tags, as gin binds query.
+func (g *schemaGenerator) queryParameters(t reflect.Type) []*OpenApiParameter {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var params []*OpenApiParameter
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Tag.Get("form") == "" {
+			params = append(params, g.queryParameters(f.Type)...)
+			continue
+		}
+
+		name := tagName(f, "form")
+		if name == "" {
+			continue
+		}
+		params = append(params, &OpenApiParameter{
+			Name:     name,
+			In:       "query",
+			Required: isRequired(f),
+			Schema:   g.schema(f.Type),
+		})
+	}
+	return params
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) *OpenApiSchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return &OpenApiSchema{Type: "string", Format: "date-time"}
+	case bytesType:
+		return &OpenApiSchema{Type: "string", Format: "byte"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &OpenApiSchema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &OpenApiSchema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return &OpenApiSchema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &OpenApiSchema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &OpenApiSchema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &OpenApiSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		return &OpenApiSchema{Type: "array", Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return &OpenApiSchema{Type: "object", AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		return &OpenApiSchema{Ref: "#/components/schemas/" + g.component(t)}
+	}
+	// interface and others are any value
+	return &OpenApiSchema{}
+}
+
+// component returns name of struct t in components, which is added at the first time.
+func (g *schemaGenerator) component(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+
+	name := componentName.ReplaceAllString(t.Name(), "_")
+	if name == "" {
+		name = "Anonymous"
+	}
+	if _, ok := g.schemas[name]; ok {
+		name = componentName.ReplaceAllString(path.Base(t.PkgPath()), "_") + "." + name
+	}
+	for i, base := 2, name; g.schemas[name] != nil; i++ {
+		name = base + "_" + strconv.Itoa(i)
+	}
+
+	s := &OpenApiSchema{Type: "object", Properties: make(map[string]*OpenApiSchema)}
+	// registered before fields, so that recursive types refer to it
+	g.names[t] = name
+	g.schemas[name] = s
+	g.fields(t, s)
+	return name
+}
+
+// fields adds fields of struct t to s by json tags, fields of embedded structs are flattened.
+func (g *schemaGenerator) fields(t reflect.Type, s *OpenApiSchema) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && f.Tag.Get("json") == "" && ft.Kind() == reflect.Struct {
+			g.fields(ft, s)
+			continue
+		}
+
+		name := tagName(f, "json")
+		if name == "" {
+			continue
+		}
+		s.Properties[name] = g.schema(f.Type)
+		if isRequired(f) {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+// tagName returns field name of tag, "" if field is skipped.
+func tagName(f reflect.StructField, tag string) string {
+	if f.PkgPath != "" && !f.Anonymous {
+		return ""
+	}
+
+	v := f.Tag.Get(tag)
+	if v == "-" {
+		return ""
+	}
+
+	if name := strings.Split(v, ",")[0]; name != "" {
+		return name
+	}
+	return f.Name
+}
+
+func isRequired(f reflect.StructField) bool {
+	for _, tag := range []string{"binding", validate.Tag} {
+		for _, rule := range strings.Split(f.Tag.Get(tag), ",") {
+			if rule == "required" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// operationId returns name of handler, or method and path of route if handler is anonymous or its
+// name is used by another route, as operationId must be unique.
+func (g *schemaGenerator) operationId(r route) string {
+	id := strings.TrimSuffix(funcName(r.handler), "-fm")
+	if !operationName.MatchString(id) || anonymousName.MatchString(id) || g.ids[id] {
+		id = strings.ToLower(r.method)
+		for _, seg := range strings.Split(r.path, "/") {
+			if strings.HasPrefix(seg, ":") || strings.HasPrefix(seg, "*") {
+				id += "By"
+			}
+			if seg = nonAlnum.ReplaceAllString(seg, ""); seg != "" {
+				id += strings.ToUpper(seg[:1]) + seg[1:]
+			}
+		}
+		for base, n := id, 2; g.ids[id]; n++ {
+			id = base + strconv.Itoa(n)
+		}
+	}
+	g.ids[id] = true
+	return id
+}
+
+func funcName(f interface{}) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer())
+	if fn == nil {
+		return ""
+	}
+	name := fn.Name()
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
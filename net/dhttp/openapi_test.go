@@ -0,0 +1,105 @@
+/**
+ * Copyright 2019 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package dhttp
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type openApiPage struct {
+	Offset int `form:"offset" json:"offset"`
+}
+
+type openApiQuery struct {
+	openApiPage
+	Name string `form:"name" binding:"required"`
+}
+
+type openApiUser struct {
+	Name    string         `json:"name" binding:"required"`
+	Tags    []string       `json:"tags,omitempty"`
+	Friends []*openApiUser `json:"friends"`
+	secret  string
+}
+
+func openApiGetUser(c *gin.Context, req *openApiQuery) (int, string, error, *openApiUser) {
+	return http.StatusOK, "ok", nil, nil
+}
+
+func openApiAddUser(c *gin.Context, req openApiUser) (int, string, error, []openApiUser) {
+	return http.StatusOK, "ok", nil, nil
+}
+
+func TestOpenApi(t *testing.T) {
+	h := &HttpServer{OpenApiPath: "/openapi.json"}
+	h.HttpServerInit = func(g *gin.Engine) error {
+		r := g.Group("/api")
+		h.GET(r, "/user/:id", openApiGetUser)
+		h.POST(r, "/user", openApiAddUser)
+		h.PUT(r, "/user/:id", openApiAddUser)
+		h.GET(r, "/user/:id/friends", func(c *gin.Context, req *openApiPage) (int, string, error, []openApiUser) {
+			return http.StatusOK, "ok", nil, nil
+		})
+		return nil
+	}
+	if err := h.initGin(); err != nil {
+		t.Fatalf("init gin occur error:%s", err)
+	}
+
+	w := httptest.NewRecorder()
+	h.g.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", w.Code)
+	}
+
+	var doc OpenApiDoc
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("unmarshal doc occur error:%s", err)
+	}
+
+	get := doc.Paths["/api/user/{id}"]["get"]
+	if get == nil || get.OperationId != "openApiGetUser" || len(get.Parameters) != 3 {
+		t.Fatalf("unexpected get operation %+v", get)
+	}
+	if p := get.Parameters[0]; p.Name != "id" || p.In != "path" || !p.Required {
+		t.Fatalf("unexpected path param %+v", p)
+	}
+	if p := get.Parameters[1]; p.Name != "offset" || p.In != "query" || p.Required {
+		t.Fatalf("unexpected embedded query param %+v", p)
+	}
+	if p := get.Parameters[2]; p.Name != "name" || !p.Required || p.Schema.Type != "string" {
+		t.Fatalf("unexpected query param %+v", p)
+	}
+
+	post := doc.Paths["/api/user"]["post"]
+	if post == nil || post.RequestBody.Content["application/json"].Schema.Ref != "#/components/schemas/openApiUser" {
+		t.Fatalf("unexpected post operation %+v", post)
+	}
+	result := post.Responses["200"].Content["application/json"].Schema.Properties["result"]
+	if result.Type != "array" || result.Items.Ref != "#/components/schemas/openApiUser" {
+		t.Fatalf("unexpected result schema %+v", result)
+	}
+
+	// operationId is unique
+	if put := doc.Paths["/api/user/{id}"]["put"]; put == nil || post.OperationId != "openApiAddUser" || put.OperationId != "putApiUserById" {
+		t.Fatalf("unexpected operation id of duplicate handler %+v", put)
+	}
+	if friends := doc.Paths["/api/user/{id}/friends"]["get"]; friends == nil || friends.OperationId != "getApiUserByIdFriends" {
+		t.Fatalf("unexpected operation id of anonymous handler %+v", friends)
+	}
+
+	user := doc.Components.Schemas["openApiUser"]
+	if user == nil || len(user.Properties) != 3 || len(user.Required) != 1 || user.Required[0] != "name" {
+		t.Fatalf("unexpected user schema %+v", user)
+	}
+	if friends := user.Properties["friends"]; friends.Items.Ref != "#/components/schemas/openApiUser" {
+		t.Fatalf("unexpected recursive schema %+v", friends)
+	}
+}
@@ -0,0 +1,122 @@
+/**
+ * Copyright 2019 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package dhttp
+
+import (
+	"context"
+	"fmt"
+	"github.com/gdp-org/gd/dlog"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"net/http"
+)
+
+/*
+ * protocols of HttpServer besides http/1.1 and https with http/2:
+ *
+ * h2c:   http/2 without tls, e.g. for internal gateways, when H2c is set. it is rejected with https,
+ *        which serves http/2 by tls.
+ * http3: quic listener on udp Http3Port alongside https, which is created by Http3, e.g. of quic-go.
+ *        Http3 must be set by code, Http3Port is ignored with a warning if it is not.
+ *
+ * e.HttpServer.Http3 = func(addr string, handler http.Handler) dhttp.Http3Server {
+ *     return &http3.Server{Addr: addr, Handler: handler}
+ * }
+ *
+ * responses of https advertise http3 by Alt-Svc. they are enabled by [Server] section of conf.ini:
+ *
+ * httpH2c       = true
+ * httpsCertFile = "conf/server.crt"
+ * httpsKeyFile  = "conf/server.key"
+ * httpHttp3Port = 10243
+ *
+ * both are shut down in Close. h2c connections are sent GOAWAY and waited like websocket, http3
+ * server is closed at once if it has no Shutdown.
+ */
+
+// Http3Server serves http3 over quic.
+type Http3Server interface {
+	ListenAndServeTLS(certFile, keyFile string) error
+	Close() error
+}
+
+// Http3ServerMaker creates http3 server listening on udp addr.
+type Http3ServerMaker func(addr string, handler http.Handler) Http3Server
+
+// makeH2c serves http/2 without tls by s, http/1.1 requests are served as before. http2 server is
+// configured to s, so that Shutdown sends GOAWAY to h2c connections, which are hijacked from s and
+// waited by waitStreams.
+func (h *HttpServer) makeH2c(s *http.Server) error {
+	h2s := &http2.Server{}
+	if err := http2.ConfigureServer(s, h2s); err != nil {
+		return err
+	}
+
+	handler := h2c.NewHandler(s.Handler, h2s)
+	s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// requests of h2c connection are served by h2s, only the connection itself gets here
+		if r.ProtoMajor == 2 || r.Header.Get("Upgrade") == "h2c" {
+			h.streams.Add(1)
+			defer h.streams.Done()
+		}
+		handler.ServeHTTP(w, r)
+	})
+	return nil
+}
+
+// altSvcHandler advertises http3 on port by Alt-Svc of responses.
+func altSvcHandler(handler http.Handler, port int) http.Handler {
+	altSvc := fmt.Sprintf(`h3=":%d"; ma=86400`, port)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor < 3 {
+			w.Header().Set("Alt-Svc", altSvc)
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func (h *HttpServer) makeHttp3Server() error {
+	if !h.UseHttps {
+		return fmt.Errorf("http3 port %d needs https", h.Http3Port)
+	}
+	if h.Http3 == nil {
+		dlog.Warn("http3 port %d is ignored, Http3 of HttpServer must be set by code", h.Http3Port)
+		return nil
+	}
+
+	addr := fmt.Sprintf("%s:%d", h.HttpServerRunAddr, h.Http3Port)
+	h.server.Handler = altSvcHandler(h.server.Handler, h.Http3Port)
+	h.h3 = h.Http3(addr, h.server.Handler)
+	return nil
+}
+
+func (h *HttpServer) startHttp3() {
+	dlog.Info("http server start http3 on udp port %d", h.Http3Port)
+	go func() {
+		err := h.h3.ListenAndServeTLS(h.HttpsCertFile, h.HttpsKeyFile)
+		if err != nil && err != http.ErrServerClosed {
+			dlog.Error("http3 server serve occur error:%v", err)
+		}
+	}()
+}
+
+func (h *HttpServer) closeHttp3(ctx context.Context) {
+	if h.h3 == nil {
+		return
+	}
+
+	var err error
+	if s, ok := h.h3.(interface{ Shutdown(context.Context) error }); ok {
+		err = s.Shutdown(ctx)
+	} else {
+		err = h.h3.Close()
+	}
+	if err != nil {
+		dlog.Error("http3 server shutdown fail,port=%d,err=%v", h.Http3Port, err)
+		return
+	}
+	dlog.Info("http3 server shutdown %d", h.Http3Port)
+}
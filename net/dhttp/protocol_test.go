@@ -0,0 +1,194 @@
+/**
+ * Copyright 2019 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package dhttp
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/http2"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeHttp3Server struct {
+	addr     string
+	handler  http.Handler
+	serving  chan struct{}
+	shutdown chan struct{}
+}
+
+func (s *fakeHttp3Server) ListenAndServeTLS(certFile, keyFile string) error {
+	close(s.serving)
+	<-s.shutdown
+	return http.ErrServerClosed
+}
+
+func (s *fakeHttp3Server) Close() error {
+	return nil
+}
+
+func (s *fakeHttp3Server) Shutdown(ctx context.Context) error {
+	close(s.shutdown)
+	return nil
+}
+
+func protocolTestServer() *HttpServer {
+	return &HttpServer{
+		HttpServerShutdownTimeout: 1,
+		HttpServerInit: func(g *gin.Engine) error {
+			g.GET("/proto", func(c *gin.Context) {
+				c.String(http.StatusOK, c.Request.Proto)
+			})
+			return nil
+		},
+	}
+}
+
+func TestH2c(t *testing.T) {
+	h := protocolTestServer()
+	h.H2c = true
+	if err := h.makeHttpServer(); err != nil {
+		t.Fatalf("make http server occur error:%s", err)
+	}
+	ts := httptest.NewServer(h.server.Handler)
+	defer ts.Close()
+
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	rsp, err := client.Get(ts.URL + "/proto")
+	if err != nil {
+		t.Fatalf("get occur error:%s", err)
+	}
+	rsp.Body.Close()
+	if rsp.ProtoMajor != 2 {
+		t.Fatalf("unexpected proto %s", rsp.Proto)
+	}
+
+	// http/1.1 is still served
+	if rsp, err = http.Get(ts.URL + "/proto"); err != nil || rsp.ProtoMajor != 1 {
+		t.Fatalf("unexpected http/1.1 response %v, err=%v", rsp, err)
+	}
+	rsp.Body.Close()
+
+	h = protocolTestServer()
+	h.H2c, h.UseHttps = true, true
+	if err := h.makeHttpServer(); err == nil {
+		t.Fatal("expect error of h2c with https")
+	}
+}
+
+func TestH2cShutdown(t *testing.T) {
+	started, finished := make(chan struct{}), make(chan struct{})
+	h := protocolTestServer()
+	h.H2c = true
+	h.HttpServerInit = func(g *gin.Engine) error {
+		g.GET("/slow", func(c *gin.Context) {
+			close(started)
+			time.Sleep(200 * time.Millisecond)
+			c.String(http.StatusOK, "done")
+			close(finished)
+		})
+		return nil
+	}
+	if err := h.makeHttpServer(); err != nil {
+		t.Fatalf("make http server occur error:%s", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen occur error:%s", err)
+	}
+	go h.server.Serve(ln)
+
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	done := make(chan string, 1)
+	go func() {
+		rsp, err := client.Get("http://" + ln.Addr().String() + "/slow")
+		if err != nil {
+			done <- err.Error()
+			return
+		}
+		defer rsp.Body.Close()
+		body, _ := io.ReadAll(rsp.Body)
+		done <- string(body)
+	}()
+
+	// Close waits for the running h2c request
+	<-started
+	h.Close()
+	select {
+	case <-finished:
+	default:
+		t.Fatal("close returns before h2c request finished")
+	}
+	select {
+	case body := <-done:
+		if body != "done" {
+			t.Fatalf("unexpected h2c response %s", body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("h2c request not answered")
+	}
+}
+
+func TestHttp3(t *testing.T) {
+	h := protocolTestServer()
+	h.Http3Port = 8443
+	if err := h.makeHttpServer(); err == nil {
+		t.Fatal("expect error of http3 without https")
+	}
+
+	// http3 port is ignored without Http3
+	h = protocolTestServer()
+	h.UseHttps, h.HttpsCertFile, h.HttpsKeyFile = true, "cert", "key"
+	h.Http3Port = 8443
+	if err := h.makeHttpServer(); err != nil || h.h3 != nil {
+		t.Fatalf("unexpected http3 server %v, err=%v", h.h3, err)
+	}
+
+	fake := &fakeHttp3Server{serving: make(chan struct{}), shutdown: make(chan struct{})}
+	h = protocolTestServer()
+	h.UseHttps, h.HttpsCertFile, h.HttpsKeyFile = true, "cert", "key"
+	h.Http3Port = 8443
+	h.Http3 = func(addr string, handler http.Handler) Http3Server {
+		fake.addr, fake.handler = addr, handler
+		return fake
+	}
+	if err := h.makeHttpServer(); err != nil {
+		t.Fatalf("make http server occur error:%s", err)
+	}
+	if fake.addr != ":8443" || fake.handler == nil {
+		t.Fatalf("unexpected http3 server %s", fake.addr)
+	}
+
+	w := httptest.NewRecorder()
+	h.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/proto", nil))
+	if alt := w.Header().Get("Alt-Svc"); alt != `h3=":8443"; ma=86400` {
+		t.Fatalf("unexpected Alt-Svc %s", alt)
+	}
+
+	h.startHttp3()
+	<-fake.serving
+	h.Close()
+	select {
+	case <-fake.shutdown:
+	default:
+		t.Fatal("http3 server not shutdown")
+	}
+}
@@ -0,0 +1,327 @@
+/**
+ * Copyright 2019 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package dhttp
+
+import (
+	"context"
+	"fmt"
+	"github.com/gdp-org/gd/dlog"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/ini.v1"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * built-in filters of HttpServer, they are used before all routes when HttpServer.Filters is set,
+ * and can be used on groups by themselves, e.g. r := g.Group("/api", dhttp.Cors(conf)).
+ *
+ * they are enabled by [Server] section of conf.ini:
+ *
+ * httpCorsOrigins       = "https://a.example.com,https://*.example.com"
+ * httpCorsMethods       = "GET,POST"
+ * httpCorsHeaders       = "Content-Type,Authorization"
+ * httpCorsExposeHeaders = "X-Request-Id"
+ * httpCorsCredentials   = true
+ * httpCorsMaxAge        = 600
+ * httpMaxBodyBytes      = 1048576
+ * httpHsts              = 31536000
+ * httpHstsSubdomains    = true
+ * httpCsp               = "default-src 'self'"
+ * httpFrameOptions      = "DENY"
+ * httpRequestTimeout    = 5
+ *
+ * cors origins may be "*", or have a wildcard subdomain. "*" can not be used with credentials, or any
+ * site could send credentialed requests, so such conf is rejected. cors headers are those requested by
+ * preflight if httpCorsHeaders is empty. timeouts are in seconds, sse and websocket routes are
+ * not limited by httpRequestTimeout.
+ */
+
+const (
+	defaultCorsMethods = "GET,POST,PUT,PATCH,DELETE,HEAD"
+)
+
+type FilterConf struct {
+	Cors *CorsConf
+	// MaxBodyBytes rejects requests whose body is larger than it with 413
+	MaxBodyBytes int64
+	Security     *SecurityConf
+	// RequestTimeout cancels context of request after it
+	RequestTimeout time.Duration
+}
+
+type CorsConf struct {
+	AllowOrigins     []string
+	AllowMethods     []string
+	AllowHeaders     []string
+	ExposeHeaders    []string
+	AllowCredentials bool
+	// MaxAge is how long results of preflight are cached by browser, in seconds
+	MaxAge int
+}
+
+type SecurityConf struct {
+	// HstsMaxAge sets Strict-Transport-Security if it is positive, in seconds
+	HstsMaxAge            int
+	HstsIncludeSubdomains bool
+	ContentSecurityPolicy string
+	FrameOptions          string
+}
+
+// NewFilterConfFromSection creates FilterConf with http keys of sec, it returns nil if no filter is enabled.
+func NewFilterConfFromSection(sec *ini.Section) (*FilterConf, error) {
+	f := &FilterConf{}
+	cors := &CorsConf{}
+	security := &SecurityConf{}
+	for _, k := range sec.Keys() {
+		var err error
+		switch k.Name() {
+		case "httpCorsOrigins":
+			cors.AllowOrigins = splitList(k.String())
+		case "httpCorsMethods":
+			cors.AllowMethods = splitList(strings.ToUpper(k.String()))
+		case "httpCorsHeaders":
+			cors.AllowHeaders = splitList(k.String())
+		case "httpCorsExposeHeaders":
+			cors.ExposeHeaders = splitList(k.String())
+		case "httpCorsCredentials":
+			cors.AllowCredentials, err = k.Bool()
+		case "httpCorsMaxAge":
+			cors.MaxAge, err = k.Int()
+		case "httpMaxBodyBytes":
+			f.MaxBodyBytes, err = k.Int64()
+		case "httpHsts":
+			security.HstsMaxAge, err = k.Int()
+		case "httpHstsSubdomains":
+			security.HstsIncludeSubdomains, err = k.Bool()
+		case "httpCsp":
+			security.ContentSecurityPolicy = k.String()
+		case "httpFrameOptions":
+			security.FrameOptions = k.String()
+		case "httpRequestTimeout":
+			var timeout int
+			timeout, err = k.Int()
+			f.RequestTimeout = time.Duration(timeout) * time.Second
+		}
+		if err != nil {
+			return nil, fmt.Errorf("conf key %s %s illegal", k.Name(), k.String())
+		}
+	}
+
+	if len(cors.AllowOrigins) > 0 {
+		if err := cors.validate(); err != nil {
+			return nil, err
+		}
+		f.Cors = cors
+	}
+	if *security != (SecurityConf{}) {
+		f.Security = security
+	}
+	if f.Cors == nil && f.Security == nil && f.MaxBodyBytes <= 0 && f.RequestTimeout <= 0 {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// Handlers returns the enabled filters, cors is the first one so that preflight is answered before others.
+func (f *FilterConf) Handlers() []gin.HandlerFunc {
+	var handlers []gin.HandlerFunc
+	if f.Cors != nil {
+		handlers = append(handlers, Cors(f.Cors))
+	}
+	if f.Security != nil {
+		handlers = append(handlers, SecurityHeaders(f.Security))
+	}
+	if f.MaxBodyBytes > 0 {
+		handlers = append(handlers, MaxBodyBytes(f.MaxBodyBytes))
+	}
+	if f.RequestTimeout > 0 {
+		handlers = append(handlers, RequestTimeout(f.RequestTimeout))
+	}
+	return handlers
+}
+
+func (conf *CorsConf) validate() error {
+	if conf.AllowCredentials && containsString(conf.AllowOrigins, "*") {
+		return fmt.Errorf("cors origin * is not allowed with credentials")
+	}
+	return nil
+}
+
+// Cors answers preflight requests and sets cors headers of requests from allowed origins,
+// it panics if conf allows origin "*" with credentials.
+func Cors(conf *CorsConf) gin.HandlerFunc {
+	if err := conf.validate(); err != nil {
+		panic(err)
+	}
+	methods := conf.AllowMethods
+	if len(methods) == 0 {
+		methods = splitList(defaultCorsMethods)
+	}
+	allowMethods := strings.Join(methods, ", ")
+	allowHeaders := strings.Join(conf.AllowHeaders, ", ")
+	exposeHeaders := strings.Join(conf.ExposeHeaders, ", ")
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		header := c.Writer.Header()
+		header.Add("Vary", "Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		allowed, wildcard := allowOrigin(conf.AllowOrigins, origin)
+		if !allowed {
+			if preflight {
+				abortWith(c, http.StatusForbidden, "cors origin "+origin+" not allowed")
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if wildcard {
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			header.Set("Access-Control-Allow-Origin", origin)
+		}
+		if conf.AllowCredentials && !wildcard {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if exposeHeaders != "" {
+				header.Set("Access-Control-Expose-Headers", exposeHeaders)
+			}
+			c.Next()
+			return
+		}
+
+		method := strings.ToUpper(c.GetHeader("Access-Control-Request-Method"))
+		if !containsString(methods, method) {
+			abortWith(c, http.StatusForbidden, "cors method "+method+" not allowed")
+			return
+		}
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+		header.Set("Access-Control-Allow-Methods", allowMethods)
+		if allowHeaders != "" {
+			header.Set("Access-Control-Allow-Headers", allowHeaders)
+		} else if requested := c.GetHeader("Access-Control-Request-Headers"); requested != "" {
+			header.Set("Access-Control-Allow-Headers", requested)
+		}
+		if conf.MaxAge > 0 {
+			header.Set("Access-Control-Max-Age", strconv.Itoa(conf.MaxAge))
+		}
+		c.Set(Code, http.StatusNoContent)
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
+
+// allowOrigin returns whether origin is allowed, and whether it is allowed by "*".
+func allowOrigin(origins []string, origin string) (bool, bool) {
+	for _, o := range origins {
+		switch {
+		case o == "*":
+			return true, true
+		case strings.EqualFold(o, origin):
+			return true, false
+		case strings.Contains(o, "://*."):
+			// https://*.example.com allows https://a.example.com, but not https://example.com
+			i := strings.Index(o, "*")
+			if len(origin) > len(o)-1 && strings.EqualFold(origin[:i], o[:i]) &&
+				strings.HasSuffix(strings.ToLower(origin), strings.ToLower(o[i+1:])) {
+				return true, false
+			}
+		}
+	}
+	return false, false
+}
+
+// SecurityHeaders sets security headers of responses, X-Content-Type-Options is always nosniff.
+func SecurityHeaders(conf *SecurityConf) gin.HandlerFunc {
+	var hsts string
+	if conf.HstsMaxAge > 0 {
+		hsts = fmt.Sprintf("max-age=%d", conf.HstsMaxAge)
+		if conf.HstsIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+	}
+
+	return func(c *gin.Context) {
+		header := c.Writer.Header()
+		header.Set("X-Content-Type-Options", "nosniff")
+		if hsts != "" {
+			header.Set("Strict-Transport-Security", hsts)
+		}
+		if conf.ContentSecurityPolicy != "" {
+			header.Set("Content-Security-Policy", conf.ContentSecurityPolicy)
+		}
+		if conf.FrameOptions != "" {
+			header.Set("X-Frame-Options", conf.FrameOptions)
+		}
+		c.Next()
+	}
+}
+
+// MaxBodyBytes rejects requests whose body is larger than n with 413, body of unknown length
+// fails to be read after n bytes.
+func MaxBodyBytes(n int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > n {
+			abortWith(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body is larger than %d bytes", n))
+			return
+		}
+		if c.Request.Body != nil {
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, n)
+		}
+		c.Next()
+	}
+}
+
+// RequestTimeout cancels context of request after timeout, handlers should pass c.Request.Context()
+// to calls which may block. sse and websocket routes of HttpServer are not limited, as Stream is set
+// for them before filters.
+func RequestTimeout(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetBool(Stream) {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		if ctx.Err() == context.DeadlineExceeded {
+			dlog.Warn("request %s %s exceeds timeout %v", c.Request.Method, c.Request.URL.Path, timeout)
+		}
+	}
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
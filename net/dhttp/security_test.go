@@ -0,0 +1,223 @@
+/**
+ * Copyright 2019 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package dhttp
+
+import (
+	"github.com/gin-gonic/gin"
+	"gopkg.in/ini.v1"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func securityTestServe(f *FilterConf) *gin.Engine {
+	g := gin.New()
+	g.Use(f.Handlers()...)
+	r := g.Group("/", GroupFilter())
+	r.POST("/echo", func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.Set(Code, http.StatusRequestEntityTooLarge)
+			c.Set(Ret, err.Error())
+			return
+		}
+		c.Set(Code, http.StatusOK)
+		c.Set(Ret, string(body))
+	})
+	r.GET("/slow", func(c *gin.Context) {
+		select {
+		case <-c.Request.Context().Done():
+			c.Set(Code, http.StatusGatewayTimeout)
+		case <-time.After(time.Second):
+			c.Set(Code, http.StatusOK)
+		}
+		c.Set(Ret, nil)
+	})
+	return g
+}
+
+func TestNewFilterConfFromSection(t *testing.T) {
+	cfg, err := ini.Load([]byte(`
+[Server]
+httpPort = 10240
+httpCorsOrigins = "https://a.example.com, https://*.b.com"
+httpCorsCredentials = true
+httpMaxBodyBytes = 16
+httpFrameOptions = "DENY"
+httpRequestTimeout = 5
+`))
+	if err != nil {
+		t.Fatalf("load conf occur error:%s", err)
+	}
+	f, err := NewFilterConfFromSection(cfg.Section("Server"))
+	if err != nil {
+		t.Fatalf("new filter conf occur error:%s", err)
+	}
+	if len(f.Cors.AllowOrigins) != 2 || !f.Cors.AllowCredentials || f.MaxBodyBytes != 16 ||
+		f.Security.FrameOptions != "DENY" || f.RequestTimeout != 5*time.Second {
+		t.Fatalf("unexpected filter conf %+v", f)
+	}
+	if len(f.Handlers()) != 4 {
+		t.Fatalf("unexpected handlers %d", len(f.Handlers()))
+	}
+
+	cfg, _ = ini.Load([]byte("[Server]\nhttpPort = 10240\n"))
+	if f, err = NewFilterConfFromSection(cfg.Section("Server")); f != nil || err != nil {
+		t.Fatalf("unexpected filter conf %+v, err=%v", f, err)
+	}
+	cfg, _ = ini.Load([]byte("[Server]\nhttpMaxBodyBytes = big\n"))
+	if _, err = NewFilterConfFromSection(cfg.Section("Server")); err == nil {
+		t.Fatal("expect error of illegal conf")
+	}
+	cfg, _ = ini.Load([]byte("[Server]\nhttpCorsOrigins = *\nhttpCorsCredentials = true\n"))
+	if _, err = NewFilterConfFromSection(cfg.Section("Server")); err == nil {
+		t.Fatal("expect error of cors origin * with credentials")
+	}
+}
+
+func TestCors(t *testing.T) {
+	g := securityTestServe(&FilterConf{Cors: &CorsConf{
+		AllowOrigins:  []string{"https://a.example.com", "https://*.b.com"},
+		AllowMethods:  []string{"POST"},
+		ExposeHeaders: []string{"X-Request-Id"},
+		MaxAge:        600,
+	}})
+
+	req := httptest.NewRequest(http.MethodOptions, "/echo", nil)
+	req.Header.Set("Origin", "https://x.b.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "Content-Type")
+	w := testDo(g, req)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "https://x.b.com" ||
+		w.Header().Get("Access-Control-Allow-Headers") != "Content-Type" || w.Header().Get("Access-Control-Max-Age") != "600" {
+		t.Fatalf("unexpected preflight %d %v", w.Code, w.Header())
+	}
+
+	req.Header.Set("Access-Control-Request-Method", "DELETE")
+	if w = testDo(g, req); w.Code != http.StatusForbidden {
+		t.Fatalf("unexpected preflight of method not allowed %d", w.Code)
+	}
+	req.Header.Set("Origin", "https://b.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	if w = testDo(g, req); w.Code != http.StatusForbidden {
+		t.Fatalf("unexpected preflight of origin not allowed %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader("hi"))
+	req.Header.Set("Origin", "https://a.example.com")
+	w = testDo(g, req)
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "https://a.example.com" ||
+		w.Header().Get("Access-Control-Expose-Headers") != "X-Request-Id" {
+		t.Fatalf("unexpected cors response %d %v", w.Code, w.Header())
+	}
+
+	req.Header.Set("Origin", "https://evil.com")
+	if w = testDo(g, req); w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("unexpected response of origin not allowed %d %v", w.Code, w.Header())
+	}
+}
+
+func TestCorsWildcard(t *testing.T) {
+	g := securityTestServe(&FilterConf{Cors: &CorsConf{AllowOrigins: []string{"*"}}})
+	req := httptest.NewRequest(http.MethodPost, "/echo", nil)
+	req.Header.Set("Origin", "https://evil.com")
+	w := testDo(g, req)
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" || w.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Fatalf("unexpected cors response %d %v", w.Code, w.Header())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expect panic of cors origin * with credentials")
+		}
+	}()
+	Cors(&CorsConf{AllowOrigins: []string{"*"}, AllowCredentials: true})
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	g := securityTestServe(&FilterConf{Security: &SecurityConf{
+		HstsMaxAge:            100,
+		HstsIncludeSubdomains: true,
+		ContentSecurityPolicy: "default-src 'self'",
+		FrameOptions:          "DENY",
+	}})
+	w := testDo(g, httptest.NewRequest(http.MethodPost, "/echo", nil))
+	expect := map[string]string{
+		"Strict-Transport-Security": "max-age=100; includeSubDomains",
+		"Content-Security-Policy":   "default-src 'self'",
+		"X-Frame-Options":           "DENY",
+		"X-Content-Type-Options":    "nosniff",
+	}
+	for k, v := range expect {
+		if w.Header().Get(k) != v {
+			t.Fatalf("unexpected header %s:%s", k, w.Header().Get(k))
+		}
+	}
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	g := securityTestServe(&FilterConf{MaxBodyBytes: 4})
+	if w := testDo(g, httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader("1234"))); w.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d", w.Code)
+	}
+	if w := testDo(g, httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader("12345"))); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("unexpected response %d", w.Code)
+	}
+
+	// length unknown
+	req := httptest.NewRequest(http.MethodPost, "/echo", io.NopCloser(strings.NewReader("12345")))
+	req.ContentLength = -1
+	if w := testDo(g, req); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("unexpected response %d", w.Code)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	g := securityTestServe(&FilterConf{RequestTimeout: 20 * time.Millisecond})
+	st := time.Now()
+	if w := testDo(g, httptest.NewRequest(http.MethodGet, "/slow", nil)); w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("unexpected response %d", w.Code)
+	}
+	if d := time.Since(st); d > 500*time.Millisecond {
+		t.Fatalf("request takes %v", d)
+	}
+}
+
+func TestRequestTimeoutStream(t *testing.T) {
+	var streamErr error
+	h := &HttpServer{Filters: &FilterConf{RequestTimeout: 20 * time.Millisecond}}
+	h.HttpServerInit = func(g *gin.Engine) error {
+		h.SSE(g.Group("/api"), "/events", func(c *gin.Context, s *SseStream) error {
+			time.Sleep(50 * time.Millisecond)
+			streamErr = c.Request.Context().Err()
+			return nil
+		})
+		g.GET("/slow", func(c *gin.Context) {
+			<-c.Request.Context().Done()
+			c.Status(http.StatusGatewayTimeout)
+		})
+		return nil
+	}
+	if err := h.initGin(); err != nil {
+		t.Fatalf("init gin occur error:%s", err)
+	}
+
+	// sse route is not limited without header of event stream
+	testDo(h.g, httptest.NewRequest(http.MethodGet, "/api/events", nil))
+	if streamErr != nil {
+		t.Fatalf("sse request is canceled: %s", streamErr)
+	}
+
+	// header of event stream does not skip the timeout of other routes
+	req := httptest.NewRequest(http.MethodGet, "/slow", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	if w := testDo(h.g, req); w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("unexpected response %d", w.Code)
+	}
+}
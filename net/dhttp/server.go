@@ -10,8 +10,12 @@ import (
 	"errors"
 	"fmt"
 	"github.com/gdp-org/gd/dlog"
+	"github.com/gdp-org/gd/runtime/limiter"
 	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
 	"net/http"
+	"net/url"
+	"sync"
 	"time"
 )
 
@@ -31,8 +35,35 @@ type HttpServer struct {
 	HttpServerRunAddr         string         `inject:"httpServerRunAddr" canNil:"true"`
 	HttpServerRunPort         int            `inject:"httpServerRunPort"`
 	HttpServerInit            HttpServerInit `inject:"httpServerInit"`
+	// H2c serves http/2 without tls, it can not be used with UseHttps
+	H2c bool `inject:"httpServerH2c" canNil:"true"`
+	// Http3Port listens http3 on udp alongside https if it is set, Http3 creates the server and
+	// must be set by code, Http3Port is ignored without it
+	Http3Port int `inject:"httpServerHttp3Port" canNil:"true"`
+	Http3     Http3ServerMaker
+	// Limiter limits requests of route, key is the full path of route
+	Limiter *limiter.Limiter `inject:"httpLimiter" canNil:"true"`
+	// OpenApiPath serves OpenAPI document of handlers if it is set, e.g. /openapi.json
+	OpenApiPath  string `inject:"httpServerOpenApiPath" canNil:"true"`
+	OpenApiTitle string `inject:"httpServerOpenApiTitle" canNil:"true"`
+	// Envelope builds response of handlers, DefaultEnvelope is used if it is nil
+	Envelope Envelope `inject:"httpServerEnvelope" canNil:"true"`
+	// Filters are cors, security headers, body size limit and request timeout used before all routes
+	Filters *FilterConf `inject:"httpServerFilters" canNil:"true"`
+	// JwtAuth authenticates bearer tokens of groups which use JwtAuth.Filter
+	JwtAuth *JwtAuth `inject:"httpJwtAuth" canNil:"true"`
+	// WsCheckOrigin checks origin of websocket requests from browser, SameOrigin is used if it is nil
+	WsCheckOrigin func(origin *url.URL, r *http.Request) bool
 
 	HandlerMap map[string]interface{}
+	routes     []route
+	h3         Http3Server
+
+	streams       sync.WaitGroup
+	streamLock    sync.Mutex
+	streamClosing chan struct{}
+	wsConns       map[*websocket.Conn]struct{}
+	streamRoutes  map[string]struct{}
 }
 
 func (h *HttpServer) Start() error {
@@ -63,6 +94,10 @@ func (h *HttpServer) Start() error {
 		return err
 	}
 
+	if h.h3 != nil {
+		h.startHttp3()
+	}
+
 	go func() {
 		var err error
 		if h.UseHttps {
@@ -87,11 +122,15 @@ func (h *HttpServer) Close() {
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(h.HttpServerShutdownTimeout)*time.Second)
 	defer cancel()
+	// sse and websocket are told to end, sse requests are waited by Shutdown, and hijacked websocket by waitStreams
+	h.signalStreams()
 	if err := h.server.Shutdown(ctx); err != nil {
 		dlog.Error("http server shutdown fail,host=%s,timeout=%d,err=%v", h.HttpServerRunPort, h.HttpServerShutdownTimeout, err)
 	} else {
 		dlog.Info("http server shutdown %d", h.HttpServerRunPort)
 	}
+	h.closeHttp3(ctx)
+	h.waitStreams(ctx)
 }
 
 func (h *HttpServer) addHandler(url string, handle interface{}) {
@@ -121,13 +160,26 @@ func (h *HttpServer) makeHttpServer() error {
 		Handler:      h.g,
 		ReadTimeout:  time.Duration(h.HttpServerReadTimeout) * time.Second,
 		WriteTimeout: time.Duration(h.HttpServerWriteTimeout) * time.Second,
+		ConnContext:  connContext,
 	}
 
 	if len(h.HttpServerRunAddr) > 0 {
 		s.Addr = fmt.Sprintf("%s:%d", h.HttpServerRunAddr, h.HttpServerRunPort)
 	}
 
+	if h.H2c {
+		if h.UseHttps {
+			return fmt.Errorf("h2c can not be used with https, which serves http/2 by tls")
+		}
+		if err := h.makeH2c(s); err != nil {
+			return err
+		}
+	}
+
 	h.server = s
+	if h.Http3Port > 0 {
+		return h.makeHttp3Server()
+	}
 	return nil
 }
 
@@ -141,55 +193,96 @@ func (h *HttpServer) initGin() error {
 		g = gin.Default()
 	}
 
+	g.Use(h.markStream)
+	if h.Filters != nil {
+		g.Use(h.Filters.Handlers()...)
+	}
+
+	if h.Limiter != nil {
+		g.Use(h.limit)
+	}
+
+	if h.Envelope != nil {
+		g.Use(UseEnvelope(h.Envelope))
+	}
+
 	err := h.HttpServerInit(g)
 	if err != nil {
 		return err
 	}
 
+	if h.OpenApiPath != "" {
+		g.GET(h.OpenApiPath, h.serveOpenApi)
+	}
+
 	h.g = g
 	return nil
 }
 
+// limit rejects requests of route with 429 when limiter of route is exceeded.
+func (h *HttpServer) limit(c *gin.Context) {
+	route := c.FullPath()
+	if route == "" {
+		c.Next()
+		return
+	}
+
+	release, ok := h.Limiter.Acquire(route)
+	if !ok {
+		c.AbortWithStatus(http.StatusTooManyRequests)
+		return
+	}
+	defer release()
+	c.Next()
+}
+
 // For GET, POST, PUT, PATCH and DELETE requests the respective shortcut
-// functions can be used.
-func (h *HttpServer) Handle(group *gin.RouterGroup, httpMethod, relativePath string, handler interface{}) {
+// functions can be used. filters run before handler, e.g. RequireRoles and RequireScopes.
+func (h *HttpServer) Handle(group *gin.RouterGroup, httpMethod, relativePath string, handler interface{}, filters ...gin.HandlerFunc) {
 	h.addHandler(relativePath, handler)
+	h.addRoute(group, httpMethod, relativePath, handler)
 	ginHandler := Wrap(handler)
-	group.Handle(httpMethod, relativePath, ginHandler)
+	group.Handle(httpMethod, relativePath, append(filters, ginHandler)...)
 }
 
-func (h *HttpServer) POST(group *gin.RouterGroup, relativePath string, handler interface{}) {
+func (h *HttpServer) POST(group *gin.RouterGroup, relativePath string, handler interface{}, filters ...gin.HandlerFunc) {
 	h.addHandler(relativePath, handler)
+	h.addRoute(group, http.MethodPost, relativePath, handler)
 	ginHandler := Wrap(handler)
-	group.POST(relativePath, ginHandler)
+	group.POST(relativePath, append(filters, ginHandler)...)
 }
 
-func (h *HttpServer) GET(group *gin.RouterGroup, relativePath string, handler interface{}) {
+func (h *HttpServer) GET(group *gin.RouterGroup, relativePath string, handler interface{}, filters ...gin.HandlerFunc) {
 	h.addHandler(relativePath, handler)
+	h.addRoute(group, http.MethodGet, relativePath, handler)
 	ginHandler := Wrap(handler)
-	group.GET(relativePath, ginHandler)
+	group.GET(relativePath, append(filters, ginHandler)...)
 }
 
-func (h *HttpServer) DELETE(group *gin.RouterGroup, relativePath string, handler interface{}) {
+func (h *HttpServer) DELETE(group *gin.RouterGroup, relativePath string, handler interface{}, filters ...gin.HandlerFunc) {
 	h.addHandler(relativePath, handler)
+	h.addRoute(group, http.MethodDelete, relativePath, handler)
 	ginHandler := Wrap(handler)
-	group.DELETE(relativePath, ginHandler)
+	group.DELETE(relativePath, append(filters, ginHandler)...)
 }
 
-func (h *HttpServer) PATCH(group *gin.RouterGroup, relativePath string, handler interface{}) {
+func (h *HttpServer) PATCH(group *gin.RouterGroup, relativePath string, handler interface{}, filters ...gin.HandlerFunc) {
 	h.addHandler(relativePath, handler)
+	h.addRoute(group, http.MethodPatch, relativePath, handler)
 	ginHandler := Wrap(handler)
-	group.DELETE(relativePath, ginHandler)
+	group.PATCH(relativePath, append(filters, ginHandler)...)
 }
 
-func (h *HttpServer) PUT(group *gin.RouterGroup, relativePath string, handler interface{}) {
+func (h *HttpServer) PUT(group *gin.RouterGroup, relativePath string, handler interface{}, filters ...gin.HandlerFunc) {
 	h.addHandler(relativePath, handler)
+	h.addRoute(group, http.MethodPut, relativePath, handler)
 	ginHandler := Wrap(handler)
-	group.DELETE(relativePath, ginHandler)
+	group.PUT(relativePath, append(filters, ginHandler)...)
 }
 
-func (h *HttpServer) OPTIONS(group *gin.RouterGroup, relativePath string, handler interface{}) {
+func (h *HttpServer) OPTIONS(group *gin.RouterGroup, relativePath string, handler interface{}, filters ...gin.HandlerFunc) {
 	h.addHandler(relativePath, handler)
+	h.addRoute(group, http.MethodOptions, relativePath, handler)
 	ginHandler := Wrap(handler)
-	group.DELETE(relativePath, ginHandler)
+	group.OPTIONS(relativePath, append(filters, ginHandler)...)
 }
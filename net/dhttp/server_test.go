@@ -0,0 +1,42 @@
+/**
+ * Copyright 2019 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package dhttp
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func serverTestHandler(c *gin.Context, req *struct{}) (int, string, error, string) {
+	return http.StatusOK, "ok", nil, c.Request.Method
+}
+
+func TestHttpServerMethods(t *testing.T) {
+	h := &HttpServer{}
+	h.HttpServerInit = func(g *gin.Engine) error {
+		r := g.Group("/api")
+		r.Use(GroupFilter())
+		h.PATCH(r, "/patch", serverTestHandler)
+		h.PUT(r, "/put", serverTestHandler)
+		h.OPTIONS(r, "/options", serverTestHandler)
+		return nil
+	}
+	if err := h.initGin(); err != nil {
+		t.Fatalf("init gin occur error:%s", err)
+	}
+
+	for _, method := range []string{http.MethodPatch, http.MethodPut, http.MethodOptions} {
+		path := "/api/" + strings.ToLower(method)
+		if w := testServe(h.g, method, path, ""); w.Code != http.StatusOK {
+			t.Fatalf("unexpected status %d of %s %s", w.Code, method, path)
+		}
+		if w := testServe(h.g, http.MethodDelete, path, ""); w.Code == http.StatusOK {
+			t.Fatalf("unexpected DELETE %s routed", path)
+		}
+	}
+}
@@ -0,0 +1,391 @@
+/**
+ * Copyright 2019 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package dhttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gdp-org/gd/dlog"
+	"github.com/gdp-org/gd/runtime/stat"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/*
+ * long-lived handlers of HttpServer, server-sent events and websocket, e.g.
+ *
+ * h.SSE(r, "/events", func(c *gin.Context, s *dhttp.SseStream) error {
+ *     for {
+ *         select {
+ *         case <-s.Done():
+ *             return nil
+ *         case e := <-events:
+ *             if err := s.Send("update", e.Id, e); err != nil {
+ *                 return err
+ *             }
+ *         }
+ *     }
+ * })
+ *
+ * h.WebSocket(r, "/ws", func(c *gin.Context, conn *dhttp.WsConn) error {
+ *     var msg string
+ *     for websocket.Message.Receive(conn.Conn, &msg) == nil {
+ *         websocket.Message.Send(conn.Conn, msg)
+ *     }
+ *     return nil
+ * })
+ *
+ * handlers run in the goroutine of request, so that gl and trace id set by GlFilter and Logger are
+ * available, and Logger logs the connection duration as cost. read and write timeouts of server
+ * do not apply to them. each connection is counted in runtime/stat by "sse <path>" or "ws <path>".
+ *
+ * when HttpServer is closed, Done of streams is closed, and connections which are not finished
+ * within HttpServerShutdownTimeout are closed.
+ */
+
+// SseHandlerFunc sends events by stream until it returns.
+type SseHandlerFunc func(c *gin.Context, stream *SseStream) error
+
+// WsHandlerFunc serves conn until it returns, conn is closed then.
+type WsHandlerFunc func(c *gin.Context, conn *WsConn) error
+
+type connKeyType struct{}
+
+var connKey = connKeyType{}
+
+// connContext is ConnContext of http.Server, so that deadlines of long-lived connections can be cleared.
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey, c)
+}
+
+type SseStream struct {
+	ctx     context.Context
+	w       gin.ResponseWriter
+	traceId string
+}
+
+// Send sends event with id, data which is not string or []byte is marshaled to json. event and id may be empty.
+func (s *SseStream) Send(event, id string, data interface{}) error {
+	var payload string
+	switch d := data.(type) {
+	case string:
+		payload = d
+	case []byte:
+		payload = string(d)
+	default:
+		b, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		payload = string(b)
+	}
+
+	var sb strings.Builder
+	if id != "" {
+		sb.WriteString("id: " + id + "\n")
+	}
+	if event != "" {
+		sb.WriteString("event: " + event + "\n")
+	}
+	for _, line := range strings.Split(payload, "\n") {
+		sb.WriteString("data: " + line + "\n")
+	}
+	sb.WriteString("\n")
+	return s.write(sb.String())
+}
+
+// Comment sends comment, which is ignored by client but keeps the connection alive.
+func (s *SseStream) Comment(comment string) error {
+	return s.write(": " + comment + "\n\n")
+}
+
+func (s *SseStream) write(msg string) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := s.w.WriteString(msg); err != nil {
+		return err
+	}
+	s.w.Flush()
+	return nil
+}
+
+// Context is done when client is gone or server is closing.
+func (s *SseStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *SseStream) Done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+func (s *SseStream) TraceId() string {
+	return s.traceId
+}
+
+type WsConn struct {
+	*websocket.Conn
+	ctx     context.Context
+	traceId string
+}
+
+// Context is done when handler returns or server is closing.
+func (c *WsConn) Context() context.Context {
+	return c.ctx
+}
+
+func (c *WsConn) Done() <-chan struct{} {
+	return c.ctx.Done()
+}
+
+func (c *WsConn) TraceId() string {
+	return c.traceId
+}
+
+// SSE registers server-sent events handler of GET on group.
+func (h *HttpServer) SSE(group *gin.RouterGroup, relativePath string, handler SseHandlerFunc) {
+	h.addStreamRoute(group, relativePath)
+	group.GET(relativePath, h.serveSse(handler))
+}
+
+// WebSocket registers websocket handler of GET on group.
+func (h *HttpServer) WebSocket(group *gin.RouterGroup, relativePath string, handler WsHandlerFunc) {
+	h.addStreamRoute(group, relativePath)
+	group.GET(relativePath, h.serveWebSocket(handler))
+}
+
+// addStreamRoute records full path of sse or websocket route.
+func (h *HttpServer) addStreamRoute(group *gin.RouterGroup, relativePath string) {
+	if h.streamRoutes == nil {
+		h.streamRoutes = make(map[string]struct{})
+	}
+	h.streamRoutes[routePath(group, relativePath)] = struct{}{}
+}
+
+// markStream sets Stream of requests to sse and websocket routes before filters, e.g. RequestTimeout.
+func (h *HttpServer) markStream(c *gin.Context) {
+	if _, ok := h.streamRoutes[c.FullPath()]; ok {
+		c.Set(Stream, true)
+	}
+}
+
+func (h *HttpServer) serveSse(handler SseHandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		st := stat.NewStat().Begin("sse " + c.FullPath())
+		c.Set(Stream, true)
+		c.Set(Code, http.StatusOK)
+
+		ctx, done, ok := h.beginStream(c.Request.Context())
+		if !ok {
+			c.Set(Code, http.StatusServiceUnavailable)
+			c.AbortWithStatus(http.StatusServiceUnavailable)
+			st.End(http.StatusServiceUnavailable)
+			return
+		}
+		defer done()
+		clearDeadline(c.Request)
+
+		header := c.Writer.Header()
+		header.Set("Content-Type", "text/event-stream")
+		header.Set("Cache-Control", "no-cache")
+		header.Set("Connection", "keep-alive")
+		header.Set("X-Accel-Buffering", "no")
+		c.Writer.WriteHeader(http.StatusOK)
+		c.Writer.Flush()
+
+		s := &SseStream{ctx: ctx, w: c.Writer, traceId: traceIdOf(c)}
+		err := handler(c, s)
+		h.endStream(c, "sse", err, st)
+	}
+}
+
+func (h *HttpServer) serveWebSocket(handler WsHandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		st := stat.NewStat().Begin("ws " + c.FullPath())
+		c.Set(Stream, true)
+		c.Set(Code, http.StatusSwitchingProtocols)
+
+		ctx, done, ok := h.beginStream(c.Request.Context())
+		if !ok {
+			c.Set(Code, http.StatusServiceUnavailable)
+			c.AbortWithStatus(http.StatusServiceUnavailable)
+			st.End(http.StatusServiceUnavailable)
+			return
+		}
+		defer done()
+
+		var (
+			err      error
+			upgraded bool
+		)
+		ws := websocket.Server{
+			Handshake: h.wsHandshake,
+			Handler: func(conn *websocket.Conn) {
+				upgraded = true
+				conn.SetDeadline(time.Time{})
+				h.trackWs(conn, true)
+				defer h.trackWs(conn, false)
+
+				err = handler(c, &WsConn{Conn: conn, ctx: ctx, traceId: traceIdOf(c)})
+			},
+		}
+		ws.ServeHTTP(c.Writer, c.Request)
+		if !upgraded {
+			// handshake is rejected by websocket server
+			c.Set(Code, http.StatusBadRequest)
+			st.End(http.StatusBadRequest)
+			return
+		}
+		h.endStream(c, "ws", err, st)
+	}
+}
+
+// wsHandshake accepts requests without origin, e.g. not from browser, or whose origin is checked by WsCheckOrigin.
+func (h *HttpServer) wsHandshake(config *websocket.Config, r *http.Request) error {
+	origin, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+	config.Origin = origin
+	if origin == nil {
+		return nil
+	}
+
+	check := h.WsCheckOrigin
+	if check == nil {
+		check = SameOrigin
+	}
+	if !check(origin, r) {
+		return fmt.Errorf("websocket origin %s not allowed", origin)
+	}
+	return nil
+}
+
+// SameOrigin is the default WsCheckOrigin, origin must be the host of request.
+func SameOrigin(origin *url.URL, r *http.Request) bool {
+	return strings.EqualFold(origin.Host, r.Host)
+}
+
+// beginStream returns ctx of stream which is done when server is closing, and done which must be
+// called when stream ends. it returns false if server is closing.
+func (h *HttpServer) beginStream(parent context.Context) (context.Context, func(), bool) {
+	h.streamLock.Lock()
+	defer h.streamLock.Unlock()
+	if h.streamClosing == nil {
+		h.streamClosing = make(chan struct{})
+	}
+	if isClosed(h.streamClosing) {
+		return nil, nil, false
+	}
+	h.streams.Add(1)
+
+	ctx, cancel := context.WithCancel(parent)
+	closing := h.streamClosing
+	go func() {
+		select {
+		case <-closing:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		cancel()
+		h.streams.Done()
+	}, true
+}
+
+func (h *HttpServer) endStream(c *gin.Context, kind string, err error, st *stat.Stat) {
+	st.EndErr(err)
+	if err != nil {
+		c.Set(Err, err)
+		dlog.Warn("%s %s trace %s closed after %v, err=%v", kind, c.Request.URL.Path, traceIdOf(c), st.Elapse(), err)
+		return
+	}
+	dlog.Info("%s %s trace %s closed after %v", kind, c.Request.URL.Path, traceIdOf(c), st.Elapse())
+}
+
+func (h *HttpServer) trackWs(conn *websocket.Conn, add bool) {
+	h.streamLock.Lock()
+	defer h.streamLock.Unlock()
+	if h.wsConns == nil {
+		h.wsConns = make(map[*websocket.Conn]struct{})
+	}
+	if add {
+		h.wsConns[conn] = struct{}{}
+	} else {
+		delete(h.wsConns, conn)
+	}
+}
+
+// signalStreams closes Done of streams, and rejects new streams.
+func (h *HttpServer) signalStreams() {
+	h.streamLock.Lock()
+	defer h.streamLock.Unlock()
+	if h.streamClosing == nil {
+		h.streamClosing = make(chan struct{})
+	}
+	if !isClosed(h.streamClosing) {
+		close(h.streamClosing)
+	}
+}
+
+// waitStreams waits for streams to end until ctx is done, then websocket connections left are closed.
+func (h *HttpServer) waitStreams(ctx context.Context) {
+	finished := make(chan struct{})
+	go func() {
+		h.streams.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return
+	case <-ctx.Done():
+	}
+
+	h.streamLock.Lock()
+	defer h.streamLock.Unlock()
+	for conn := range h.wsConns {
+		dlog.Warn("websocket %s not finished in shutdown timeout, close it", conn.Request().URL.Path)
+		conn.Close()
+	}
+}
+
+// clearDeadline clears read and write deadlines set by server, so that the stream is not broken by timeouts.
+func clearDeadline(r *http.Request) {
+	if r.ProtoMajor != 1 {
+		// connection is shared by streams of http2
+		return
+	}
+	if conn, ok := r.Context().Value(connKey).(net.Conn); ok {
+		conn.SetDeadline(time.Time{})
+	}
+}
+
+func traceIdOf(c *gin.Context) string {
+	if traceId := c.GetString(TraceID); traceId != "" {
+		return traceId
+	}
+	if traceId := c.Query(TraceID); traceId != "" {
+		return traceId
+	}
+	return c.GetHeader(TraceID)
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
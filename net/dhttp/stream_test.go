@@ -0,0 +1,137 @@
+/**
+ * Copyright 2019 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package dhttp
+
+import (
+	"bufio"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startStreamServer(t *testing.T, init func(h *HttpServer, g *gin.Engine)) (*HttpServer, *httptest.Server) {
+	h := &HttpServer{HttpServerShutdownTimeout: 1}
+	h.HttpServerInit = func(g *gin.Engine) error {
+		init(h, g)
+		return nil
+	}
+	if err := h.initGin(); err != nil {
+		t.Fatalf("init gin occur error:%s", err)
+	}
+
+	ts := httptest.NewUnstartedServer(h.g)
+	ts.Config.ConnContext = connContext
+	ts.Config.WriteTimeout = 100 * time.Millisecond
+	ts.Start()
+	h.server = ts.Config
+	return h, ts
+}
+
+func TestSse(t *testing.T) {
+	returned := make(chan struct{})
+	h, ts := startStreamServer(t, func(h *HttpServer, g *gin.Engine) {
+		h.SSE(g.Group("/"), "/events", func(c *gin.Context, s *SseStream) error {
+			defer close(returned)
+			if err := s.Send("greet", "1", "hello\nworld"); err != nil {
+				return err
+			}
+			// longer than write timeout of server
+			time.Sleep(200 * time.Millisecond)
+			if err := s.Send("", "2", map[string]int{"n": 2}); err != nil {
+				return err
+			}
+			<-s.Done()
+			return nil
+		})
+	})
+	defer ts.Close()
+
+	rsp, err := http.Get(ts.URL + "/events")
+	if err != nil {
+		t.Fatalf("get events occur error:%s", err)
+	}
+	defer rsp.Body.Close()
+	if ct := rsp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %s", ct)
+	}
+
+	expect := []string{"id: 1", "event: greet", "data: hello", "data: world", "", "id: 2", `data: {"n":2}`, ""}
+	r := bufio.NewReader(rsp.Body)
+	for _, e := range expect {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read event occur error:%s", err)
+		}
+		if strings.TrimSuffix(line, "\n") != e {
+			t.Fatalf("unexpected line %q, expect %q", line, e)
+		}
+	}
+
+	st := time.Now()
+	h.Close()
+	select {
+	case <-returned:
+	default:
+		t.Fatal("sse handler not returned after close")
+	}
+	if d := time.Since(st); d > 500*time.Millisecond {
+		t.Fatalf("close takes %v", d)
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	returned := make(chan struct{})
+	h, ts := startStreamServer(t, func(h *HttpServer, g *gin.Engine) {
+		h.WebSocket(g.Group("/"), "/ws", func(c *gin.Context, conn *WsConn) error {
+			defer close(returned)
+			var msg string
+			for websocket.Message.Receive(conn.Conn, &msg) == nil {
+				if err := websocket.Message.Send(conn.Conn, "echo:"+msg); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	defer ts.Close()
+
+	wsUrl := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+	if _, err := websocket.Dial(wsUrl, "", "http://evil.example.com"); err == nil {
+		t.Fatal("expect error of cross origin")
+	}
+
+	conn, err := websocket.Dial(wsUrl, "", ts.URL)
+	if err != nil {
+		t.Fatalf("dial websocket occur error:%s", err)
+	}
+	defer conn.Close()
+
+	// longer than write timeout of server
+	time.Sleep(200 * time.Millisecond)
+	var msg string
+	if err := websocket.Message.Send(conn, "hi"); err != nil {
+		t.Fatalf("send occur error:%s", err)
+	}
+	if err := websocket.Message.Receive(conn, &msg); err != nil || msg != "echo:hi" {
+		t.Fatalf("unexpected echo %s, err=%v", msg, err)
+	}
+
+	// handler blocks on receive, it is closed after shutdown timeout
+	st := time.Now()
+	h.Close()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("websocket handler not returned after close")
+	}
+	if d := time.Since(st); d < time.Second || d > 2*time.Second {
+		t.Fatalf("close takes %v", d)
+	}
+}
@@ -0,0 +1,61 @@
+/**
+ * Copyright 2019 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package dhttp
+
+import (
+	"fmt"
+	"github.com/gdp-org/gd/utls/validate"
+	"github.com/gin-gonic/gin"
+	"reflect"
+)
+
+/*
+ * typed handlers are checked by compiler and called without reflection, they bind, validate and
+ * return as handlers of Wrap, so that ret and code are set for GroupFilter, Logger and StatFilter.
+ *
+ * e.g.
+ *
+ * func getUser(c *gin.Context, req *GetUserReq) (int, string, error, *User)
+ *
+ * r.GET("/user", dhttp.Handle(getUser))
+ * dhttp.Route(h, r, http.MethodGet, "/user", getUser) // also in HandlerMap and OpenApi
+ */
+
+// HandlerFunc is typed handler, req is nil if data_raw set by filters is empty.
+type HandlerFunc[In, Out any] func(c *gin.Context, req *In) (code int, message string, err error, ret Out)
+
+// Handle converts typed handler to gin.HandlerFunc, it panics if validate tags of In are illegal.
+func Handle[In, Out any](f HandlerFunc[In, Out]) gin.HandlerFunc {
+	if err := validate.Check(reflect.TypeOf((*In)(nil))); err != nil {
+		panic(fmt.Sprintf("typed handler validate tag illegal %v: %s", f, err))
+	}
+
+	return func(c *gin.Context) {
+		req := new(In)
+		empty, ok := bindData(c, req, f)
+		if !ok {
+			return
+		}
+		if empty {
+			req = nil
+		}
+		c.Set(Data, req)
+
+		if !validData(c, req, f) {
+			return
+		}
+
+		code, message, err, ret := f(c, req)
+		Return(c, code, message, err, ret)
+	}
+}
+
+// Route registers typed handler on group like HttpServer.Handle.
+func Route[In, Out any](h *HttpServer, group *gin.RouterGroup, httpMethod, relativePath string, f HandlerFunc[In, Out], filters ...gin.HandlerFunc) {
+	h.addHandler(relativePath, f)
+	h.addRoute(group, httpMethod, relativePath, f)
+	group.Handle(httpMethod, relativePath, append(filters, Handle(f))...)
+}
@@ -0,0 +1,87 @@
+/**
+ * Copyright 2019 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package dhttp
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type typedTestReq struct {
+	Name string `json:"name" form:"name" validate:"required"`
+}
+
+type typedTestResp struct {
+	Hello string `json:"hello"`
+}
+
+func typedTestHandler(c *gin.Context, req *typedTestReq) (int, string, error, *typedTestResp) {
+	return http.StatusOK, "ok", nil, &typedTestResp{Hello: req.Name}
+}
+
+func TestTypedHandler(t *testing.T) {
+	h := &HttpServer{OpenApiPath: "/openapi.json"}
+	h.HttpServerInit = func(g *gin.Engine) error {
+		r := g.Group("/api")
+		r.Use(GroupFilter())
+		Route(h, r, http.MethodGet, "/hello", typedTestHandler)
+		r.POST("/hello", Handle(typedTestHandler))
+		return nil
+	}
+	if err := h.initGin(); err != nil {
+		t.Fatalf("init gin occur error:%s", err)
+	}
+	if err := h.CheckHandle(); err != nil {
+		t.Fatalf("check handle occur error:%s", err)
+	}
+
+	w := httptest.NewRecorder()
+	h.g.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/hello?name=gd", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", w.Code)
+	}
+	ret := struct {
+		Code   int           `json:"code"`
+		Result typedTestResp `json:"result"`
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &ret); err != nil {
+		t.Fatalf("unmarshal rsp occur error:%s", err)
+	}
+	if ret.Code != http.StatusOK || ret.Result.Hello != "gd" {
+		t.Fatalf("unexpected rsp %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/hello", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	h.g.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("unexpected status %d", w.Code)
+	}
+
+	doc := h.OpenApi()
+	if op := doc.Paths["/api/hello"]["get"]; op == nil || len(op.Parameters) != 1 || !op.Parameters[0].Required {
+		t.Fatalf("unexpected openapi of typed handler %+v", doc.Paths)
+	}
+}
+
+func TestHandleIllegalTag(t *testing.T) {
+	type illegalReq struct {
+		Name string `validate:"max"`
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expect panic when validate tag is illegal")
+		}
+	}()
+	Handle(func(c *gin.Context, req *illegalReq) (int, string, error, string) {
+		return http.StatusOK, "", nil, ""
+	})
+}
@@ -9,8 +9,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	de "github.com/gdp-org/gd/derror"
 	"github.com/gdp-org/gd/dlog"
+	"github.com/gdp-org/gd/utls/validate"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
 	"io/ioutil"
 	"net/http"
 	"reflect"
@@ -43,6 +46,9 @@ func CheckWrap(toWrap interface{}) error {
 	if !wt.Out(2).Implements(errInterface) {
 		return fmt.Errorf("params out 3 must be error %v", toWrap)
 	}
+	if err := validate.Check(wt.In(1)); err != nil {
+		return fmt.Errorf("params in validate tag illegal %v: %s", toWrap, err)
+	}
 	return nil
 }
 
@@ -65,52 +71,18 @@ func Wrap(toWrap interface{}) gin.HandlerFunc {
 
 		// parse data
 		// data_raw is possible to encrypt data
-		dataBtsObj, ok := c.Get(DataRaw)
+		empty, ok := bindData(c, inValInterface, toWrap)
 		if !ok {
-			if c.Request.Method == "GET" {
-				// TODO fix
-				c.Bind(inValInterface)
-				c.Set(Data, inValInterface)
-			} else {
-				err := c.Bind(inValInterface)
-				if err != nil {
-					var body []byte
-					var readBodyErr error
-					if c.Request.Method == "POST" {
-						body, readBodyErr = ioutil.ReadAll(c.Request.Body)
-					} else {
-						body = []byte(c.Request.RequestURI)
-					}
-					dlog.Error("wrap data not valid!data=%s,func=%v,err=%v,readBodyErr=%v", string(body), toWrap, err, readBodyErr)
-					Return(c, http.StatusBadRequest, "data not valid", err, nil)
-					c.Set(SessionLogLevel, "INFO")
-					return
-				}
-				c.Set(Data, inValInterface)
-			}
-		} else {
-			dataBts, ok := dataBtsObj.([]byte)
-			if !ok {
-				dlog.Error("wrap data not []byte!func=%v,data=%v", toWrap, dataBtsObj)
-				Return(c, http.StatusInternalServerError, "data not byte array", nil, nil)
-				c.Set(SessionLogLevel, "INFO")
-				return
-			}
-			if dataBts != nil && len(dataBts) > 0 {
-				jsonErr := json.Unmarshal(dataBts, inValInterface)
-				if jsonErr != nil {
-					dlog.Info("wrap wrap data from json fail!bts=%s,func=%v,err=%v", string(dataBts), toWrap, jsonErr)
-					Return(c, http.StatusInternalServerError, "data type not valid", jsonErr, nil)
-					c.Set(SessionLogLevel, "INFO")
-					return
-				}
-			} else {
-				if inType.Kind() == reflect.Ptr {
-					inVal = reflect.Zero(inType)
-					inValInterface = inVal.Interface()
-				}
-			}
-			c.Set(Data, inValInterface)
+			return
+		}
+		if empty && inType.Kind() == reflect.Ptr {
+			inVal = reflect.Zero(inType)
+			inValInterface = inVal.Interface()
+		}
+		c.Set(Data, inValInterface)
+
+		if !validData(c, inValInterface, toWrap) {
+			return
 		}
 
 		in := make([]reflect.Value, wtNumIn)
@@ -160,14 +132,85 @@ func Wrap(toWrap interface{}) gin.HandlerFunc {
 	return wrapped
 }
 
+// bindData binds data of request to in, data_raw set by filters is used if it exists. it reports
+// whether data_raw is empty, and ret is set if it fails.
+func bindData(c *gin.Context, in interface{}, handler interface{}) (empty bool, ok bool) {
+	dataBtsObj, ok := c.Get(DataRaw)
+	if !ok {
+		if c.Request.Method == "GET" {
+			// TODO fix
+			c.Bind(in)
+			return false, true
+		}
+
+		err := c.Bind(in)
+		if err != nil {
+			var body []byte
+			var readBodyErr error
+			if c.Request.Method == "POST" {
+				body, readBodyErr = ioutil.ReadAll(c.Request.Body)
+			} else {
+				body = []byte(c.Request.RequestURI)
+			}
+			dlog.Error("wrap data not valid!data=%s,func=%v,err=%v,readBodyErr=%v", string(body), handler, err, readBodyErr)
+			Return(c, http.StatusBadRequest, "data not valid", err, nil)
+			c.Set(SessionLogLevel, "INFO")
+			return false, false
+		}
+		return false, true
+	}
+
+	dataBts, ok := dataBtsObj.([]byte)
+	if !ok {
+		dlog.Error("wrap data not []byte!func=%v,data=%v", handler, dataBtsObj)
+		Return(c, http.StatusInternalServerError, "data not byte array", nil, nil)
+		c.Set(SessionLogLevel, "INFO")
+		return false, false
+	}
+	if len(dataBts) == 0 {
+		return true, true
+	}
+
+	jsonErr := json.Unmarshal(dataBts, in)
+	if jsonErr != nil {
+		dlog.Info("wrap wrap data from json fail!bts=%s,func=%v,err=%v", string(dataBts), handler, jsonErr)
+		Return(c, http.StatusInternalServerError, "data type not valid", jsonErr, nil)
+		c.Set(SessionLogLevel, "INFO")
+		return false, false
+	}
+	return false, true
+}
+
+// validData validates in by validate tags, see utls/validate, ret of ParameterError is set if it is not valid.
+func validData(c *gin.Context, in interface{}, handler interface{}) bool {
+	errs := validate.Struct(in)
+	if len(errs) == 0 {
+		return true
+	}
+
+	dlog.Info("wrap data not valid!func=%v,err=%v", handler, errs)
+	Return(c, de.ParameterError, errs.Error(), de.MakeCodeError(de.ParameterError, errs), errs)
+	// ParameterError is not http status, which is 400 unless it is mapped by envelope
+	if status := c.GetInt(Code); status < 100 || status >= 600 {
+		c.Set(Code, http.StatusBadRequest)
+	}
+	c.Set(SessionLogLevel, "INFO")
+	return false
+}
+
+// Return sets response body built by envelope of c as ret, and http status as code. result which
+// is render.Render is the body itself.
 func Return(c *gin.Context, code int, message string, err error, result interface{}) {
-	ret := make(map[string]interface{})
-	ret["code"] = code
-	ret["result"] = result
-	ret["message"] = message
+	e := envelopeOf(c)
+	var ret interface{}
+	if r, ok := result.(render.Render); ok {
+		ret = r
+	} else {
+		ret = e.Body(code, message, err, result)
+	}
 
 	c.Set(Ret, ret)
-	c.Set(Code, code)
+	c.Set(Code, e.Status(code, err))
 	if err != nil {
 		c.Set(Err, err)
 	}
@@ -181,11 +224,7 @@ func ParseRet(c *gin.Context) (ret interface{}, origErr interface{}) {
 			err := errors.New("no ret found")
 			c.Set(Err, err)
 		}
-		ret = gin.H{
-			"code":    http.StatusInternalServerError,
-			"result":  nil,
-			"message": "no result",
-		}
+		ret = envelopeOf(c).Body(http.StatusInternalServerError, "no result", nil, nil)
 	} else {
 		if retObj == nil {
 			if origErr != nil {
@@ -196,11 +235,7 @@ func ParseRet(c *gin.Context) (ret interface{}, origErr interface{}) {
 				c.Set(Err, err)
 			}
 
-			ret = gin.H{
-				"code":    http.StatusInternalServerError,
-				"result":  nil,
-				"message": "empty result",
-			}
+			ret = envelopeOf(c).Body(http.StatusInternalServerError, "empty result", nil, nil)
 		} else {
 			ret = retObj
 			return
@@ -0,0 +1,86 @@
+/**
+ * Copyright 2019 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package dhttp
+
+import (
+	"encoding/json"
+	de "github.com/gdp-org/gd/derror"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type wrapValidateItem struct {
+	Sku string `json:"sku" validate:"required,regex=^[A-Z]+$"`
+}
+
+type wrapValidateReq struct {
+	Name  string             `json:"name" validate:"required"`
+	Level int                `json:"level" validate:"min=1,max=3"`
+	Items []wrapValidateItem `json:"items"`
+}
+
+func wrapValidateHandler(c *gin.Context, req *wrapValidateReq) (int, string, error, string) {
+	return http.StatusOK, "ok", nil, req.Name
+}
+
+func TestWrapValidate(t *testing.T) {
+	if err := CheckWrap(wrapValidateHandler); err != nil {
+		t.Fatalf("check wrap occur error:%s", err)
+	}
+
+	g := gin.New()
+	g.Use(GroupFilter())
+	g.POST("/user", Wrap(wrapValidateHandler))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/user", strings.NewReader(`{"level":5,"items":[{"sku":"abc"}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	g.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("unexpected status %d", w.Code)
+	}
+
+	ret := struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Result  []struct {
+			Field string `json:"field"`
+			Rule  string `json:"rule"`
+		} `json:"result"`
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &ret); err != nil {
+		t.Fatalf("unmarshal rsp occur error:%s", err)
+	}
+	if ret.Code != de.ParameterError || ret.Message == "" || len(ret.Result) != 3 {
+		t.Fatalf("unexpected rsp %s", w.Body.String())
+	}
+	for i, field := range []string{"name", "level", "items[0].sku"} {
+		if ret.Result[i].Field != field {
+			t.Fatalf("unexpected field error %+v", ret.Result[i])
+		}
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/user", strings.NewReader(`{"name":"a","level":2,"items":[{"sku":"ABC"}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	g.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d, rsp %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCheckWrapValidateTag(t *testing.T) {
+	if err := CheckWrap(func(c *gin.Context, req *struct {
+		Name string `validate:"max=a"`
+	}) (int, string, error, string) {
+		return http.StatusOK, "", nil, ""
+	}); err == nil {
+		t.Fatal("expect error when validate tag is illegal")
+	}
+}
@@ -0,0 +1,285 @@
+/**
+ * Copyright 2018 gd Author. All Rights Reserved.
+ * Author: Chuck1024
+ */
+
+package dogrpc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	dogError "github.com/gdp-org/gd/derror"
+	"github.com/gdp-org/gd/dlog"
+	"gopkg.in/ini.v1"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * authentication of dog packet without client certs. credentials are carried in metadata:
+ *
+ * bearer token: auth_token = <token>
+ * hmac signing: auth_key = <key id>, auth_ts = <unix second>, auth_nonce = <random>,
+ *               auth_sign = hex(hmac-sha256(secret, key id \n ts \n nonce \n cmd \n body))
+ *
+ * signed requests are rejected if ts is not within MaxSkew from now, or nonce is used in
+ * MaxSkew. the principal, name of token or key id, is carried by ctx of handler, and credentials
+ * are removed from metadata of ctx after they are verified.
+ *
+ * credentials are configured in RpcAuth section of conf.ini, e.g.
+ *
+ * [RpcAuth]
+ * maxSkew = 300
+ * token.caller-a = 3b1f0c...
+ * hmac.caller-b = 9c2e7a...
+ *
+ * RpcPacket has no metadata, so RpcServer of NewRpcServer fails to start if auth is enabled.
+ */
+
+const (
+	MetaAuthToken = "auth_token"
+	MetaAuthKey   = "auth_key"
+	MetaAuthTs    = "auth_ts"
+	MetaAuthNonce = "auth_nonce"
+	MetaAuthSign  = "auth_sign"
+
+	DefaultAuthMaxSkew = 5 * time.Minute
+)
+
+type Auth struct {
+	MaxSkew time.Duration
+
+	tokens  map[string]string // token -> principal
+	secrets map[string][]byte // key id -> secret
+
+	nonceLock  sync.Mutex
+	nonces     map[string]time.Time // key id + nonce -> expire time
+	lastPruned time.Time
+}
+
+func NewAuth() *Auth {
+	return &Auth{
+		MaxSkew: DefaultAuthMaxSkew,
+		tokens:  make(map[string]string),
+		secrets: make(map[string][]byte),
+		nonces:  make(map[string]time.Time),
+	}
+}
+
+// NewAuthFromSection creates auth with credentials in sec, see the comment of Auth.
+func NewAuthFromSection(sec *ini.Section) (*Auth, error) {
+	a := NewAuth()
+	for _, k := range sec.Keys() {
+		name := k.Name()
+		switch {
+		case name == "maxSkew":
+			skew, err := k.Int()
+			if err != nil || skew <= 0 {
+				return nil, fmt.Errorf("auth maxSkew %s illegal", k.String())
+			}
+			a.MaxSkew = time.Duration(skew) * time.Second
+		case strings.HasPrefix(name, "token."):
+			a.AddToken(strings.TrimPrefix(name, "token."), k.String())
+		case strings.HasPrefix(name, "hmac."):
+			a.AddHmacKey(strings.TrimPrefix(name, "hmac."), k.String())
+		default:
+			return nil, fmt.Errorf("auth key %s unknown", name)
+		}
+	}
+	return a, nil
+}
+
+// AddToken adds bearer token of principal.
+func (a *Auth) AddToken(principal, token string) *Auth {
+	a.tokens[token] = principal
+	return a
+}
+
+// AddHmacKey adds hmac secret of key id, which is the principal of signed requests.
+func (a *Auth) AddHmacKey(keyId, secret string) *Auth {
+	a.secrets[keyId] = []byte(secret)
+	return a
+}
+
+// Verify returns the principal of request by credentials in md.
+func (a *Auth) Verify(md Metadata, cmd uint32, body []byte) (string, error) {
+	if token := md[MetaAuthToken]; token != "" {
+		for t, principal := range a.tokens {
+			if hmac.Equal([]byte(t), []byte(token)) {
+				return principal, nil
+			}
+		}
+		return "", fmt.Errorf("invalid token")
+	}
+
+	keyId := md[MetaAuthKey]
+	if keyId == "" {
+		return "", fmt.Errorf("no credential")
+	}
+	secret, ok := a.secrets[keyId]
+	if !ok {
+		return "", fmt.Errorf("unknown key %s", keyId)
+	}
+
+	ts, err := strconv.ParseInt(md[MetaAuthTs], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid timestamp %s", md[MetaAuthTs])
+	}
+	now := time.Now()
+	if skew := now.Sub(time.Unix(ts, 0)); skew > a.MaxSkew || skew < -a.MaxSkew {
+		return "", fmt.Errorf("timestamp %d skew %v", ts, skew)
+	}
+
+	nonce := md[MetaAuthNonce]
+	if nonce == "" {
+		return "", fmt.Errorf("no nonce")
+	}
+	sign, err := hex.DecodeString(md[MetaAuthSign])
+	if err != nil || !hmac.Equal(sign, hmacSign(secret, keyId, md[MetaAuthTs], nonce, cmd, body)) {
+		return "", fmt.Errorf("invalid sign")
+	}
+
+	if !a.useNonce(keyId+"\n"+nonce, now) {
+		return "", fmt.Errorf("nonce %s replayed", nonce)
+	}
+	return keyId, nil
+}
+
+// useNonce reports whether nonce is not used in MaxSkew, and records it.
+func (a *Auth) useNonce(nonce string, now time.Time) bool {
+	a.nonceLock.Lock()
+	defer a.nonceLock.Unlock()
+
+	if now.Sub(a.lastPruned) > time.Second {
+		for n, expire := range a.nonces {
+			if now.After(expire) {
+				delete(a.nonces, n)
+			}
+		}
+		a.lastPruned = now
+	}
+
+	if expire, ok := a.nonces[nonce]; ok && !now.After(expire) {
+		return false
+	}
+	// signed requests with the same nonce are rejected by timestamp after 2 * MaxSkew
+	a.nonces[nonce] = now.Add(2 * a.MaxSkew)
+	return true
+}
+
+func hmacSign(secret []byte, keyId, ts, nonce string, cmd uint32, body []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	fmt.Fprintf(h, "%s\n%s\n%s\n%d\n", keyId, ts, nonce, cmd)
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// authenticate verifies request by Auth of server, and returns ctx carrying the principal.
+func (s *RpcServer) authenticate(ctx context.Context, clientAddr string, cmd uint32, body []byte) (context.Context, *dogError.CodeError) {
+	if s.Auth == nil {
+		return ctx, nil
+	}
+
+	md := IncomingMetadata(ctx)
+	principal, err := s.Auth.Verify(md, cmd, body)
+	if err != nil {
+		dlog.Warn("rpc auth cmd %d from %s reject: %s", cmd, clientAddr, err)
+		return nil, UnauthorizedError
+	}
+
+	// credentials are not seen by filters and handlers
+	rest := make(Metadata, len(md))
+	for k, v := range md {
+		switch k {
+		case MetaAuthToken, MetaAuthKey, MetaAuthTs, MetaAuthNonce, MetaAuthSign:
+		default:
+			rest[k] = v
+		}
+	}
+	ctx = withIncomingMetadata(ctx, rest)
+	return context.WithValue(ctx, principalKey, principal), nil
+}
+
+// Principal returns the authenticated principal carried by ctx of handler, or "" if none.
+func Principal(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	principal, _ := ctx.Value(principalKey).(string)
+	return principal
+}
+
+// TokenCredential returns client interceptor which sends bearer token.
+func TokenCredential(token string) ClientInterceptor {
+	return func(ctx *Context, next Invoker) (uint32, []byte, *dogError.CodeError) {
+		ctx.Ctx = withAuthMetadata(ctx.Ctx, Metadata{MetaAuthToken: token})
+		return next(ctx)
+	}
+}
+
+// signer returns auth metadata of request, it is called when each attempt is sent.
+type signer func(cmd uint32, body []byte) (Metadata, error)
+
+// HmacCredential returns client interceptor which signs requests by secret of key id.
+// requests are signed with fresh timestamp and nonce when they are sent, so that retried
+// and hedged attempts are not rejected as replays.
+func HmacCredential(keyId, secret string) ClientInterceptor {
+	sign := signer(func(cmd uint32, body []byte) (Metadata, error) {
+		var b [16]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return nil, err
+		}
+
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		nonce := hex.EncodeToString(b[:])
+		return Metadata{
+			MetaAuthKey:   keyId,
+			MetaAuthTs:    ts,
+			MetaAuthNonce: nonce,
+			MetaAuthSign:  hex.EncodeToString(hmacSign([]byte(secret), keyId, ts, nonce, cmd, body)),
+		}, nil
+	})
+	return func(ctx *Context, next Invoker) (uint32, []byte, *dogError.CodeError) {
+		ctx.Ctx = context.WithValue(ctx.Ctx, signerKey, sign)
+		return next(ctx)
+	}
+}
+
+// sign sets auth metadata of signer to p. metadata is copied, as the previous attempt may be still sending.
+func (p *DogPacket) sign() error {
+	if p.signer == nil {
+		return nil
+	}
+	auth, err := p.signer(p.Cmd, p.Body)
+	if err != nil {
+		return err
+	}
+
+	md := make(map[string]string, len(p.Meta)+len(auth))
+	for k, v := range p.Meta {
+		md[k] = v
+	}
+	for k, v := range auth {
+		md[k] = v
+	}
+	p.Meta = md
+	return nil
+}
+
+// withAuthMetadata returns a copy of ctx carrying metadata of ctx and auth.
+func withAuthMetadata(ctx context.Context, auth Metadata) context.Context {
+	md := make(Metadata)
+	for k, v := range MetadataFromContext(ctx) {
+		md[k] = v
+	}
+	for k, v := range auth {
+		md[k] = v
+	}
+	return WithMetadata(ctx, md)
+}
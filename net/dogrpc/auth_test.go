@@ -0,0 +1,156 @@
+/**
+ * Copyright 2018 gd Author. All Rights Reserved.
+ * Author: Chuck1024
+ */
+
+package dogrpc
+
+import (
+	"context"
+	"encoding/hex"
+	dogError "github.com/gdp-org/gd/derror"
+	"gopkg.in/ini.v1"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAuth(t *testing.T) {
+	f, err := ini.Load([]byte("[RpcAuth]\nmaxSkew = 60\ntoken.caller-a = abc\nhmac.caller-b = secret\n"))
+	if err != nil {
+		t.Fatalf("load ini occur error:%s", err)
+	}
+	a, err := NewAuthFromSection(f.Section("RpcAuth"))
+	if err != nil {
+		t.Fatalf("new auth occur error:%s", err)
+	}
+
+	s := NewDogRpcServer()
+	s.Auth = a
+	s.AddCtxHandler(1, func(ctx context.Context, req []byte) (uint32, []byte) {
+		return 0, []byte(Principal(ctx))
+	})
+	startPipeServer(t, s, "auth")
+	defer s.ss.Stop()
+
+	newClient := func() *RpcClient {
+		return NewClient(time.Second, 0, false, nil, "", "", "").SetNetwork(NetworkPipe).AddAddr("auth")
+	}
+
+	c := newClient()
+	defer c.Stop()
+	if code, _, _ := c.DogInvoke(1, "hello"); code != uint32(UnauthorizedError.Code()) {
+		t.Fatalf("expect unauthorized, got code %d", code)
+	}
+
+	c = newClient().Use(TokenCredential("abc"))
+	defer c.Stop()
+	if code, rsp, _ := c.DogInvoke(1, "hello"); code != 0 || string(rsp) != "caller-a" {
+		t.Fatalf("unexpected token response code %d principal %s", code, rsp)
+	}
+
+	c = newClient().Use(HmacCredential("caller-b", "secret"))
+	defer c.Stop()
+	if code, rsp, _ := c.DogInvoke(1, "hello"); code != 0 || string(rsp) != "caller-b" {
+		t.Fatalf("unexpected hmac response code %d principal %s", code, rsp)
+	}
+
+	c = newClient().Use(HmacCredential("caller-b", "wrong"))
+	defer c.Stop()
+	if code, _, _ := c.DogInvoke(1, "hello"); code != uint32(UnauthorizedError.Code()) {
+		t.Fatalf("expect unauthorized of wrong secret, got code %d", code)
+	}
+}
+
+func TestAuthReplay(t *testing.T) {
+	a := NewAuth().AddHmacKey("caller", "secret")
+	a.MaxSkew = time.Minute
+
+	sign := func(ts int64) Metadata {
+		tss := strconv.FormatInt(ts, 10)
+		return Metadata{
+			MetaAuthKey:   "caller",
+			MetaAuthTs:    tss,
+			MetaAuthNonce: "nonce",
+			MetaAuthSign:  hex.EncodeToString(hmacSign([]byte("secret"), "caller", tss, "nonce", 1, []byte("body"))),
+		}
+	}
+
+	md := sign(time.Now().Unix())
+	if _, err := a.Verify(md, 1, []byte("body")); err != nil {
+		t.Fatalf("verify occur error:%s", err)
+	}
+	if _, err := a.Verify(md, 1, []byte("body")); err == nil {
+		t.Fatal("expect replay rejected")
+	}
+	if _, err := a.Verify(sign(time.Now().Unix()), 2, []byte("body")); err == nil {
+		t.Fatal("expect sign of another cmd rejected")
+	}
+	if _, err := a.Verify(sign(time.Now().Add(-2*time.Minute).Unix()), 1, []byte("body")); err == nil {
+		t.Fatal("expect expired timestamp rejected")
+	}
+}
+
+func TestAuthRetry(t *testing.T) {
+	a := NewAuth().AddHmacKey("caller", "secret")
+
+	var ctx context.Context
+	HmacCredential("caller", "secret")(&Context{Ctx: context.Background()}, func(c *Context) (uint32, []byte, *dogError.CodeError) {
+		ctx = c.Ctx
+		return 0, nil, nil
+	})
+	p := NewDogPacket(1, []byte("body"))
+	setDogPacketContext(p, ctx)
+
+	// each attempt is signed again, and is not rejected as replay
+	for i := 0; i < 2; i++ {
+		if err := p.sign(); err != nil {
+			t.Fatalf("sign occur error:%s", err)
+		}
+		if _, err := a.Verify(p.Meta, 1, []byte("body")); err != nil {
+			t.Fatalf("verify attempt %d occur error:%s", i, err)
+		}
+	}
+}
+
+func TestAuthHop(t *testing.T) {
+	down := NewDogRpcServer()
+	down.AddCtxHandler(1, func(ctx context.Context, req []byte) (uint32, []byte) {
+		return 0, []byte(IncomingMetadata(ctx)[MetaAuthToken])
+	})
+	startPipeServer(t, down, "auth-down")
+	defer down.ss.Stop()
+
+	dc := NewClient(time.Second, 0, false, nil, "", "", "").SetNetwork(NetworkPipe).AddAddr("auth-down")
+	defer dc.Stop()
+
+	s := NewDogRpcServer()
+	s.Auth = NewAuth().AddToken("caller", "abc")
+	s.AddCtxHandler(1, func(ctx context.Context, req []byte) (uint32, []byte) {
+		if token := IncomingMetadata(ctx)[MetaAuthToken]; token != "" {
+			return uint32(InternalServerError.Code()), []byte(token)
+		}
+		// client of handler has no credential of its own
+		code, rsp, err := dc.DogInvokeCtx(ctx, 1, "hello")
+		if err != nil {
+			return uint32(err.Code()), nil
+		}
+		return code, rsp
+	})
+	startPipeServer(t, s, "auth-up")
+	defer s.ss.Stop()
+
+	c := NewClient(time.Second, 0, false, nil, "", "", "").SetNetwork(NetworkPipe).AddAddr("auth-up").Use(TokenCredential("abc"))
+	defer c.Stop()
+	if code, rsp, err := c.DogInvoke(1, "hello"); err != nil || code != 0 || len(rsp) != 0 {
+		t.Fatalf("unexpected response code %d token %s error %v", code, rsp, err)
+	}
+}
+
+func TestRpcServerAuth(t *testing.T) {
+	s := NewRpcServer()
+	s.Auth = NewAuth().AddToken("caller", "abc")
+	if err := s.Start(); err == nil {
+		t.Fatal("expect error of auth without metadata")
+	}
+}
@@ -0,0 +1,215 @@
+/**
+ * Copyright 2018 gd Author. All Rights Reserved.
+ * Author: Chuck1024
+ */
+
+package dogrpc
+
+import (
+	"hash/crc32"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Node is a server of RpcClient, from AddAddr or discovery.
+type Node struct {
+	Addr   string
+	Weight int
+
+	pending int32
+	// current weight of RoundRobinBalancer
+	current int
+}
+
+// Pending returns the number of requests waiting for response from node.
+func (n *Node) Pending() int32 {
+	return atomic.LoadInt32(&n.pending)
+}
+
+// Balancer chooses a node for each request. Update is called when nodes change,
+// Pick may be called concurrently and returns nil if there is no node.
+type Balancer interface {
+	Update(nodes []*Node)
+	Pick(key string) *Node
+}
+
+/*
+ * random balancer, default
+ */
+
+type RandomBalancer struct {
+	lock  sync.Mutex
+	rand  *rand.Rand
+	nodes []*Node
+}
+
+func NewRandomBalancer() *RandomBalancer {
+	return &RandomBalancer{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (b *RandomBalancer) Update(nodes []*Node) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.nodes = nodes
+}
+
+func (b *RandomBalancer) Pick(key string) *Node {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(b.nodes) == 0 {
+		return nil
+	}
+	return b.nodes[b.rand.Intn(len(b.nodes))]
+}
+
+/*
+ * smooth weighted round robin balancer
+ */
+
+type RoundRobinBalancer struct {
+	lock  sync.Mutex
+	nodes []*Node
+}
+
+func NewRoundRobinBalancer() *RoundRobinBalancer {
+	return &RoundRobinBalancer{}
+}
+
+func (b *RoundRobinBalancer) Update(nodes []*Node) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.nodes = nodes
+}
+
+func (b *RoundRobinBalancer) Pick(key string) *Node {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	var best *Node
+	total := 0
+	for _, n := range b.nodes {
+		n.current += n.Weight
+		total += n.Weight
+		if best == nil || n.current > best.current {
+			best = n
+		}
+	}
+
+	if best != nil {
+		best.current -= total
+	}
+	return best
+}
+
+/*
+ * least pending requests balancer
+ */
+
+type LeastPendingBalancer struct {
+	lock  sync.RWMutex
+	nodes []*Node
+	next  uint32
+}
+
+func NewLeastPendingBalancer() *LeastPendingBalancer {
+	return &LeastPendingBalancer{}
+}
+
+func (b *LeastPendingBalancer) Update(nodes []*Node) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.nodes = nodes
+}
+
+func (b *LeastPendingBalancer) Pick(key string) *Node {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	n := len(b.nodes)
+	if n == 0 {
+		return nil
+	}
+
+	// start from a rotating index, so that idle nodes are used evenly.
+	start := int(atomic.AddUint32(&b.next, 1)) % n
+	var best *Node
+	for i := 0; i < n; i++ {
+		node := b.nodes[(start+i)%n]
+		if best == nil || node.Pending() < best.Pending() {
+			best = node
+		}
+	}
+	return best
+}
+
+/*
+ * consistent hash balancer, key is set by WithBalanceKey
+ */
+
+const defaultVirtualNodes = 160
+
+type ConsistentHashBalancer struct {
+	lock         sync.RWMutex
+	VirtualNodes int
+	hashes       []uint32
+	ring         map[uint32]*Node
+	random       *RandomBalancer
+}
+
+func NewConsistentHashBalancer() *ConsistentHashBalancer {
+	return &ConsistentHashBalancer{
+		VirtualNodes: defaultVirtualNodes,
+		random:       NewRandomBalancer(),
+	}
+}
+
+func (b *ConsistentHashBalancer) Update(nodes []*Node) {
+	virtualNodes := b.VirtualNodes
+	if virtualNodes <= 0 {
+		virtualNodes = defaultVirtualNodes
+	}
+
+	ring := make(map[uint32]*Node)
+	hashes := make([]uint32, 0, len(nodes)*virtualNodes)
+	for _, n := range nodes {
+		for i := 0; i < virtualNodes*n.Weight; i++ {
+			h := crc32.ChecksumIEEE([]byte(n.Addr + "#" + strconv.Itoa(i)))
+			if _, ok := ring[h]; ok {
+				continue
+			}
+			ring[h] = n
+			hashes = append(hashes, h)
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.ring = ring
+	b.hashes = hashes
+	b.random.Update(nodes)
+}
+
+// Pick returns a random node if key is empty.
+func (b *ConsistentHashBalancer) Pick(key string) *Node {
+	if key == "" {
+		return b.random.Pick(key)
+	}
+
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	if len(b.hashes) == 0 {
+		return nil
+	}
+
+	h := crc32.ChecksumIEEE([]byte(key))
+	idx := sort.Search(len(b.hashes), func(i int) bool { return b.hashes[i] >= h })
+	if idx == len(b.hashes) {
+		idx = 0
+	}
+	return b.ring[b.hashes[idx]]
+}
@@ -0,0 +1,73 @@
+/**
+ * Copyright 2018 gd Author. All Rights Reserved.
+ * Author: Chuck1024
+ */
+
+package dogrpc
+
+import (
+	"testing"
+)
+
+func TestRoundRobinBalancer(t *testing.T) {
+	b := NewRoundRobinBalancer()
+	b.Update([]*Node{{Addr: "a", Weight: 3}, {Addr: "b", Weight: 1}})
+
+	count := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		count[b.Pick("").Addr]++
+	}
+	if count["a"] != 6 || count["b"] != 2 {
+		t.Fatalf("unexpected count %v", count)
+	}
+}
+
+func TestLeastPendingBalancer(t *testing.T) {
+	nodes := []*Node{{Addr: "a", Weight: 1, pending: 2}, {Addr: "b", Weight: 1}, {Addr: "c", Weight: 1, pending: 1}}
+	b := NewLeastPendingBalancer()
+	b.Update(nodes)
+
+	for i := 0; i < 3; i++ {
+		if n := b.Pick(""); n.Addr != "b" {
+			t.Fatalf("unexpected node %s", n.Addr)
+		}
+	}
+}
+
+func TestConsistentHashBalancer(t *testing.T) {
+	b := NewConsistentHashBalancer()
+	b.Update([]*Node{{Addr: "a", Weight: 1}, {Addr: "b", Weight: 1}, {Addr: "c", Weight: 1}})
+	n := b.Pick("user-1")
+
+	// removing another node keeps the key on its node
+	var others []*Node
+	for _, addr := range []string{"a", "b", "c"} {
+		if addr == n.Addr {
+			others = append(others, n)
+			continue
+		}
+		if len(others) < 2 {
+			others = append(others, &Node{Addr: addr, Weight: 1})
+		}
+	}
+	b.Update(others)
+	if b.Pick("user-1").Addr != n.Addr {
+		t.Fatalf("key moved from node %s", n.Addr)
+	}
+}
+
+func TestRpcClientSetNodes(t *testing.T) {
+	c := NewClient(100, 0, false, nil, "", "", "")
+	c.AddAddr("127.0.0.1:10240").AddAddr("127.0.0.1:10241")
+	c.connect("127.0.0.1:10241", true)
+
+	c.setNodes(map[string]int{"127.0.0.1:10240": 2})
+	if len(c.nodes) != 1 || c.nodes[0].Weight != 2 {
+		t.Fatalf("unexpected nodes %v", c.nodes)
+	}
+	if _, ok := c.Cm["127.0.0.1:10241"]; ok {
+		t.Fatal("client of removed node is not stopped")
+	}
+
+	c.Stop()
+}
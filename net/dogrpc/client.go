@@ -10,6 +10,7 @@ import (
 	dogError "github.com/gdp-org/gd/derror"
 	"github.com/gdp-org/gd/dlog"
 	"io"
+	"math/rand"
 	"runtime"
 	"sync"
 	"sync/atomic"
@@ -26,12 +27,20 @@ type Client struct {
 	RequestTimeout       time.Duration
 	SendBufferSize       int
 	RecvBufferSize       int
+	MaxConnAge           time.Duration // connections are recycled after about it, 0 never
+	Ping                 func() Packet // makes ping packet, nil disables ping
+	PingInterval         time.Duration // idle connections are pinged every interval, default DefaultPingInterval
 	pendingRequestsCount uint32
+	liveConns            int32
+	dialFailed           int32
+	unhealthy            int32
+	load                 atomic.Value
 	requestsChan         chan *AsyncResult
 	clientStopChan       chan struct{}
 	stopWg               sync.WaitGroup
 	stopLock             sync.Mutex
 	startLock            sync.Mutex
+	streams              sync.Map
 	Encoder              MessageEncoderFunc
 	Decoder              MessageDecoderFunc
 }
@@ -71,6 +80,9 @@ func (c *Client) Start() {
 	if c.DialRetryTime < 0 {
 		c.DialRetryTime = DefaultDialRetryTime
 	}
+	if c.PingInterval <= 0 {
+		c.PingInterval = DefaultPingInterval
+	}
 
 	c.requestsChan = make(chan *AsyncResult, c.PendingRequests)
 	c.clientStopChan = make(chan struct{})
@@ -93,6 +105,7 @@ func (c *Client) Stop() {
 	c.stopLock.Lock()
 	if c.clientStopChan == nil {
 		dlog.Error("the client must be started before stopping it")
+		return
 	}
 	close(c.clientStopChan)
 	c.stopWg.Wait()
@@ -133,13 +146,16 @@ func clientHandler(c *Client) {
 
 		// connect fail
 		if err != nil {
-			// need to reconnect
-			if dialRetryTime > 0 {
+			atomic.StoreInt32(&c.dialFailed, 1)
+			// need to reconnect, keep reconnecting while other connections are alive
+			if dialRetryTime > 0 || atomic.LoadInt32(&c.liveConns) > 0 {
 				select { // if client is already stop, quit
 				case <-c.clientStopChan:
 					return
 				case <-time.After(time.Second):
-					dialRetryTime--
+					if dialRetryTime > 0 {
+						dialRetryTime--
+					}
 					continue
 				}
 			} else {
@@ -147,7 +163,10 @@ func clientHandler(c *Client) {
 			}
 		}
 
+		atomic.StoreInt32(&c.dialFailed, 0)
+		atomic.AddInt32(&c.liveConns, 1)
 		clientHandleConnection(c, conn)
+		atomic.AddInt32(&c.liveConns, -1)
 
 		dialRetryTime = c.DialRetryTime
 
@@ -167,12 +186,52 @@ func clientHandleConnection(c *Client, conn io.ReadWriteCloser) {
 
 	pendingRequests := make(map[uint32]*AsyncResult)
 	var pendingRequestLock sync.Mutex
+	// streams opened on this connection, only accessed by writer until it is done.
+	streams := make(map[uint32]*Stream)
+
+	// closed by reader when server is shutting down, writer stops sending new requests on this connection.
+	goAwayChan := make(chan struct{})
+	// closed when connection reaches max age, writer stops sending new requests and returns after
+	// pending requests are done, meanwhile a new connection is established.
+	retireChan := make(chan struct{})
+	if c.MaxConnAge > 0 {
+		t := time.AfterFunc(connAge(c.MaxConnAge), func() { close(retireChan) })
+		defer t.Stop()
+	}
+
+	// pings sent on this connection, and number of packets written for keepalive to check idle.
+	pingChan := make(chan *AsyncResult, 1)
+	var written uint64
+	// version of server told by the first pong, requests are not sent before it if client pings.
+	var version uint32
+	negotiated := make(chan struct{})
+	if c.Ping == nil {
+		close(negotiated)
+	}
 
-	go clientWriter(c, conn, pendingRequests, &pendingRequestLock, stopChan, writerDone)
-	go clientReader(c, conn, pendingRequests, &pendingRequestLock, readerDone)
+	go clientWriter(c, conn, pendingRequests, &pendingRequestLock, streams, stopChan, goAwayChan, retireChan, pingChan, &written, &version, negotiated, writerDone)
+	go clientReader(c, conn, pendingRequests, &pendingRequestLock, goAwayChan, readerDone)
+	if c.Ping != nil {
+		go clientKeepalive(c, conn, pingChan, &written, &version, negotiated, stopChan)
+	}
 
+	if !clientCloseConnection(c, conn, pendingRequests, streams, stopChan, writerDone, readerDone, retireChan) {
+		dlog.Debug("client [%s] connection reaches max age, recycle it", c.Addr)
+		c.stopWg.Add(1)
+		go func() {
+			defer c.stopWg.Done()
+			clientCloseConnection(c, conn, pendingRequests, streams, stopChan, writerDone, readerDone, nil)
+		}()
+	}
+}
+
+// clientCloseConnection waits until writer or reader is done or client is stopped, then closes conn and
+// fails requests pending on it. it returns false at once if retireChan is closed before.
+func clientCloseConnection(c *Client, conn io.ReadWriteCloser, pendingRequests map[uint32]*AsyncResult, streams map[uint32]*Stream, stopChan chan struct{}, writerDone, readerDone <-chan error, retireChan <-chan struct{}) bool {
 	var err error
 	select {
+	case <-retireChan:
+		return false
 	case err = <-writerDone:
 		close(stopChan)
 		conn.Close()
@@ -199,9 +258,30 @@ func clientHandleConnection(c *Client, conn io.ReadWriteCloser) {
 			close(m.Done)
 		}
 	}
+
+	for _, st := range streams {
+		st.finish(StreamClosedError)
+	}
+	return true
+}
+
+// connAge returns a random age in (0.9 * maxAge, maxAge], so that connections are not recycled at once.
+func connAge(maxAge time.Duration) time.Duration {
+	return maxAge - time.Duration(rand.Int63n(int64(maxAge)/10+1))
+}
+
+// LiveConns returns the number of established connections.
+func (c *Client) LiveConns() int {
+	return int(atomic.LoadInt32(&c.liveConns))
+}
+
+// Healthy reports whether client has established connection or is not failed to dial yet,
+// and last ping is answered by server which is not draining.
+func (c *Client) Healthy() bool {
+	return (atomic.LoadInt32(&c.liveConns) > 0 || atomic.LoadInt32(&c.dialFailed) == 0) && atomic.LoadInt32(&c.unhealthy) == 0
 }
 
-func clientWriter(c *Client, conn io.Writer, pendingRequests map[uint32]*AsyncResult, pendingRequestLock *sync.Mutex, stopChan <-chan struct{}, done chan<- error) {
+func clientWriter(c *Client, conn io.Writer, pendingRequests map[uint32]*AsyncResult, pendingRequestLock *sync.Mutex, streams map[uint32]*Stream, stopChan <-chan struct{}, goAwayChan <-chan struct{}, retireChan <-chan struct{}, pingChan <-chan *AsyncResult, written *uint64, version *uint32, negotiated <-chan struct{}, done chan<- error) {
 	var err error
 	defer func() {
 		done <- err
@@ -213,19 +293,58 @@ func clientWriter(c *Client, conn io.Writer, pendingRequests map[uint32]*AsyncRe
 		return
 	}
 
+	// frames of opened streams are sent on this connection.
+	streamChan := make(chan *AsyncResult, c.PendingRequests)
 	t := time.NewTimer(c.FlushDelay)
 	var flushChan <-chan time.Time
+	// requests are taken after version is negotiated, and are left to the next connection after
+	// server goes away or connection retires.
+	var requestsChan chan *AsyncResult
+	// checks whether the retired connection is idle.
+	var idleChan <-chan time.Time
+	var retireAt time.Time
 	for {
 		var m *AsyncResult
 		select {
-		case m = <-c.requestsChan:
+		case m = <-requestsChan:
+		case m = <-streamChan:
+		case m = <-pingChan:
 		default:
 			runtime.Gosched()
 
 			select {
 			case <-stopChan:
 				return
-			case m = <-c.requestsChan:
+			case <-negotiated:
+				requestsChan = c.requestsChan
+				negotiated = nil
+				continue
+			case <-goAwayChan:
+				requestsChan = nil
+				goAwayChan = nil
+				negotiated = nil
+				continue
+			case <-retireChan:
+				requestsChan = nil
+				retireChan = nil
+				negotiated = nil
+				retireAt = time.Now()
+				idle := time.NewTicker(retireCheckInterval)
+				defer idle.Stop()
+				idleChan = idle.C
+				continue
+			case <-idleChan:
+				// requests not responded within RequestTimeout are failed
+				if !clientConnIdle(pendingRequests, pendingRequestLock, streams) && time.Since(retireAt) < c.RequestTimeout {
+					continue
+				}
+				if err = enc.Flush(); err != nil {
+					err = fmt.Errorf("Cannot flush requests to underlying stream:%s [%s] ", c.Addr, err)
+				}
+				return
+			case m = <-requestsChan:
+			case m = <-streamChan:
+			case m = <-pingChan:
 			case <-flushChan:
 				if err = enc.Flush(); err != nil {
 					err = fmt.Errorf("Cannot flush requests to underlying stream:%s [%s] ", c.Addr, err)
@@ -251,6 +370,16 @@ func clientWriter(c *Client, conn io.Writer, pendingRequests map[uint32]*AsyncRe
 			continue
 		}
 
+		if m.stream != nil {
+			st := m.stream
+			m.stream = nil
+			// stream is finished before opened, no need to open it.
+			if !st.bind(streamChan) {
+				continue
+			}
+			streams[st.id] = st
+		}
+
 		if !m.isSkipResponse() {
 			msgID := m.Request.ID()
 
@@ -279,13 +408,32 @@ func clientWriter(c *Client, conn io.Writer, pendingRequests map[uint32]*AsyncRe
 			}
 		}
 
+		if p, ok := m.Request.(*DogPacket); ok && c.Ping != nil {
+			negotiateVersion(p, uint8(atomic.LoadUint32(version)))
+		}
 		if err = enc.Encode(m.Request); err != nil {
 			return
 		}
+		atomic.AddUint64(written, 1)
 	}
 }
 
-func clientReader(c *Client, conn io.Reader, pendingRequests map[uint32]*AsyncResult, pendingRequestLock *sync.Mutex, done chan<- error) {
+// clientConnIdle reports whether there is no pending request or opened stream on connection.
+func clientConnIdle(pendingRequests map[uint32]*AsyncResult, pendingRequestLock *sync.Mutex, streams map[uint32]*Stream) bool {
+	for id, st := range streams {
+		select {
+		case <-st.done:
+			delete(streams, id)
+		default:
+		}
+	}
+
+	pendingRequestLock.Lock()
+	defer pendingRequestLock.Unlock()
+	return len(pendingRequests) == 0 && len(streams) == 0
+}
+
+func clientReader(c *Client, conn io.Reader, pendingRequests map[uint32]*AsyncResult, pendingRequestLock *sync.Mutex, goAwayChan chan<- struct{}, done chan<- error) {
 	var err error
 	defer func() {
 		if r := recover(); r != nil {
@@ -308,6 +456,22 @@ func clientReader(c *Client, conn io.Reader, pendingRequests map[uint32]*AsyncRe
 			return
 		}
 
+		if sp, ok := packet.(*DogPacket); ok && sp.Cmd == CmdGoAway && sp.Seq == 0 {
+			if goAwayChan != nil {
+				dlog.Info("client [%s] server is shutting down, stop sending requests", c.Addr)
+				close(goAwayChan)
+				goAwayChan = nil
+			}
+			continue
+		}
+
+		if sp, ok := packet.(*DogPacket); ok && sp.Stream != 0 {
+			if st, ok := c.streams.Load(sp.Seq); ok {
+				st.(*Stream).handleFrame(sp)
+			}
+			continue
+		}
+
 		msgID := packet.ID()
 		pendingRequestLock.Lock()
 		m, ok := pendingRequests[msgID]
@@ -343,9 +507,28 @@ func (c *Client) SendUDP(req Packet) (err *dogError.CodeError) {
 	return nil
 }
 
+// deadlinePacket is implemented by packets which carry the caller deadline to server, such as DogPacket.
+type deadlinePacket interface {
+	SetDeadline(t time.Time)
+}
+
+// signPacket is implemented by packets which are signed for each attempt, such as DogPacket.
+type signPacket interface {
+	sign() error
+}
+
 func (c *Client) CallTimeout(req Packet, timeout time.Duration, retryNum uint32) (rsp Packet, err *dogError.CodeError) {
 	var tryNum uint32
 retry:
+	if dp, ok := req.(deadlinePacket); ok {
+		dp.SetDeadline(time.Now().Add(timeout))
+	}
+	if sp, ok := req.(signPacket); ok {
+		if sErr := sp.sign(); sErr != nil {
+			dlog.Error("CallTimeout sign occur error:%s", sErr)
+			return nil, InternalServerError
+		}
+	}
 	var m *AsyncResult
 	if m, err = c.callAsync(req, false, true); err != nil {
 		return nil, err
@@ -436,6 +619,7 @@ type AsyncResult struct {
 	t            time.Time
 	canceled     uint32
 	skipResponse bool
+	stream       *Stream
 }
 
 func (m *AsyncResult) Cancel() {
@@ -468,6 +652,7 @@ func releaseAsyncResult(m *AsyncResult) {
 	m.Done = nil
 	m.Request = nil
 	m.t = zeroTime
+	m.stream = nil
 	asyncResultPool.Put(m)
 }
 
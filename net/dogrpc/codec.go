@@ -0,0 +1,136 @@
+/**
+ * Copyright 2019 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package dogrpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/ugorji/go/codec"
+	"sync"
+)
+
+// Codec marshals bodies of wrapped handlers. the codec id is carried in DogPacket Header.Padding,
+// server replies with the codec of request.
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+const (
+	CodecJson     uint8 = 0
+	CodecProtobuf uint8 = 1
+	CodecMsgpack  uint8 = 2
+)
+
+var (
+	codecs     = make(map[uint8]Codec)
+	codecsLock sync.RWMutex
+)
+
+func init() {
+	RegisterCodec(CodecJson, jsonCodec{})
+	RegisterCodec(CodecProtobuf, protobufCodec{})
+	RegisterCodec(CodecMsgpack, msgpackCodec{})
+}
+
+// RegisterCodec registers codec with id, the former one with the same id is replaced.
+func RegisterCodec(id uint8, c Codec) {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+	codecs[id] = c
+}
+
+// GetCodec returns the codec of id, or nil if not registered.
+func GetCodec(id uint8) Codec {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	return codecs[id]
+}
+
+func withCodec(ctx context.Context, id uint8) context.Context {
+	return context.WithValue(ctx, codecKey, id)
+}
+
+// CodecFromContext returns the codec id of request carried by ctx, CodecJson if none.
+func CodecFromContext(ctx context.Context) uint8 {
+	if ctx == nil {
+		return CodecJson
+	}
+	id, _ := ctx.Value(codecKey).(uint8)
+	return id
+}
+
+// envelope is the response body of wrapped handlers.
+type envelope struct {
+	Code    uint32      `json:"code" codec:"code"`
+	Message string      `json:"message" codec:"message"`
+	Result  interface{} `json:"result" codec:"result"`
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// protobufCodec only marshals the result of envelope, code is carried in header ErrCode.
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string {
+	return "protobuf"
+}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	if e, ok := v.(*envelope); ok {
+		if e.Result == nil {
+			return nil, nil
+		}
+		v = e.Result
+	}
+
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec marshal %T is not proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf codec unmarshal %T is not proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+var msgpackHandle = &codec.MsgpackHandle{}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var b []byte
+	err := codec.NewEncoderBytes(&b, msgpackHandle).Encode(v)
+	return b, err
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return codec.NewDecoderBytes(data, msgpackHandle).Decode(v)
+}
@@ -0,0 +1,72 @@
+/**
+ * Copyright 2019 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package dogrpc
+
+import (
+	"context"
+	de "github.com/gdp-org/gd/derror"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"testing"
+)
+
+func TestWrapMsgpackCodec(t *testing.T) {
+	f, err := wrap(func(req *wrapTestReq) (code uint32, message string, err error, ret *wrapTestResp) {
+		return uint32(de.RpcSuccess), "ok", nil, &wrapTestResp{Ret: req.Data}
+	})
+	if err != nil {
+		t.Fatalf("wrap occur error:%s", err)
+	}
+
+	cd := GetCodec(CodecMsgpack)
+	req, err := cd.Marshal(&wrapTestReq{Data: "hello"})
+	if err != nil {
+		t.Fatalf("marshal occur error:%s", err)
+	}
+
+	code, rsp := f(withCodec(context.Background(), CodecMsgpack), req)
+	if code != uint32(de.RpcSuccess) {
+		t.Fatalf("unexpected code %d", code)
+	}
+
+	ret := struct {
+		Code   uint32       `codec:"code"`
+		Result wrapTestResp `codec:"result"`
+	}{}
+	if err := cd.Unmarshal(rsp, &ret); err != nil {
+		t.Fatalf("unmarshal rsp occur error:%s", err)
+	}
+	if ret.Code != uint32(de.RpcSuccess) || ret.Result.Ret != "hello" {
+		t.Fatalf("unexpected ret %v", ret)
+	}
+}
+
+func TestWrapProtobufCodec(t *testing.T) {
+	f, err := wrap(func(req *wrappers.StringValue) (code uint32, message string, err error, ret *wrappers.StringValue) {
+		return uint32(de.RpcSuccess), "ok", nil, &wrappers.StringValue{Value: req.Value + "!"}
+	})
+	if err != nil {
+		t.Fatalf("wrap occur error:%s", err)
+	}
+
+	cd := GetCodec(CodecProtobuf)
+	req, err := cd.Marshal(&wrappers.StringValue{Value: "hello"})
+	if err != nil {
+		t.Fatalf("marshal occur error:%s", err)
+	}
+
+	code, rsp := f(withCodec(context.Background(), CodecProtobuf), req)
+	if code != uint32(de.RpcSuccess) {
+		t.Fatalf("unexpected code %d", code)
+	}
+
+	ret := &wrappers.StringValue{}
+	if err := cd.Unmarshal(rsp, ret); err != nil {
+		t.Fatalf("unmarshal rsp occur error:%s", err)
+	}
+	if ret.Value != "hello!" {
+		t.Fatalf("unexpected ret %s", ret.Value)
+	}
+}
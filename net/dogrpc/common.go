@@ -11,13 +11,26 @@ import (
 )
 
 const (
-	DefaultConcurrency     = 8 * 1024
-	DefaultRequestTimeout  = 20 * time.Second
-	DefaultPendingMessages = 32 * 1024
-	DefaultFlushDelay      = -1
-	DefaultBufferSize      = 64 * 1024
-	DefaultDialRetryTime   = 0
-	DefaultConnectNumbers  = 1
+	DefaultConcurrency       = 8 * 1024
+	DefaultRequestTimeout    = 20 * time.Second
+	DefaultPendingMessages   = 32 * 1024
+	DefaultFlushDelay        = -1
+	DefaultBufferSize        = 64 * 1024
+	DefaultDialRetryTime     = 0
+	DefaultConnectNumbers    = 1
+	DefaultStreamWindow      = 64
+	DefaultDiscoveryInterval = time.Second
+	DefaultShutdownTimeout   = 20 // second
+	DefaultPingInterval      = 10 * time.Second
+	DefaultPingTimeout       = 3 * time.Second
+
+	retireCheckInterval = 10 * time.Millisecond
+)
+
+// control commands of dog packet, which are reserved and not dispatched to handlers.
+const (
+	CmdGoAway uint32 = 0xFFFFFFFF // server is shutting down, sent to clients with seq 0
+	CmdPing   uint32 = 0xFFFFFFFE // ping of client, answered with ServerLoad by server
 )
 
 var (
@@ -25,6 +38,11 @@ var (
 	OverflowError       = derror.SetCodeType(10002, "overflow error.")
 	InternalServerError = derror.SetCodeType(10003, "interval server error.")
 	InvalidParam        = derror.SetCodeType(10004, "invalid param")
+	StreamResetError    = derror.SetCodeType(10005, "stream reset error.").SetMsg("stream reset")
+	StreamClosedError   = derror.SetCodeType(10006, "stream closed error.").SetMsg("stream closed")
+	BreakerOpenError    = derror.SetCodeType(10007, "breaker open error.").SetMsg("circuit breaker open")
+	UnauthorizedError   = derror.SetCodeType(10008, "unauthorized error.").SetMsg("unauthorized")
+	ShutdownError       = derror.SetCodeType(10009, "shutdown error.").SetMsg("server is shutting down")
 )
 
 var closedFlushChan = make(chan time.Time)
@@ -5,10 +5,105 @@
 
 package dogrpc
 
+import (
+	"context"
+	"time"
+)
+
 type Context struct {
 	ClientAddr string
 	Seq        uint32
+	Cmd        uint32
 	Method     string
 	Handler    RpcHandlerFunc
 	Req        []byte
+	// Meta is the metadata sent by client, nil if none.
+	Meta Metadata
+	// Ctx is passed to ctx handlers. filters may replace it with a derived context before calling next.
+	Ctx context.Context
+	// StartTime is when the request is received by server or invoked by client.
+	StartTime time.Time
+}
+
+// Metadata is the key/value pairs carried in the DogPacket header extension.
+type Metadata map[string]string
+
+// MetaTraceId is the metadata key of trace id.
+const MetaTraceId = "trace_id"
+
+type ctxKey int
+
+const (
+	traceIdKey ctxKey = iota
+	clientAddrKey
+	metadataKey
+	codecKey
+	balanceKeyKey
+	principalKey
+	signerKey
+	incomingMetadataKey
+)
+
+// WithBalanceKey returns a copy of ctx carrying key, which is used by ConsistentHashBalancer to choose server.
+func WithBalanceKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, balanceKeyKey, key)
+}
+
+func balanceKey(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	key, _ := ctx.Value(balanceKeyKey).(string)
+	return key
+}
+
+// WithMetadata returns a copy of ctx carrying md. DogInvokeCtx sends it to server.
+func WithMetadata(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, metadataKey, md)
+}
+
+// MetadataFromContext returns the metadata carried by ctx to be sent, or nil if none.
+func MetadataFromContext(ctx context.Context) Metadata {
+	if ctx == nil {
+		return nil
+	}
+	md, _ := ctx.Value(metadataKey).(Metadata)
+	return md
+}
+
+// IncomingMetadata returns the metadata sent by client of request handled by ctx, or nil if none.
+// it is not sent by DogInvokeCtx with ctx of handler, so that it is not passed to other servers.
+func IncomingMetadata(ctx context.Context) Metadata {
+	if ctx == nil {
+		return nil
+	}
+	md, _ := ctx.Value(incomingMetadataKey).(Metadata)
+	return md
+}
+
+func withIncomingMetadata(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, incomingMetadataKey, md)
+}
+
+// WithTraceId returns a copy of ctx carrying trace id.
+func WithTraceId(ctx context.Context, traceId string) context.Context {
+	return context.WithValue(ctx, traceIdKey, traceId)
+}
+
+// TraceId returns the trace id carried by ctx, or "" if none.
+func TraceId(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	traceId, _ := ctx.Value(traceIdKey).(string)
+	return traceId
+}
+
+// ClientAddr returns the remote address of the request carried by ctx, or "" if none.
+func ClientAddr(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	addr, _ := ctx.Value(clientAddrKey).(string)
+	return addr
 }
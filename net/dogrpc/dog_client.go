@@ -6,14 +6,9 @@
 package dogrpc
 
 import (
-	"bufio"
-	"crypto/tls"
-	"encoding/json"
+	"context"
 	dogError "github.com/gdp-org/gd/derror"
 	"github.com/gdp-org/gd/dlog"
-	"io"
-	"math/rand"
-	"net"
 	"time"
 )
 
@@ -23,78 +18,88 @@ import (
 
 // dog packet establish connection
 func (c *RpcClient) DogConnect() (*Client, error) {
-	addr := &net.TCPAddr{}
+	return c.dogConnectKey("")
+}
 
-	if len(c.addrs) > 0 {
-		rand.Seed(time.Now().UnixNano())
-		idx := rand.Intn(len(c.addrs))
-		addr = c.addrs[idx]
-	} else {
-		return nil, InternalServerError
+func (c *RpcClient) dogConnectKey(key string) (*Client, error) {
+	n, err := c.pickNode(key, "")
+	if err != nil {
+		return nil, err
 	}
 
-	cc, ok := c.Cm[addr.String()]
-	if !ok {
-		c.cmMutex.Lock()
-		defer c.cmMutex.Unlock()
-		if cc, ok = c.Cm[addr.String()]; !ok {
-			cc = &Client{
-				Addr:           addr.String(),
-				RequestTimeout: time.Millisecond * time.Duration(c.Timeout),
-				Encoder: func(w io.Writer, bufferSize int) (encoder MessageEncoder, err error) {
-					return &DogPacketEncoder{bw: bufio.NewWriterSize(w, bufferSize)}, nil
-				},
-				Decoder: func(r io.Reader, bufferSize int) (decoder MessageDecoder, err error) {
-					return &DogPacketDecoder{br: bufio.NewReaderSize(r, bufferSize)}, nil
-				},
-			}
-
-			if c.TlsCfg != nil {
-				cc.Dial = func(addr string) (conn io.ReadWriteCloser, err error) {
-					c, err := tls.DialWithDialer(dialer, DefaultDialNetWork, addr, c.TlsCfg)
-					if err != nil {
-						return nil, err
-					}
-					return c, err
-				}
-			}
-
-			cc.Start()
-			c.Cm[addr.String()] = cc
-		} else {
-			dlog.Warn("Addr %s already created.", addr)
-		}
-	} else {
-		if cc.clientStopChan == nil {
-			cc.Start()
+	return c.connect(n.Addr, true), nil
+}
+
+// dog packet. Invoke rpc call
+func (c *RpcClient) DogInvoke(cmd uint32, req interface{}, client ...*Client) (code uint32, rsp []byte, err *dogError.CodeError) {
+	return c.DogInvokeCtx(context.Background(), cmd, req, client...)
+}
+
+// dog packet. Invoke rpc call with the deadline, trace id and metadata of ctx sent to server
+func (c *RpcClient) DogInvokeCtx(ctx context.Context, cmd uint32, req interface{}, client ...*Client) (code uint32, rsp []byte, err *dogError.CodeError) {
+	cd := GetCodec(c.Codec)
+	if cd == nil {
+		dlog.Error("Invoke codec %d not register", c.Codec)
+		return code, nil, InvalidParam
+	}
+
+	var body []byte
+	if req != nil {
+		var mErr error
+		if body, mErr = cd.Marshal(req); mErr != nil {
+			dlog.Error("Invoke marshal %s occur error:%s", cd.Name(), mErr)
+			return code, nil, InvalidParam
 		}
 	}
 
-	return cc, nil
+	return c.invoke(ctx, cmd, nextDogSeq(), body, func(ctx *Context) (uint32, []byte, *dogError.CodeError) {
+		return c.dogInvoke(ctx.Ctx, ctx.Cmd, ctx.Seq, ctx.Req, client...)
+	})
 }
 
-// dog packet. Invoke rpc call
-func (c *RpcClient) DogInvoke(cmd uint32, req interface{}, client ...*Client) (code uint32, rsp []byte, err *dogError.CodeError) {
+func (c *RpcClient) dogInvoke(ctx context.Context, cmd uint32, seq uint32, body []byte, client ...*Client) (code uint32, rsp []byte, err *dogError.CodeError) {
+	reqPkt := NewDogPacketWithSeq(cmd, body, seq)
+	reqPkt.Version = VersionCrc32c
+	if c.DogVersion > 0 {
+		reqPkt.Version = c.DogVersion
+	}
+	reqPkt.Padding = c.Codec
+	setDogPacketContext(reqPkt, ctx)
+
+	var rspPkt Packet
+	if len(client) == 0 && c.RetryPolicy != nil {
+		deadline, _ := ctx.Deadline()
+		if rspPkt, err = c.callPolicy(balanceKey(ctx), true, reqPkt, c.requestTimeout(true), deadline); err != nil {
+			dlog.Error("Invoke call occur error:%v ", err)
+			return code, nil, err
+		}
+		return rspPkt.(*DogPacket).ErrCode, rspPkt.(*DogPacket).Body, nil
+	}
+
 	var ct *Client
 	if len(client) == 0 {
-		cc, err := c.DogConnect()
-		if err != nil {
-			dlog.Error("Invoke connect occur error:%s", err)
-			return code, nil, InternalServerError
+		cc, release, cErr := c.acquire(balanceKey(ctx), "", true)
+		if cErr != nil {
+			dlog.Error("Invoke connect occur error:%s", cErr)
+			return code, nil, cErr
 		}
 		ct = cc
+		defer func() { release(err == nil) }()
 	} else {
 		ct = client[0]
 	}
 
-	var body []byte
-	if req != nil {
-		body, _ = json.Marshal(req)
+	timeout := ct.RequestTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		if d := time.Until(deadline); d < timeout {
+			timeout = d
+		}
+		if timeout <= 0 {
+			return code, nil, TimeOutError
+		}
 	}
 
-	var reqPkt, rspPkt Packet
-	reqPkt = NewDogPacket(cmd, body)
-	if rspPkt, err = ct.CallRetry(reqPkt, c.RetryNum); err != nil {
+	if rspPkt, err = ct.CallTimeout(reqPkt, timeout, c.RetryNum); err != nil {
 		dlog.Error("Invoke CallRetry occur error:%v ", err)
 		return code, nil, err
 	}
@@ -104,3 +109,24 @@ func (c *RpcClient) DogInvoke(cmd uint32, req interface{}, client ...*Client) (c
 
 	return code, rsp, nil
 }
+
+// setDogPacketContext sets trace id, metadata, deadline and signer of ctx to p.
+func setDogPacketContext(p *DogPacket, ctx context.Context) {
+	p.signer, _ = ctx.Value(signerKey).(signer)
+
+	md := MetadataFromContext(ctx)
+	traceId := TraceId(ctx)
+	if len(md) > 0 || traceId != "" {
+		p.Meta = make(map[string]string, len(md)+1)
+		for k, v := range md {
+			p.Meta[k] = v
+		}
+		if traceId != "" {
+			p.Meta[MetaTraceId] = traceId
+		}
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		p.SetDeadline(deadline)
+	}
+}
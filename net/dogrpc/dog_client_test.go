@@ -7,7 +7,9 @@ package dogrpc_test
 
 import (
 	"github.com/gdp-org/gd"
+	"github.com/gdp-org/gd/net/dogrpc"
 	"github.com/gdp-org/gd/utls/network"
+	"io/ioutil"
 	"testing"
 	"time"
 )
@@ -27,3 +29,26 @@ func TestDogClient(t *testing.T) {
 
 	t.Logf("code=%d, resp=%s", code, string(rsp))
 }
+
+func benchmarkDogPacketEncode(b *testing.B, version uint8) {
+	enc, _ := dogrpc.NewDogPacketEncoder(ioutil.Discard, dogrpc.DefaultBufferSize)
+	p := dogrpc.NewDogPacket(1024, make([]byte, 64*1024))
+	p.Version = version
+
+	b.SetBytes(int64(len(p.Body)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := enc.Encode(p); err != nil {
+			b.Fatalf("encode occur error:%s", err)
+		}
+	}
+}
+
+func BenchmarkDogPacketEncodeJsonCrc32(b *testing.B) {
+	benchmarkDogPacketEncode(b, dogrpc.Version)
+}
+
+func BenchmarkDogPacketEncodeCrc32c(b *testing.B) {
+	benchmarkDogPacketEncode(b, dogrpc.VersionCrc32c)
+}
@@ -6,10 +6,11 @@
 package dogrpc
 
 import (
-	"bufio"
+	"context"
+	"fmt"
+	dogError "github.com/gdp-org/gd/derror"
 	"github.com/gdp-org/gd/dlog"
-	"io"
-	"strconv"
+	"time"
 )
 
 /*
@@ -18,22 +19,38 @@ import (
 
 func NewDogRpcServer() *RpcServer {
 	s := &RpcServer{
-		defaultHandler: make(map[uint32]RpcHandlerFunc),
+		defaultHandler: make(map[uint32]RpcCtxHandlerFunc),
+		dog:            true,
 	}
 
 	s.ss = &Server{
-		Handler: s.dogDispatchPacket,
-		Encoder: func(w io.Writer, bufferSize int) (encoder MessageEncoder, err error) {
-			return &DogPacketEncoder{bw: bufio.NewWriterSize(w, bufferSize)}, nil
-		},
-		Decoder: func(r io.Reader, bufferSize int) (decoder MessageDecoder, err error) {
-			return &DogPacketDecoder{br: bufio.NewReaderSize(r, bufferSize)}, nil
-		},
+		CtxHandler:   s.dogDispatchPacket,
+		FrameHandler: s.dogFrame,
+		Encoder:      NewDogPacketEncoder,
+		Decoder:      NewDogPacketDecoder,
+		GoAway:       dogGoAway,
+		Reject:       dogReject,
 	}
 
 	return s
 }
 
+// dogFrame handles control packets and stream frames.
+func (s *RpcServer) dogFrame(ctx context.Context, conn *ServerConn, clientAddr string, req Packet) bool {
+	return s.dogPingFrame(ctx, conn, clientAddr, req) || s.dogStreamFrame(ctx, conn, clientAddr, req)
+}
+
+// dogGoAway uses Version without extension, so that clients of all versions can decode it.
+func dogGoAway() Packet {
+	p := NewDogPacketWithRet(CmdGoAway, nil, 0, 0)
+	p.Version = Version
+	return p
+}
+
+func dogReject(req Packet) Packet {
+	return newDogRspPacket(req.(*DogPacket), []byte(""), uint32(ShutdownError.Code()))
+}
+
 func (s *RpcServer) AddDogHandler(headCmd uint32, f interface{}) {
 	if s.wrapHandler == nil {
 		s.wrapHandler = make(map[uint32]interface{})
@@ -55,28 +72,74 @@ func (s *RpcServer) DogRpcRegister() error {
 			dlog.Error("DogRpcRegister wrap occur error:%s", err)
 			return err
 		}
-		s.AddHandler(k, wf)
+		s.AddCtxHandler(k, wf)
 	}
 	return nil
 }
 
-func (s *RpcServer) dogDispatchPacket(clientAddr string, req Packet) (rsp Packet) {
+func (s *RpcServer) dogDispatchPacket(ctx context.Context, clientAddr string, req Packet) (rsp Packet) {
 	packet := req.(*DogPacket)
 	headCmd := packet.Cmd
 
 	f, ok := s.defaultHandler[headCmd]
 	if !ok {
 		dlog.Error("dispatchPacket head cmd %d not register handler!", headCmd)
-		return NewDogPacketWithRet(headCmd, []byte(""), packet.Seq, uint32(InvalidParam.Code()))
+		return newDogRspPacket(packet, []byte(""), uint32(InvalidParam.Code()))
+	}
+
+	ctx, dCancel, err := dogPacketContext(ctx, packet)
+	if err != nil {
+		dlog.Warn("dispatchPacket head cmd %d seq %d occur error:%s", headCmd, packet.Seq, err.Error())
+		return newDogRspPacket(packet, []byte(""), uint32(err.Code()))
+	}
+	defer dCancel()
+
+	if ctx, err = s.authenticate(ctx, clientAddr, headCmd, packet.Body); err != nil {
+		return newDogRspPacket(packet, []byte(""), uint32(err.Code()))
+	}
+
+	release, ok := s.acquire(headCmd)
+	if !ok {
+		return newDogRspPacket(packet, []byte(""), uint32(OverflowError.Code()))
 	}
+	defer release()
 
-	code, body := globalFilter.Handle(&Context{
-		ClientAddr: clientAddr,
-		Seq:        packet.Seq,
-		Method:     strconv.Itoa(int(headCmd)),
-		Handler:    f,
-		Req:        req.(*DogPacket).Body,
-	})
+	c, cancel := s.newContext(ctx, clientAddr, packet.Seq, headCmd, f, packet.Body)
+	defer cancel()
+
+	code, body := s.handle(c)
+
+	return newDogRspPacket(packet, body, code)
+}
+
+// dogPacketContext returns a copy of ctx carrying codec, deadline and metadata of packet.
+func dogPacketContext(ctx context.Context, packet *DogPacket) (context.Context, context.CancelFunc, *dogError.CodeError) {
+	if GetCodec(packet.Padding) == nil {
+		return nil, nil, InvalidParam.SetMsg(fmt.Sprintf("codec %d not register", packet.Padding))
+	}
+	ctx = withCodec(ctx, packet.Padding)
+
+	if len(packet.Meta) > 0 {
+		ctx = withIncomingMetadata(ctx, packet.Meta)
+	}
+
+	if packet.Deadline > 0 {
+		deadline := time.Unix(0, packet.Deadline)
+		if !time.Now().Before(deadline) {
+			return nil, nil, TimeOutError.SetMsg("deadline exceeded")
+		}
+		ctx, cancel := context.WithDeadline(ctx, deadline)
+		return ctx, cancel, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	return ctx, cancel, nil
+}
 
-	return NewDogPacketWithRet(packet.Cmd, body, packet.Seq, code)
+// newDogRspPacket replies with the version and codec of req, so that old clients keep working.
+func newDogRspPacket(req *DogPacket, body []byte, code uint32) *DogPacket {
+	rsp := NewDogPacketWithRet(req.Cmd, body, req.Seq, code)
+	rsp.Version = req.Version
+	rsp.Padding = req.Padding
+	return rsp
 }
@@ -6,6 +6,7 @@
 package dogrpc_test
 
 import (
+	"bytes"
 	de "github.com/gdp-org/gd/derror"
 	"github.com/gdp-org/gd/dlog"
 	"github.com/gdp-org/gd/net/dogrpc"
@@ -48,3 +49,36 @@ func TestDogServer(t *testing.T) {
 	}
 	<-i
 }
+
+func benchmarkDogPacketDecode(b *testing.B, version uint8) {
+	buf := &bytes.Buffer{}
+	enc, _ := dogrpc.NewDogPacketEncoder(buf, dogrpc.DefaultBufferSize)
+	p := dogrpc.NewDogPacket(1024, make([]byte, 64*1024))
+	p.Version = version
+	if err := enc.Encode(p); err != nil {
+		b.Fatalf("encode occur error:%s", err)
+	}
+	enc.Flush()
+	data := buf.Bytes()
+
+	r := bytes.NewReader(data)
+	dec, _ := dogrpc.NewDogPacketDecoder(r, dogrpc.DefaultBufferSize)
+
+	b.SetBytes(int64(len(p.Body)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Reset(data)
+		if _, err := dec.Decode(); err != nil {
+			b.Fatalf("decode occur error:%s", err)
+		}
+	}
+}
+
+func BenchmarkDogPacketDecodeJsonCrc32(b *testing.B) {
+	benchmarkDogPacketDecode(b, dogrpc.Version)
+}
+
+func BenchmarkDogPacketDecodeCrc32c(b *testing.B) {
+	benchmarkDogPacketDecode(b, dogrpc.VersionCrc32c)
+}
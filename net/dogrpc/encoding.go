@@ -13,6 +13,7 @@ import (
 	"hash/crc32"
 	"io"
 	"sync/atomic"
+	"time"
 )
 
 type Packet interface {
@@ -157,19 +158,54 @@ func (e *RpcPacketEncoder) Flush() error {
 
 /*
  * DogPacket. It is protocol of gd.
+ *
+ * Version 2 adds a header extension between the fixed header and the body:
+ *   ExtLen   uint32 // length of the extension, ExtLen itself included
+ *   Timeout  int64  // nanoseconds left to caller deadline when packet is sent, 0 means no deadline
+ *   MetaNum  uint16
+ *   MetaNum * (KeyLen uint16, Key, ValLen uint16, Val)
+ *   Stream   uint8  // stream frame type, optional, 0 means unary request
+ * The extension is only sent when the packet carries a deadline, metadata or stream frame, and
+ * it is covered by the checksum.
+ * Timeout is relative, so that clocks of hosts need not agree. the receiver converts it to
+ * Deadline by its own clock when the packet is decoded.
+ *
+ * Version 3 always sends the extension and replaces the json based crc32 checksum
+ * by crc32c over the header (CheckSum as 0), extension and body bytes.
+ *
+ * Packets of Version never carry the extension, deadline and metadata of them are dropped, so
+ * that peers which only know Version can decode them. stream frames need VersionExt at least.
+ *
+ * Server replies with the version of request. client which pings negotiates the version on each
+ * connection: requests are held until the first pong, which tells the highest version of server,
+ * and are sent by the lower one of it and their own. servers which do not tell it get Version.
+ *
+ * Padding carries the body codec id of wrapped handlers, 0 is json. see RegisterCodec.
  */
 
 const (
-	HeaderLen = 24
-	Version   = 1
-	Padding   = 0
-	SOH       = 0x10
-	EOH       = 0x24
+	HeaderLen     = 24
+	Version       = 1
+	VersionExt    = 2
+	VersionCrc32c = 3
+	Padding       = 0
+	SOH           = 0x10
+	EOH           = 0x24
+
+	extFixedLen = 14
+	maxMetaLen  = 0xFFFF
 )
 
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
 type DogPacket struct {
 	Header
-	Body []byte
+	// Deadline is unix nano of caller deadline by local clock, 0 means no deadline
+	Deadline int64             `json:"-"`
+	Meta     map[string]string `json:"-"`
+	Stream   uint8             `json:"-"`
+	signer   signer
+	Body     []byte
 }
 
 type Header struct {
@@ -184,6 +220,30 @@ type Header struct {
 	EOH       uint8
 }
 
+func (h *Header) marshal(b []byte) {
+	binary.BigEndian.PutUint32(b[0:], h.PacketLen)
+	binary.BigEndian.PutUint32(b[4:], h.Seq)
+	binary.BigEndian.PutUint32(b[8:], h.Cmd)
+	binary.BigEndian.PutUint32(b[12:], h.CheckSum)
+	binary.BigEndian.PutUint32(b[16:], h.ErrCode)
+	b[20] = h.Version
+	b[21] = h.Padding
+	b[22] = h.SOH
+	b[23] = h.EOH
+}
+
+func (h *Header) unmarshal(b []byte) {
+	h.PacketLen = binary.BigEndian.Uint32(b[0:])
+	h.Seq = binary.BigEndian.Uint32(b[4:])
+	h.Cmd = binary.BigEndian.Uint32(b[8:])
+	h.CheckSum = binary.BigEndian.Uint32(b[12:])
+	h.ErrCode = binary.BigEndian.Uint32(b[16:])
+	h.Version = b[20]
+	h.Padding = b[21]
+	h.SOH = b[22]
+	h.EOH = b[23]
+}
+
 var (
 	globalDogSeq uint32
 )
@@ -200,6 +260,113 @@ func (p *DogPacket) SetErrCode(code uint32) {
 	p.ErrCode = code
 }
 
+func (p *DogPacket) SetDeadline(t time.Time) {
+	if t.IsZero() {
+		p.Deadline = 0
+		return
+	}
+	p.Deadline = t.UnixNano()
+}
+
+func (p *DogPacket) hasExt() bool {
+	return p.Version >= VersionCrc32c || p.Deadline != 0 || len(p.Meta) > 0 || p.Stream != 0
+}
+
+// timeout returns nanoseconds left to Deadline, an expired deadline is 1 so that receiver rejects it.
+func (p *DogPacket) timeout() int64 {
+	if p.Deadline == 0 {
+		return 0
+	}
+	if d := time.Until(time.Unix(0, p.Deadline)); d > 0 {
+		return int64(d)
+	}
+	return 1
+}
+
+func (p *DogPacket) marshalExt() ([]byte, error) {
+	if len(p.Meta) > maxMetaLen {
+		return nil, errors.New("too many meta")
+	}
+
+	extLen := extFixedLen
+	for k, v := range p.Meta {
+		if len(k) > maxMetaLen || len(v) > maxMetaLen {
+			return nil, errors.New("meta too long")
+		}
+		extLen += 4 + len(k) + len(v)
+	}
+	if p.Stream != 0 {
+		extLen++
+	}
+
+	ext := make([]byte, extLen)
+	binary.BigEndian.PutUint32(ext[0:], uint32(extLen))
+	binary.BigEndian.PutUint64(ext[4:], uint64(p.timeout()))
+	binary.BigEndian.PutUint16(ext[12:], uint16(len(p.Meta)))
+	off := extFixedLen
+	for k, v := range p.Meta {
+		binary.BigEndian.PutUint16(ext[off:], uint16(len(k)))
+		off += 2
+		off += copy(ext[off:], k)
+		binary.BigEndian.PutUint16(ext[off:], uint16(len(v)))
+		off += 2
+		off += copy(ext[off:], v)
+	}
+	if p.Stream != 0 {
+		ext[off] = p.Stream
+	}
+
+	return ext, nil
+}
+
+// unmarshalExt parses the extension. unknown trailing bytes are ignored.
+func (p *DogPacket) unmarshalExt(ext []byte) error {
+	if len(ext) < extFixedLen {
+		return errors.New("invalid ext")
+	}
+
+	if timeout := int64(binary.BigEndian.Uint64(ext[4:])); timeout > 0 {
+		p.Deadline = time.Now().Add(time.Duration(timeout)).UnixNano()
+	}
+	metaNum := int(binary.BigEndian.Uint16(ext[12:]))
+	off := extFixedLen
+	if metaNum > 0 {
+		p.Meta = make(map[string]string, metaNum)
+	}
+
+	readStr := func() (string, error) {
+		if off+2 > len(ext) {
+			return "", errors.New("invalid ext meta")
+		}
+		l := int(binary.BigEndian.Uint16(ext[off:]))
+		off += 2
+		if off+l > len(ext) {
+			return "", errors.New("invalid ext meta")
+		}
+		str := string(ext[off : off+l])
+		off += l
+		return str, nil
+	}
+
+	for i := 0; i < metaNum; i++ {
+		k, err := readStr()
+		if err != nil {
+			return err
+		}
+		v, err := readStr()
+		if err != nil {
+			return err
+		}
+		p.Meta[k] = v
+	}
+
+	if off < len(ext) {
+		p.Stream = ext[off]
+	}
+
+	return nil
+}
+
 func NewDogPacket(cmd uint32, body []byte) *DogPacket {
 	seq := nextDogSeq()
 	return NewDogPacketWithSeq(cmd, body, seq)
@@ -209,6 +376,8 @@ func NewDogPacketWithSeq(cmd uint32, body []byte, seq uint32) *DogPacket {
 	return NewDogPacketWithRet(cmd, body, seq, 0)
 }
 
+// NewDogPacketWithRet returns a packet of VersionExt, which is sent as Version if it carries no
+// extension. CheckSum is computed by DogPacketEncoder.
 func NewDogPacketWithRet(cmd uint32, body []byte, seq uint32, ret uint32) *DogPacket {
 	packet := &DogPacket{
 		Header: Header{
@@ -217,7 +386,7 @@ func NewDogPacketWithRet(cmd uint32, body []byte, seq uint32, ret uint32) *DogPa
 			Cmd:       cmd,
 			CheckSum:  0,
 			ErrCode:   ret,
-			Version:   Version,
+			Version:   VersionExt,
 			Padding:   Padding,
 			SOH:       SOH,
 			EOH:       EOH,
@@ -225,27 +394,82 @@ func NewDogPacketWithRet(cmd uint32, body []byte, seq uint32, ret uint32) *DogPa
 		Body: body,
 	}
 
+	return packet
+}
+
+// jsonCheckSum computes the checksum of Version and VersionExt packet with CheckSum field as 0.
+// nil body is computed as empty, which is what decoder gets. ext bytes of VersionExt are covered
+// too, as fields of the extension are not marshaled.
+func jsonCheckSum(packet *DogPacket, ext []byte) uint32 {
+	checkSum, body := packet.CheckSum, packet.Body
+	packet.CheckSum = 0
+	if body == nil {
+		packet.Body = []byte{}
+	}
 	packetByte, _ := json.Marshal(packet)
-	checkSum := crc32.ChecksumIEEE(packetByte)
-	packet.CheckSum = checkSum
+	packet.CheckSum, packet.Body = checkSum, body
+	return crc32.Update(crc32.ChecksumIEEE(packetByte), crc32.IEEETable, ext)
+}
 
-	return packet
+// crc32cCheckSum computes the checksum of VersionCrc32c packet. header must be marshaled with CheckSum as 0.
+func crc32cCheckSum(header, ext, body []byte) uint32 {
+	checkSum := crc32.Update(0, crc32cTable, header)
+	checkSum = crc32.Update(checkSum, crc32cTable, ext)
+	return crc32.Update(checkSum, crc32cTable, body)
+}
+
+func NewDogPacketEncoder(w io.Writer, bufferSize int) (encoder MessageEncoder, err error) {
+	return &DogPacketEncoder{bw: bufio.NewWriterSize(w, bufferSize)}, nil
+}
+
+func NewDogPacketDecoder(r io.Reader, bufferSize int) (decoder MessageDecoder, err error) {
+	return &DogPacketDecoder{br: bufio.NewReaderSize(r, bufferSize)}, nil
 }
 
 type DogPacketEncoder struct {
-	bw *bufio.Writer
+	bw     *bufio.Writer
+	header [HeaderLen]byte
 }
 
 type DogPacketDecoder struct {
-	br *bufio.Reader
+	br     *bufio.Reader
+	header [HeaderLen]byte
 }
 
 func (e *DogPacketEncoder) Encode(p Packet) error {
 	if packet, ok := p.(*DogPacket); ok {
-		if err := binary.Write(e.bw, binary.BigEndian, packet.Header); err != nil {
+		var ext []byte
+		if packet.Version <= Version {
+			if packet.Stream != 0 {
+				return errors.New("DogPacketEncoder stream frame needs VersionExt")
+			}
+		} else if packet.hasExt() {
+			var err error
+			if ext, err = packet.marshalExt(); err != nil {
+				return err
+			}
+		} else if packet.Version == VersionExt {
+			packet.Version = Version
+		}
+		packet.PacketLen = uint32(HeaderLen + len(ext) + len(packet.Body))
+
+		if packet.Version >= VersionCrc32c {
+			packet.CheckSum = 0
+			packet.Header.marshal(e.header[:])
+			packet.CheckSum = crc32cCheckSum(e.header[:], ext, packet.Body)
+			binary.BigEndian.PutUint32(e.header[12:], packet.CheckSum)
+		} else {
+			packet.CheckSum = jsonCheckSum(packet, ext)
+			packet.Header.marshal(e.header[:])
+		}
+
+		if _, err := e.bw.Write(e.header[:]); err != nil {
 			return err
 		}
-		if err := binary.Write(e.bw, binary.BigEndian, packet.Body); err != nil {
+		if _, err := e.bw.Write(ext); err != nil {
+			return err
+		}
+		if _, err := e.bw.Write(packet.Body); err != nil {
 			return err
 		}
 
@@ -257,9 +481,10 @@ func (e *DogPacketEncoder) Encode(p Packet) error {
 func (d *DogPacketDecoder) Decode() (Packet, error) {
 	packet := &DogPacket{}
 
-	if err := binary.Read(d.br, binary.BigEndian, &packet.Header); err != nil {
+	if _, err := io.ReadFull(d.br, d.header[:]); err != nil {
 		return nil, err
 	}
+	packet.Header.unmarshal(d.header[:])
 
 	if packet.Header.PacketLen < HeaderLen {
 		return nil, errors.New("invalid packet")
@@ -274,23 +499,48 @@ func (d *DogPacketDecoder) Decode() (Packet, error) {
 	}
 
 	bodyLen := packet.Header.PacketLen - HeaderLen
+	var ext []byte
+	if packet.Header.Version >= VersionExt {
+		var extLenBytes [4]byte
+		if _, err := io.ReadFull(d.br, extLenBytes[:]); err != nil {
+			return nil, err
+		}
+
+		extLen := binary.BigEndian.Uint32(extLenBytes[:])
+		if extLen < extFixedLen || extLen > bodyLen {
+			return nil, errors.New("invalid ext")
+		}
+
+		ext = make([]byte, extLen)
+		copy(ext, extLenBytes[:])
+		if _, err := io.ReadFull(d.br, ext[4:]); err != nil {
+			return nil, err
+		}
+
+		if err := packet.unmarshalExt(ext); err != nil {
+			return nil, err
+		}
+		bodyLen -= extLen
+	}
+
 	packet.Body = make([]byte, bodyLen)
 
-	if err := binary.Read(d.br, binary.BigEndian, packet.Body); err != nil {
+	if _, err := io.ReadFull(d.br, packet.Body); err != nil {
 		return nil, err
 	}
 
-	checkSum1 := packet.Header.CheckSum
-	packet.Header.CheckSum = 0
-	packetByte, _ := json.Marshal(packet)
-	checkSum2 := crc32.ChecksumIEEE(packetByte)
+	var checkSum uint32
+	if packet.Header.Version >= VersionCrc32c {
+		binary.BigEndian.PutUint32(d.header[12:], 0)
+		checkSum = crc32cCheckSum(d.header[:], ext, packet.Body)
+	} else {
+		checkSum = jsonCheckSum(packet, ext)
+	}
 
-	if checkSum1 != checkSum2 {
+	if packet.Header.CheckSum != checkSum {
 		return nil, errors.New("invalid CheckSum")
 	}
 
-	packet.Header.CheckSum = checkSum1
-
 	return packet, nil
 }
 
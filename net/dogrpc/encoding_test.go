@@ -0,0 +1,289 @@
+/**
+ * Copyright 2018 gd Author. All Rights Reserved.
+ * Author: Chuck1024
+ */
+
+package dogrpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func encodeDecodeDogPacket(t *testing.T, p *DogPacket) *DogPacket {
+	buf := &bytes.Buffer{}
+	enc := &DogPacketEncoder{bw: bufio.NewWriter(buf)}
+	if err := enc.Encode(p); err != nil {
+		t.Fatalf("encode occur error:%s", err)
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatalf("flush occur error:%s", err)
+	}
+
+	dec := &DogPacketDecoder{br: bufio.NewReader(buf)}
+	rp, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("decode occur error:%s", err)
+	}
+	return rp.(*DogPacket)
+}
+
+func TestDogPacketHeaderExt(t *testing.T) {
+	for _, version := range []uint8{VersionExt, VersionCrc32c} {
+		deadline := time.Now().Add(time.Second)
+		p := NewDogPacket(1024, []byte("How are you?"))
+		p.Version = version
+		p.SetDeadline(deadline)
+		p.Meta = map[string]string{MetaTraceId: "trace", "k": "v"}
+
+		rp := encodeDecodeDogPacket(t, p)
+		if rp.Version != version {
+			t.Fatalf("unexpected version %d", rp.Version)
+		}
+		// deadline is sent as timeout and converted back by receiver, which adds the transit time
+		if d := time.Duration(rp.Deadline - deadline.UnixNano()); d < 0 || d > 100*time.Millisecond {
+			t.Fatalf("unexpected deadline %d, want %d", rp.Deadline, deadline.UnixNano())
+		}
+		if rp.Meta[MetaTraceId] != "trace" || rp.Meta["k"] != "v" {
+			t.Fatalf("unexpected meta %v", rp.Meta)
+		}
+		if string(rp.Body) != "How are you?" {
+			t.Fatalf("unexpected body %s", rp.Body)
+		}
+	}
+}
+
+func TestDogPacketTimeout(t *testing.T) {
+	p := NewDogPacket(1024, []byte("How are you?"))
+	p.SetDeadline(time.Now().Add(time.Second))
+	ext, err := p.marshalExt()
+	if err != nil {
+		t.Fatalf("marshal ext occur error:%s", err)
+	}
+	// the wire carries time left, not the absolute deadline of sender clock
+	if timeout := time.Duration(binary.BigEndian.Uint64(ext[4:])); timeout <= 0 || timeout > time.Second {
+		t.Fatalf("unexpected timeout %v", timeout)
+	}
+
+	// expired deadline is still sent, so that receiver rejects it
+	p.SetDeadline(time.Now().Add(-time.Second))
+	rp := encodeDecodeDogPacket(t, p)
+	if rp.Deadline == 0 || time.Until(time.Unix(0, rp.Deadline)) > time.Millisecond {
+		t.Fatalf("unexpected deadline %d", rp.Deadline)
+	}
+}
+
+func TestDogPacketVersionWithoutExt(t *testing.T) {
+	p := NewDogPacket(1024, []byte("How are you?"))
+	p.Version = Version
+	p.SetDeadline(time.Now().Add(time.Second))
+	p.Meta = map[string]string{MetaTraceId: "trace"}
+
+	// version is not raised, the extension is dropped
+	rp := encodeDecodeDogPacket(t, p)
+	if rp.Version != Version || rp.PacketLen != HeaderLen+uint32(len(p.Body)) {
+		t.Fatalf("unexpected version %d len %d", rp.Version, rp.PacketLen)
+	}
+	if rp.Deadline != 0 || rp.Meta != nil {
+		t.Fatalf("unexpected ext %d %v", rp.Deadline, rp.Meta)
+	}
+
+	p.Stream = 1
+	enc := &DogPacketEncoder{bw: bufio.NewWriter(&bytes.Buffer{})}
+	if err := enc.Encode(p); err == nil {
+		t.Fatal("expect error of stream frame in Version")
+	}
+}
+
+// decodeDogPacketV1 is the decoder of peers which only know Version.
+func decodeDogPacketV1(r io.Reader) (*DogPacket, error) {
+	packet := &struct {
+		Header
+		Body []byte
+	}{}
+	if err := binary.Read(r, binary.BigEndian, &packet.Header); err != nil {
+		return nil, err
+	}
+	if packet.PacketLen < HeaderLen || packet.SOH != SOH || packet.EOH != EOH {
+		return nil, errors.New("invalid header")
+	}
+	packet.Body = make([]byte, packet.PacketLen-HeaderLen)
+	if err := binary.Read(r, binary.BigEndian, packet.Body); err != nil {
+		return nil, err
+	}
+
+	checkSum := packet.CheckSum
+	packet.CheckSum = 0
+	packetByte, _ := json.Marshal(packet)
+	if crc32.ChecksumIEEE(packetByte) != checkSum {
+		return nil, errors.New("invalid CheckSum")
+	}
+	packet.CheckSum = checkSum
+	return &DogPacket{Header: packet.Header, Body: packet.Body}, nil
+}
+
+func TestDogVersionClient(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen occur error:%s", err)
+	}
+	defer ln.Close()
+
+	reqs, errs := make(chan *DogPacket, 1), make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		enc := &DogPacketEncoder{bw: bufio.NewWriter(conn)}
+		for {
+			p, err := decodeDogPacketV1(conn)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if p.Cmd != CmdPing {
+				reqs <- p
+			}
+			rsp := NewDogPacketWithRet(p.Cmd, p.Body, p.Seq, 0)
+			rsp.Version = Version
+			if enc.Encode(rsp) != nil || enc.Flush() != nil {
+				return
+			}
+		}
+	}()
+
+	c := NewClient(time.Second, 0, false, nil, "", "", "").AddAddr(ln.Addr().String())
+	defer c.Stop()
+
+	// peer does not tell its version by pong, deadline and metadata of ctx are not sent to it
+	ctx, cancel := context.WithTimeout(WithMetadata(context.Background(), Metadata{"k": "v"}), time.Second)
+	defer cancel()
+	code, _, dErr := c.DogInvokeCtx(ctx, 1024, "hello")
+	var p *DogPacket
+	select {
+	case p = <-reqs:
+	case err := <-errs:
+		t.Fatalf("decode occur error:%s", err)
+	}
+	if dErr != nil || code != 0 {
+		t.Fatalf("invoke occur error:%v code %d", dErr, code)
+	}
+	if p.Version != Version || string(p.Body) != `"hello"` {
+		t.Fatalf("unexpected request version %d body %s", p.Version, p.Body)
+	}
+}
+
+func TestDogPacketWithoutHeaderExt(t *testing.T) {
+	for _, version := range []uint8{Version, VersionCrc32c} {
+		p := NewDogPacket(1024, []byte("How are you?"))
+		p.Version = version
+
+		rp := encodeDecodeDogPacket(t, p)
+		if rp.Version != version {
+			t.Fatalf("unexpected version %d", rp.Version)
+		}
+		if rp.Deadline != 0 || rp.Meta != nil {
+			t.Fatalf("unexpected ext %d %v", rp.Deadline, rp.Meta)
+		}
+		if string(rp.Body) != "How are you?" {
+			t.Fatalf("unexpected body %s", rp.Body)
+		}
+	}
+}
+
+func TestDogPacketCheckSum(t *testing.T) {
+	for _, version := range []uint8{Version, VersionCrc32c} {
+		p := NewDogPacket(1024, []byte("How are you?"))
+		p.Version = version
+
+		buf := &bytes.Buffer{}
+		enc := &DogPacketEncoder{bw: bufio.NewWriter(buf)}
+		if err := enc.Encode(p); err != nil {
+			t.Fatalf("encode occur error:%s", err)
+		}
+		enc.Flush()
+
+		b := buf.Bytes()
+		b[len(b)-1] ^= 0xFF
+		dec := &DogPacketDecoder{br: bufio.NewReader(bytes.NewReader(b))}
+		if _, err := dec.Decode(); err == nil {
+			t.Fatalf("version %d expect checksum error", version)
+		}
+	}
+}
+
+func TestDogPacketExtCheckSum(t *testing.T) {
+	for _, version := range []uint8{VersionExt, VersionCrc32c} {
+		p := NewDogPacket(1024, []byte("How are you?"))
+		p.Version = version
+		p.Meta = map[string]string{"k": "v"}
+
+		buf := &bytes.Buffer{}
+		enc := &DogPacketEncoder{bw: bufio.NewWriter(buf)}
+		if err := enc.Encode(p); err != nil {
+			t.Fatalf("encode occur error:%s", err)
+		}
+		enc.Flush()
+
+		// the value of meta
+		b := buf.Bytes()
+		b[HeaderLen+extFixedLen+5] ^= 0x01
+		dec := &DogPacketDecoder{br: bufio.NewReader(bytes.NewReader(b))}
+		if _, err := dec.Decode(); err == nil {
+			t.Fatalf("version %d expect checksum error", version)
+		}
+	}
+}
+
+// versionDecoder records versions of requests decoded by server.
+type versionDecoder struct {
+	MessageDecoder
+	versions chan uint8
+}
+
+func (d *versionDecoder) Decode() (Packet, error) {
+	p, err := d.MessageDecoder.Decode()
+	if dp, ok := p.(*DogPacket); ok && dp.Cmd != CmdPing {
+		d.versions <- dp.Version
+	}
+	return p, err
+}
+
+func TestDogVersionNegotiate(t *testing.T) {
+	versions := make(chan uint8, 1)
+	s := NewDogRpcServer()
+	s.AddHandler(1, func(req []byte) (uint32, []byte) {
+		return 0, req
+	})
+	s.ss.Decoder = func(r io.Reader, bufferSize int) (MessageDecoder, error) {
+		dec, err := NewDogPacketDecoder(r, bufferSize)
+		return &versionDecoder{MessageDecoder: dec, versions: versions}, err
+	}
+	startPipeServer(t, s, "version")
+	defer s.ss.Stop()
+
+	c := NewClient(time.Second, 0, false, nil, "", "", "").SetNetwork(NetworkPipe).AddAddr("version")
+	defer c.Stop()
+
+	for _, version := range []uint8{VersionCrc32c, VersionExt} {
+		c.DogVersion = version
+		if code, _, err := c.DogInvoke(1, "hello"); err != nil || code != 0 {
+			t.Fatalf("invoke occur error:%v code %d", err, code)
+		}
+		if v := <-versions; v != version {
+			t.Fatalf("unexpected request version %d, want %d", v, version)
+		}
+	}
+}
@@ -26,8 +26,10 @@ func (f *GlFilter) SetNext(filter Filter) {
 func (f *GlFilter) Handle(ctx *Context) (code uint32, rsp []byte) {
 	gl.Init()
 	defer gl.Close()
-	st := time.Now()
-	logId := strconv.FormatInt(st.UnixNano(), 10)
+	logId := TraceId(ctx.Ctx)
+	if logId == "" {
+		logId = strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
 	gl.Set(gl.LogId, logId)
 	gl.Set(gl.ClientIp, ctx.ClientAddr)
 
@@ -0,0 +1,112 @@
+/**
+ * Copyright 2019 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package dogrpc
+
+import (
+	"context"
+	dogError "github.com/gdp-org/gd/derror"
+	"strconv"
+	"time"
+)
+
+/*
+ * interceptors of requests. server interceptors are called around handler of RpcServer, client
+ * interceptors are called around Invoke and DogInvoke of RpcClient. interceptors added by Use are
+ * called before those added by UseCmd, in the order they are added, and each calls next to go on,
+ * or returns without calling next to reject the request.
+ *
+ * server interceptors receive Context with ClientAddr, Seq, Cmd, Req, Meta and StartTime, Ctx
+ * may be replaced by a derived context for handler. client interceptors receive Context with Seq,
+ * Cmd, Req and StartTime, Ctx may be replaced to carry metadata to server.
+ *
+ * filters added by Use are still called at the end of server interceptors.
+ */
+
+// ServerHandler handles the request of ctx.
+type ServerHandler func(ctx *Context) (code uint32, rsp []byte)
+
+// ServerInterceptor intercepts requests of RpcServer, next is the rest of chain.
+type ServerInterceptor func(ctx *Context, next ServerHandler) (code uint32, rsp []byte)
+
+// Invoker sends the request of ctx to server.
+type Invoker func(ctx *Context) (code uint32, rsp []byte, err *dogError.CodeError)
+
+// ClientInterceptor intercepts requests of RpcClient, next is the rest of chain.
+type ClientInterceptor func(ctx *Context, next Invoker) (code uint32, rsp []byte, err *dogError.CodeError)
+
+// Use adds interceptors of all commands.
+func (s *RpcServer) Use(interceptors ...ServerInterceptor) *RpcServer {
+	s.interceptors = append(s.interceptors, interceptors...)
+	return s
+}
+
+// UseCmd adds interceptors of cmd, which are called after those added by Use.
+func (s *RpcServer) UseCmd(cmd uint32, interceptors ...ServerInterceptor) *RpcServer {
+	if s.cmdInterceptors == nil {
+		s.cmdInterceptors = make(map[uint32][]ServerInterceptor)
+	}
+	s.cmdInterceptors[cmd] = append(s.cmdInterceptors[cmd], interceptors...)
+	return s
+}
+
+// handle calls interceptors of ctx.Cmd and filters before handler.
+func (s *RpcServer) handle(ctx *Context) (uint32, []byte) {
+	h := globalFilter.Handle
+	h = chainServer(s.cmdInterceptors[ctx.Cmd], h)
+	h = chainServer(s.interceptors, h)
+	return h(ctx)
+}
+
+func chainServer(interceptors []ServerInterceptor, h ServerHandler) ServerHandler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], h
+		h = func(ctx *Context) (uint32, []byte) {
+			return interceptor(ctx, next)
+		}
+	}
+	return h
+}
+
+// Use adds interceptors of all commands. it must be called before any request.
+func (c *RpcClient) Use(interceptors ...ClientInterceptor) *RpcClient {
+	c.interceptors = append(c.interceptors, interceptors...)
+	return c
+}
+
+// UseCmd adds interceptors of cmd, which are called after those added by Use. it must be called before any request.
+func (c *RpcClient) UseCmd(cmd uint32, interceptors ...ClientInterceptor) *RpcClient {
+	if c.cmdInterceptors == nil {
+		c.cmdInterceptors = make(map[uint32][]ClientInterceptor)
+	}
+	c.cmdInterceptors[cmd] = append(c.cmdInterceptors[cmd], interceptors...)
+	return c
+}
+
+// invoke calls interceptors of cmd before invoker.
+func (c *RpcClient) invoke(ctx context.Context, cmd uint32, seq uint32, req []byte, invoker Invoker) (uint32, []byte, *dogError.CodeError) {
+	cc := &Context{
+		Seq:       seq,
+		Cmd:       cmd,
+		Method:    strconv.Itoa(int(cmd)),
+		Req:       req,
+		Ctx:       ctx,
+		StartTime: time.Now(),
+	}
+
+	invoker = chainClient(c.cmdInterceptors[cmd], invoker)
+	invoker = chainClient(c.interceptors, invoker)
+	return invoker(cc)
+}
+
+func chainClient(interceptors []ClientInterceptor, invoker Invoker) Invoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx *Context) (uint32, []byte, *dogError.CodeError) {
+			return interceptor(ctx, next)
+		}
+	}
+	return invoker
+}
@@ -0,0 +1,63 @@
+/**
+ * Copyright 2019 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package dogrpc
+
+import (
+	"context"
+	dogError "github.com/gdp-org/gd/derror"
+	"testing"
+	"time"
+)
+
+func TestInterceptor(t *testing.T) {
+	var calls []string
+	record := func(name string) ServerInterceptor {
+		return func(ctx *Context, next ServerHandler) (uint32, []byte) {
+			calls = append(calls, name)
+			return next(ctx)
+		}
+	}
+
+	s := NewDogRpcServer()
+	s.AddCtxHandler(1, func(ctx context.Context, req []byte) (uint32, []byte) {
+		return 0, req
+	})
+	s.AddCtxHandler(2, func(ctx context.Context, req []byte) (uint32, []byte) {
+		return 0, req
+	})
+	s.Use(record("global"))
+	s.UseCmd(1, record("cmd1"))
+	s.UseCmd(2, func(ctx *Context, next ServerHandler) (uint32, []byte) {
+		if ctx.Meta["token"] != "secret" {
+			return uint32(InvalidParam.Code()), nil
+		}
+		return next(ctx)
+	})
+	startPipeServer(t, s, "interceptor")
+	defer s.ss.Stop()
+
+	c := NewClient(time.Second, 0, false, nil, "", "", "").SetNetwork(NetworkPipe).AddAddr("interceptor")
+	defer c.Stop()
+
+	if code, _, err := c.DogInvoke(1, "hello"); err != nil || code != 0 {
+		t.Fatalf("invoke cmd 1 code %d error %v", code, err)
+	}
+	if len(calls) != 2 || calls[0] != "global" || calls[1] != "cmd1" {
+		t.Fatalf("unexpected interceptor calls %v", calls)
+	}
+
+	if code, _, err := c.DogInvoke(2, "hello"); err != nil || code != uint32(InvalidParam.Code()) {
+		t.Fatalf("expect cmd 2 rejected, code %d error %v", code, err)
+	}
+
+	c.UseCmd(2, func(ctx *Context, next Invoker) (uint32, []byte, *dogError.CodeError) {
+		ctx.Ctx = WithMetadata(ctx.Ctx, Metadata{"token": "secret"})
+		return next(ctx)
+	})
+	if code, _, err := c.DogInvoke(2, "hello"); err != nil || code != 0 {
+		t.Fatalf("invoke cmd 2 with token code %d error %v", code, err)
+	}
+}
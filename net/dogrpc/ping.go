@@ -0,0 +1,174 @@
+/**
+ * Copyright 2018 gd Author. All Rights Reserved.
+ * Author: Chuck1024
+ */
+
+package dogrpc
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gdp-org/gd/dlog"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ * ping of dog protocol. client sends CmdPing on a connection which is just established or idle for
+ * PingInterval, server answers it in the connection reader with ServerLoad in json body, so that
+ * it is not blocked by busy handlers. client whose ping is not answered within DefaultPingTimeout
+ * or whose server is draining is unhealthy, and the connection is closed and dialed again.
+ *
+ * the first pong of a connection also negotiates the version of packets sent on it by Version of
+ * ServerLoad, see the comment of DogPacket.
+ */
+
+// ServerLoad is the body of pong.
+type ServerLoad struct {
+	Conns       int32 `json:"conns"`
+	InFlight    int   `json:"inflight"`
+	Concurrency int   `json:"concurrency"`
+	Draining    bool  `json:"draining"`
+	// Version is the highest DogPacket version of server, 0 if server does not tell it
+	Version uint8 `json:"version"`
+}
+
+// Load returns current load of server.
+func (s *Server) Load() ServerLoad {
+	return ServerLoad{
+		Conns:       atomic.LoadInt32(&s.conns),
+		InFlight:    len(s.workersCh),
+		Concurrency: s.Concurrency,
+		Draining:    isServerStop(s.drainChan),
+	}
+}
+
+// dogPingFrame answers ping, it returns false if req is not ping.
+func (s *RpcServer) dogPingFrame(ctx context.Context, conn *ServerConn, clientAddr string, req Packet) bool {
+	packet, ok := req.(*DogPacket)
+	if !ok || packet.Cmd != CmdPing || packet.Stream != 0 {
+		return false
+	}
+
+	load := s.ss.Load()
+	load.Version = VersionCrc32c
+	body, err := json.Marshal(load)
+	if err != nil {
+		dlog.Error("dogPingFrame marshal load occur error:%s", err)
+		return true
+	}
+	conn.Send(newDogRspPacket(packet, body, 0))
+	return true
+}
+
+// dogPing uses Version without extension like dogGoAway, so that servers of all versions can decode it.
+func dogPing() Packet {
+	p := NewDogPacket(CmdPing, nil)
+	p.Version = Version
+	return p
+}
+
+// negotiateVersion lowers version of p to version of server, which is 0 if server does not tell it.
+// stream frames are sent by VersionExt at least.
+func negotiateVersion(p *DogPacket, version uint8) {
+	if version < Version {
+		version = Version
+	}
+	if p.Version > version {
+		p.Version = version
+	}
+	if p.Stream != 0 && p.Version < VersionExt {
+		p.Version = VersionExt
+	}
+}
+
+// clientKeepalive pings on conn at first and then every PingInterval if no packet is written on it,
+// conn is closed if ping fails. version of server told by the first pong is stored to version, and
+// negotiated is closed then.
+func clientKeepalive(c *Client, conn io.Closer, pingChan chan<- *AsyncResult, written *uint64, version *uint32, negotiated chan<- struct{}, stopChan <-chan struct{}) {
+	load, ok := c.ping(pingChan, stopChan)
+	if !ok {
+		conn.Close()
+		return
+	}
+	atomic.StoreUint32(version, uint32(load.Version))
+	close(negotiated)
+
+	t := time.NewTicker(c.PingInterval)
+	defer t.Stop()
+	last := atomic.LoadUint64(written)
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-t.C:
+		}
+
+		if n := atomic.LoadUint64(written); n != last {
+			last = n
+			continue
+		}
+
+		if _, ok := c.ping(pingChan, stopChan); !ok {
+			conn.Close()
+			return
+		}
+		last = atomic.LoadUint64(written)
+	}
+}
+
+// ping returns load in pong and reports whether ping is answered in time, client is marked unhealthy
+// if not or server is draining.
+func (c *Client) ping(pingChan chan<- *AsyncResult, stopChan <-chan struct{}) (ServerLoad, bool) {
+	m := &AsyncResult{
+		Request: c.Ping(),
+		Done:    make(chan struct{}),
+		t:       time.Now(),
+	}
+	select {
+	case pingChan <- m:
+	case <-stopChan:
+		return ServerLoad{}, true
+	}
+
+	timeout := DefaultPingTimeout
+	if c.PingInterval < timeout {
+		timeout = c.PingInterval
+	}
+	t := acquireTimer(timeout)
+	defer releaseTimer(t)
+
+	select {
+	case <-m.Done:
+	case <-t.C:
+		m.Cancel()
+		atomic.StoreInt32(&c.unhealthy, 1)
+		dlog.Warn("client [%s] ping timeout after %v, close connection", c.Addr, timeout)
+		return ServerLoad{}, false
+	case <-stopChan:
+		return ServerLoad{}, true
+	}
+
+	if m.Error != nil {
+		atomic.StoreInt32(&c.unhealthy, 1)
+		return ServerLoad{}, false
+	}
+
+	var load ServerLoad
+	if p, ok := m.Response.(*DogPacket); ok && p.ErrCode == 0 && json.Unmarshal(p.Body, &load) == nil {
+		c.load.Store(load)
+	}
+	if load.Draining {
+		atomic.StoreInt32(&c.unhealthy, 1)
+	} else {
+		atomic.StoreInt32(&c.unhealthy, 0)
+	}
+	return load, true
+}
+
+// ServerLoad returns the load of server in last pong, false if there is none.
+func (c *Client) ServerLoad() (ServerLoad, bool) {
+	load, ok := c.load.Load().(ServerLoad)
+	return load, ok
+}
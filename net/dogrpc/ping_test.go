@@ -0,0 +1,82 @@
+/**
+ * Copyright 2018 gd Author. All Rights Reserved.
+ * Author: Chuck1024
+ */
+
+package dogrpc
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func startPipeServer(t *testing.T, s *RpcServer, addr string) {
+	s.ss.Addr = addr
+	s.ss.Listener = &pipeListener{}
+	if err := s.ss.Start(); err != nil {
+		t.Fatalf("server start occur error:%s", err)
+	}
+}
+
+func TestPing(t *testing.T) {
+	s := NewDogRpcServer()
+	startPipeServer(t, s, "ping")
+	defer s.ss.Stop()
+
+	c := &Client{
+		Addr:         "ping",
+		Dial:         dialPipe,
+		Encoder:      NewDogPacketEncoder,
+		Decoder:      NewDogPacketDecoder,
+		Ping:         dogPing,
+		PingInterval: 20 * time.Millisecond,
+	}
+	c.Start()
+	defer c.Stop()
+
+	time.Sleep(100 * time.Millisecond)
+	load, ok := c.ServerLoad()
+	if !ok || load.Conns != 1 || load.Draining {
+		t.Fatalf("unexpected server load %+v", load)
+	}
+	if !c.Healthy() {
+		t.Fatal("expect client healthy")
+	}
+}
+
+func TestPingTimeout(t *testing.T) {
+	s := NewDogRpcServer()
+	// server does not answer ping
+	s.ss.FrameHandler = func(ctx context.Context, conn *ServerConn, clientAddr string, req Packet) bool {
+		return req.(*DogPacket).Cmd == CmdPing
+	}
+	startPipeServer(t, s, "ping-timeout")
+	defer s.ss.Stop()
+
+	var dials int32
+	c := &Client{
+		Addr: "ping-timeout",
+		Dial: func(addr string) (io.ReadWriteCloser, error) {
+			atomic.AddInt32(&dials, 1)
+			return dialPipe(addr)
+		},
+		DialRetryTime: 1,
+		Encoder:       NewDogPacketEncoder,
+		Decoder:       NewDogPacketDecoder,
+		Ping:          dogPing,
+		PingInterval:  20 * time.Millisecond,
+	}
+	c.Start()
+	defer c.Stop()
+
+	time.Sleep(100 * time.Millisecond)
+	if c.Healthy() {
+		t.Fatal("expect client unhealthy")
+	}
+	if n := atomic.LoadInt32(&dials); n < 2 {
+		t.Fatalf("expect connection dialed again, dials %d", n)
+	}
+}
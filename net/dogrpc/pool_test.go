@@ -0,0 +1,67 @@
+/**
+ * Copyright 2018 gd Author. All Rights Reserved.
+ * Author: Chuck1024
+ */
+
+package dogrpc
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientConnRecycle(t *testing.T) {
+	s := NewDogRpcServer()
+	s.AddCtxHandler(1, func(ctx context.Context, req []byte) (uint32, []byte) {
+		time.Sleep(20 * time.Millisecond)
+		return 0, req
+	})
+	startPipeServer(t, s, "recycle")
+	defer s.ss.Stop()
+
+	var dials int32
+	c := &Client{
+		Addr:       "recycle",
+		Conns:      2,
+		MaxConnAge: 50 * time.Millisecond,
+		Dial: func(addr string) (io.ReadWriteCloser, error) {
+			atomic.AddInt32(&dials, 1)
+			return dialPipe(addr)
+		},
+		Encoder: NewDogPacketEncoder,
+		Decoder: NewDogPacketDecoder,
+	}
+	c.Start()
+	defer c.Stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for st := time.Now(); time.Since(st) < 300*time.Millisecond; {
+				rsp, err := c.Call(NewDogPacket(1, []byte("hello")))
+				if err != nil {
+					t.Errorf("call occur error:%s", err)
+					return
+				}
+				if p := rsp.(*DogPacket); p.ErrCode != 0 || string(p.Body) != "hello" {
+					t.Errorf("unexpected response code %d body %s", p.ErrCode, p.Body)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&dials); n < 4 {
+		t.Fatalf("expect connections recycled, dials %d", n)
+	}
+	if n := c.LiveConns(); n != 2 {
+		t.Fatalf("expect 2 live connections, got %d", n)
+	}
+}
@@ -0,0 +1,370 @@
+/**
+ * Copyright 2018 gd Author. All Rights Reserved.
+ * Author: Chuck1024
+ */
+
+package dogrpc
+
+import (
+	dogError "github.com/gdp-org/gd/derror"
+	"github.com/gdp-org/gd/dlog"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+ * retry policy of RpcClient. a failed call is retried with exponential backoff and jitter on the
+ * node picked again, while retries and hedged requests are limited by a budget shared by all calls
+ * of the policy: each call deposits BudgetRatio token, each retry or hedge withdraws one, and
+ * MinRetriesPerSecond tokens are refilled every second anyway.
+ *
+ * hedging sends the same request to another node if the first one is not answered within the
+ * HedgePercentile latency of recent calls, and the first answer wins. only commands in HedgeCmds
+ * are hedged, which must be idempotent.
+ */
+
+const (
+	DefaultRetryBaseBackoff     = 10 * time.Millisecond
+	DefaultRetryMaxBackoff      = time.Second
+	DefaultRetryBudgetRatio     = 0.1
+	DefaultMinRetriesPerSecond  = 10
+	DefaultHedgeMinDelay        = 10 * time.Millisecond
+	defaultLatencySamples       = 1024
+	defaultLatencyRefreshPeriod = 64
+)
+
+type RetryPolicy struct {
+	MaxRetries int
+	// RetryCodes are codes of call error or response ErrCode to retry, default TimeOutError, OverflowError
+	// and ShutdownError
+	RetryCodes          []uint32
+	BaseBackoff         time.Duration
+	MaxBackoff          time.Duration
+	BudgetRatio         float64
+	MinRetriesPerSecond int
+	// HedgePercentile in (0, 1) enables hedging of HedgeCmds, e.g. 0.95
+	HedgePercentile float64
+	// HedgeCmds are idempotent commands which may be hedged
+	HedgeCmds []uint32
+	// HedgeMinDelay is the least hedge delay, also used before there are enough latency samples
+	HedgeMinDelay time.Duration
+
+	initOnce sync.Once
+	budget   *retryBudget
+	latency  *latencyStat
+	randLock sync.Mutex
+	rand     *rand.Rand
+}
+
+func (p *RetryPolicy) init() {
+	p.initOnce.Do(func() {
+		if len(p.RetryCodes) == 0 {
+			p.RetryCodes = []uint32{uint32(TimeOutError.Code()), uint32(OverflowError.Code()), uint32(ShutdownError.Code())}
+		}
+		if p.BaseBackoff <= 0 {
+			p.BaseBackoff = DefaultRetryBaseBackoff
+		}
+		if p.MaxBackoff <= 0 {
+			p.MaxBackoff = DefaultRetryMaxBackoff
+		}
+		if p.BudgetRatio <= 0 {
+			p.BudgetRatio = DefaultRetryBudgetRatio
+		}
+		if p.MinRetriesPerSecond <= 0 {
+			p.MinRetriesPerSecond = DefaultMinRetriesPerSecond
+		}
+		if p.HedgeMinDelay <= 0 {
+			p.HedgeMinDelay = DefaultHedgeMinDelay
+		}
+
+		p.budget = newRetryBudget(p.BudgetRatio, p.MinRetriesPerSecond)
+		p.latency = newLatencyStat(defaultLatencySamples, p.HedgePercentile)
+		p.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	})
+}
+
+func (p *RetryPolicy) retryable(rsp Packet, err *dogError.CodeError) bool {
+	var code uint32
+	if err != nil {
+		code = uint32(err.Code())
+	} else if rsp != nil {
+		code = packetErrCode(rsp)
+	}
+
+	if code == 0 {
+		return false
+	}
+	for _, c := range p.RetryCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// hedgeable reports whether cmd is in HedgeCmds.
+func (p *RetryPolicy) hedgeable(cmd uint32) bool {
+	if p.HedgePercentile <= 0 || p.HedgePercentile >= 1 {
+		return false
+	}
+	for _, c := range p.HedgeCmds {
+		if c == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns a random duration in [0, min(MaxBackoff, BaseBackoff * 2^attempt)), the full jitter.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.MaxBackoff
+	if attempt < 32 {
+		if b := p.BaseBackoff << uint(attempt); b > 0 && b < d {
+			d = b
+		}
+	}
+
+	p.randLock.Lock()
+	defer p.randLock.Unlock()
+	return time.Duration(p.rand.Int63n(int64(d)))
+}
+
+type retryBudget struct {
+	lock         sync.Mutex
+	ratio        float64
+	minPerSecond float64
+	max          float64
+	tokens       float64
+	last         time.Time
+}
+
+func newRetryBudget(ratio float64, minPerSecond int) *retryBudget {
+	return &retryBudget{
+		ratio:        ratio,
+		minPerSecond: float64(minPerSecond),
+		max:          float64(minPerSecond) * 10,
+		tokens:       float64(minPerSecond),
+		last:         time.Now(),
+	}
+}
+
+func (b *retryBudget) deposit() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.tokens += b.ratio
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+}
+
+func (b *retryBudget) withdraw() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.minPerSecond
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// latencyStat keeps latency of recent calls, the percentile is refreshed every defaultLatencyRefreshPeriod samples.
+type latencyStat struct {
+	lock       sync.Mutex
+	percentile float64
+	samples    []time.Duration
+	next       int
+	full       bool
+	added      int
+	value      time.Duration
+}
+
+func newLatencyStat(n int, percentile float64) *latencyStat {
+	return &latencyStat{percentile: percentile, samples: make([]time.Duration, n)}
+}
+
+func (s *latencyStat) add(d time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.samples[s.next] = d
+	s.next++
+	if s.next == len(s.samples) {
+		s.next = 0
+		s.full = true
+	}
+
+	s.added++
+	if s.added%defaultLatencyRefreshPeriod == 0 {
+		n := s.next
+		if s.full {
+			n = len(s.samples)
+		}
+		sorted := make([]time.Duration, n)
+		copy(sorted, s.samples[:n])
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		s.value = sorted[int(float64(n-1)*s.percentile)]
+	}
+}
+
+// get returns the latency percentile, 0 if there are not enough samples.
+func (s *latencyStat) get() time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.value
+}
+
+func packetCmd(p Packet) uint32 {
+	switch pkt := p.(type) {
+	case *DogPacket:
+		return pkt.Cmd
+	case *RpcPacket:
+		return pkt.Cmd
+	}
+	return 0
+}
+
+func packetErrCode(p Packet) uint32 {
+	switch pkt := p.(type) {
+	case *DogPacket:
+		return pkt.ErrCode
+	case *RpcPacket:
+		return pkt.ErrCode
+	}
+	return 0
+}
+
+// clonePacket copies p with a new seq, so that it can be sent concurrently with p.
+func clonePacket(p Packet) Packet {
+	switch pkt := p.(type) {
+	case *DogPacket:
+		cp := *pkt
+		cp.Seq = nextDogSeq()
+		return &cp
+	case *RpcPacket:
+		cp := *pkt
+		cp.Seq = nextSeq()
+		return &cp
+	}
+	return p
+}
+
+type callResult struct {
+	rsp Packet
+	err *dogError.CodeError
+}
+
+// callPolicy calls req by picked nodes with retry policy of client, each attempt waits timeout
+// at most, and all attempts end before deadline if it is not zero.
+func (c *RpcClient) callPolicy(key string, dog bool, req Packet, timeout time.Duration, deadline time.Time) (Packet, *dogError.CodeError) {
+	p := c.RetryPolicy
+	p.init()
+	p.budget.deposit()
+
+	for attempt := 0; ; attempt++ {
+		t := timeout
+		if !deadline.IsZero() {
+			if d := time.Until(deadline); d < t {
+				t = d
+			}
+			if t <= 0 {
+				return nil, TimeOutError
+			}
+		}
+
+		rsp, err := c.callHedged(key, dog, req, t)
+		if !p.retryable(rsp, err) || attempt >= p.MaxRetries {
+			return rsp, err
+		}
+
+		// no attempt is left if backoff reaches deadline
+		b := p.backoff(attempt)
+		if !deadline.IsZero() && b >= time.Until(deadline) {
+			return rsp, err
+		}
+
+		if !p.budget.withdraw() {
+			dlog.Warn("rpc client retry budget exhausted, req %d is not retried", req.ID())
+			return rsp, err
+		}
+
+		time.Sleep(b)
+	}
+}
+
+// callHedged sends a hedged request to another node if req is not answered within the hedge delay.
+func (c *RpcClient) callHedged(key string, dog bool, req Packet, timeout time.Duration) (Packet, *dogError.CodeError) {
+	p := c.RetryPolicy
+	results := make(chan callResult, 2)
+	st := time.Now()
+	addr, err := c.callAsyncNode(key, "", dog, clonePacket(req), timeout, results)
+	if err != nil {
+		return nil, err
+	}
+
+	var delay time.Duration
+	if p.hedgeable(packetCmd(req)) {
+		if delay = p.latency.get(); delay < p.HedgeMinDelay {
+			delay = p.HedgeMinDelay
+		}
+	}
+
+	if delay <= 0 || delay >= timeout {
+		r := <-results
+		if r.err == nil {
+			p.latency.add(time.Now().Sub(st))
+		}
+		return r.rsp, r.err
+	}
+
+	pending := 1
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case r := <-results:
+		if r.err == nil {
+			p.latency.add(time.Now().Sub(st))
+		}
+		return r.rsp, r.err
+	case <-t.C:
+		if p.budget.withdraw() {
+			if _, err := c.callAsyncNode(key, addr, dog, clonePacket(req), timeout-delay, results); err == nil {
+				pending++
+			}
+		}
+	}
+
+	// the first success wins, or the last failure
+	var r callResult
+	for ; pending > 0; pending-- {
+		if r = <-results; r.err == nil && !p.retryable(r.rsp, nil) {
+			p.latency.add(time.Now().Sub(st))
+			break
+		}
+	}
+	return r.rsp, r.err
+}
+
+// callAsyncNode calls req on a node other than except, result is sent to results when it is done.
+func (c *RpcClient) callAsyncNode(key, except string, dog bool, req Packet, timeout time.Duration, results chan<- callResult) (string, *dogError.CodeError) {
+	ct, release, err := c.acquire(key, except, dog)
+	if err != nil {
+		return "", err
+	}
+
+	go func() {
+		rsp, err := ct.CallTimeout(req, timeout, 0)
+		release(err == nil)
+		results <- callResult{rsp: rsp, err: err}
+	}()
+	return ct.Addr, nil
+}
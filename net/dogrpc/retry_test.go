@@ -0,0 +1,104 @@
+/**
+ * Copyright 2018 gd Author. All Rights Reserved.
+ * Author: Chuck1024
+ */
+
+package dogrpc
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := &RetryPolicy{BaseBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	p.init()
+
+	for attempt := 0; attempt < 40; attempt++ {
+		max := p.MaxBackoff
+		if attempt < 3 {
+			max = p.BaseBackoff << uint(attempt)
+		}
+		if d := p.backoff(attempt); d < 0 || d >= max {
+			t.Fatalf("backoff %s of attempt %d out of [0, %s)", d, attempt, max)
+		}
+	}
+}
+
+func TestRetryPolicyRetryable(t *testing.T) {
+	p := &RetryPolicy{}
+	p.init()
+
+	if !p.retryable(nil, TimeOutError) || !p.retryable(nil, OverflowError) {
+		t.Fatal("expect timeout and overflow retryable")
+	}
+	if p.retryable(nil, InvalidParam) {
+		t.Fatal("expect invalid param not retryable")
+	}
+
+	rsp := NewDogPacket(1024, nil)
+	if p.retryable(rsp, nil) {
+		t.Fatal("expect success not retryable")
+	}
+	rsp.ErrCode = uint32(OverflowError.Code())
+	if !p.retryable(rsp, nil) {
+		t.Fatal("expect response of overflow retryable")
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	b := newRetryBudget(0.5, 1)
+	if !b.withdraw() {
+		t.Fatal("expect initial token")
+	}
+	if b.withdraw() {
+		t.Fatal("expect budget exhausted")
+	}
+
+	b.deposit()
+	b.deposit()
+	if !b.withdraw() {
+		t.Fatal("expect token of deposits")
+	}
+}
+
+func TestRetryPolicyHedgeable(t *testing.T) {
+	p := &RetryPolicy{HedgeCmds: []uint32{1}}
+	if p.hedgeable(1) {
+		t.Fatal("expect no hedging without percentile")
+	}
+
+	p.HedgePercentile = 0.9
+	if !p.hedgeable(1) || p.hedgeable(2) {
+		t.Fatal("expect only cmd 1 hedged")
+	}
+}
+
+func TestRetryDeadline(t *testing.T) {
+	s := NewDogRpcServer()
+	s.AddHandler(1, func(req []byte) (uint32, []byte) {
+		return uint32(OverflowError.Code()), nil
+	})
+	s.ss.Addr = "127.0.0.1:0"
+	if err := s.ss.Start(); err != nil {
+		t.Fatalf("server start occur error:%s", err)
+	}
+	defer s.ss.Stop()
+
+	c := NewClient(time.Second, 0, false, nil, "", "", "").AddAddr(s.ss.Listener.ListenAddr().String())
+	defer c.Stop()
+	c.RetryPolicy = &RetryPolicy{MaxRetries: 100, BaseBackoff: time.Second, MaxBackoff: time.Second}
+
+	// no backoff sleeps past deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	st := time.Now()
+	code, _, err := c.DogInvokeCtx(ctx, 1, "hello")
+	if err == nil && code != uint32(OverflowError.Code()) || err != nil && err.Code() != TimeOutError.Code() {
+		t.Fatalf("unexpected code %d error %v", code, err)
+	}
+	if d := time.Since(st); d > 150*time.Millisecond {
+		t.Fatalf("retry takes %v, deadline is 100ms", d)
+	}
+}
@@ -6,16 +6,21 @@
 package dogrpc
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"fmt"
 	dogError "github.com/gdp-org/gd/derror"
 	"github.com/gdp-org/gd/dlog"
+	"github.com/gdp-org/gd/runtime/breaker"
+	"github.com/gdp-org/gd/service/discovery"
 	"github.com/gdp-org/gd/utls/network"
 	"io"
 	"io/ioutil"
-	"math/rand"
 	"net"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -26,15 +31,47 @@ import (
 type RpcClient struct {
 	Cm       map[string]*Client
 	cmMutex  sync.Mutex
-	addrs    []*net.TCPAddr
 	Timeout  time.Duration
 	RetryNum uint32
 	localIp  string
 
+	// DogVersion is the highest DogPacket version sent by DogInvoke, default VersionCrc32c. the
+	// version is lowered to that of server told by ping, servers which do not tell it get Version,
+	// and deadline and metadata of ctx are not sent then.
+	DogVersion uint8
+	// Codec is the body codec of DogInvoke, default CodecJson. rsp is marshaled by the same codec.
+	Codec uint8
+
 	TlsCfg           *tls.Config
 	RpcCaPemFile     string
 	RpcClientKeyFile string
 	RpcClientPemFile string
+
+	// ConnsPerAddr is the number of connections to each server, default DefaultConnectNumbers.
+	// MaxConnAge recycles connections after about it, 0 never recycles.
+	ConnsPerAddr int
+	MaxConnAge   time.Duration
+	// PingInterval is the keepalive interval of idle connections of DogInvoke, default DefaultPingInterval.
+	PingInterval time.Duration
+
+	// Network of server addrs, NetworkTcp, NetworkUnix or NetworkPipe, default NetworkTcp.
+	Network string
+
+	// Breaker ejects nodes failing too much, nil disables it.
+	Breaker *breaker.Group
+	// RetryPolicy replaces RetryNum if it is set.
+	RetryPolicy *RetryPolicy
+
+	interceptors    []ClientInterceptor
+	cmdInterceptors map[uint32][]ClientInterceptor
+
+	nodes      []*Node
+	nodesLock  sync.Mutex
+	balancer   Balancer
+	discovery  discovery.DogDiscovery
+	serviceKey string
+	stopChan   chan struct{}
+	stopOnce   sync.Once
 }
 
 func NewClient(timeout time.Duration, retryNum uint32, useTls bool, cfg *tls.Config, ca, serverKey, serverPem string) *RpcClient {
@@ -89,16 +126,235 @@ func NewClient(timeout time.Duration, retryNum uint32, useTls bool, cfg *tls.Con
 
 // add server address
 func (c *RpcClient) AddAddr(addr string) *RpcClient {
-	if addr2, err := net.ResolveTCPAddr("tcp", addr); err != nil {
-		dlog.Error("parse addr failed, %s", err.Error())
-	} else {
-		c.addrs = append(c.addrs, addr2)
+	if c.Network == "" || c.Network == NetworkTcp {
+		addr2, err := net.ResolveTCPAddr("tcp", addr)
+		if err != nil {
+			dlog.Error("parse addr failed, %s", err.Error())
+			return c
+		}
+		addr = addr2.String()
+	}
+
+	c.nodesLock.Lock()
+	weights := make(map[string]int, len(c.nodes)+1)
+	for _, n := range c.nodes {
+		weights[n.Addr] = n.Weight
 	}
+	weights[addr] = 1
+	c.nodesLock.Unlock()
+	c.setNodes(weights)
 	return c
 }
 
+// SetPool sets the number of connections to each server and their max age, requests to a server are
+// spread across its connections. it must be called before any request.
+func (c *RpcClient) SetPool(connsPerAddr int, maxConnAge time.Duration) *RpcClient {
+	c.ConnsPerAddr = connsPerAddr
+	c.MaxConnAge = maxConnAge
+	return c
+}
+
+// SetNetwork sets network of server addrs, it must be called before AddAddr.
+func (c *RpcClient) SetNetwork(network string) *RpcClient {
+	c.Network = network
+	return c
+}
+
+// SetBalancer sets the balancer to choose server, default RandomBalancer.
+func (c *RpcClient) SetBalancer(b Balancer) *RpcClient {
+	c.nodesLock.Lock()
+	defer c.nodesLock.Unlock()
+	c.balancer = b
+	b.Update(c.nodes)
+	return c
+}
+
+// SetBreaker sets the circuit breaker of nodes, nodes whose breaker is open are not picked.
+func (c *RpcClient) SetBreaker(g *breaker.Group) *RpcClient {
+	c.Breaker = g
+	return c
+}
+
+// SetRetryPolicy sets the retry policy of Invoke and DogInvoke without client, which replaces RetryNum.
+func (c *RpcClient) SetRetryPolicy(p *RetryPolicy) *RpcClient {
+	c.RetryPolicy = p
+	return c
+}
+
+// SetDiscovery makes servers of client follow the online nodes of key in d, which are refreshed
+// every DefaultDiscoveryInterval. clients of removed nodes are stopped.
+func (c *RpcClient) SetDiscovery(d discovery.DogDiscovery, key string) *RpcClient {
+	c.discovery = d
+	c.serviceKey = key
+	c.stopChan = make(chan struct{})
+	c.refreshNodes()
+
+	go func() {
+		t := time.NewTicker(DefaultDiscoveryInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				c.refreshNodes()
+			case <-c.stopChan:
+				return
+			}
+		}
+	}()
+	return c
+}
+
+func (c *RpcClient) refreshNodes() {
+	weights := make(map[string]int)
+	for _, info := range c.discovery.GetNodeInfo(c.serviceKey) {
+		if info == nil || info.GetOffline() {
+			continue
+		}
+
+		weight := int(info.GetWeight())
+		if weight <= 0 {
+			weight = 1
+		}
+		weights[fmt.Sprintf("%s:%d", info.GetIp(), info.GetPort())] = weight
+	}
+
+	c.setNodes(weights)
+}
+
+// setNodes replaces nodes by weights of addr, nodes whose weight is not changed are kept.
+func (c *RpcClient) setNodes(weights map[string]int) {
+	c.nodesLock.Lock()
+	old := make(map[string]*Node, len(c.nodes))
+	for _, n := range c.nodes {
+		old[n.Addr] = n
+	}
+
+	changed := len(weights) != len(c.nodes)
+	nodes := make([]*Node, 0, len(weights))
+	for addr, weight := range weights {
+		n, ok := old[addr]
+		if !ok || n.Weight != weight {
+			n = &Node{Addr: addr, Weight: weight}
+			changed = true
+		}
+		nodes = append(nodes, n)
+		delete(old, addr)
+	}
+
+	if changed {
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i].Addr < nodes[j].Addr })
+		c.nodes = nodes
+		c.getBalancer().Update(nodes)
+		dlog.Info("rpc client nodes changed, nodes num %d", len(nodes))
+	}
+	c.nodesLock.Unlock()
+
+	for addr := range old {
+		if c.Breaker != nil {
+			c.Breaker.Remove(addr)
+		}
+
+		c.cmMutex.Lock()
+		cc, ok := c.Cm[addr]
+		delete(c.Cm, addr)
+		c.cmMutex.Unlock()
+		if ok {
+			cc.Stop()
+			dlog.Info("rpc client stop client of removed node %s", addr)
+		}
+	}
+}
+
+// getBalancer must be called with nodesLock held.
+func (c *RpcClient) getBalancer() Balancer {
+	if c.balancer == nil {
+		c.balancer = NewRandomBalancer()
+	}
+	return c.balancer
+}
+
+// pickNode picks a node other than except by balancer, nodes whose breaker is open are skipped,
+// and nodes which cannot be connected are skipped if there is any other node.
+func (c *RpcClient) pickNode(key, except string) (*Node, *dogError.CodeError) {
+	c.nodesLock.Lock()
+	b := c.getBalancer()
+	nodes := c.nodes
+	c.nodesLock.Unlock()
+
+	available := func(n *Node) bool {
+		return n.Addr != except && (c.Breaker == nil || c.Breaker.Get(n.Addr).State() != breaker.StateOpen)
+	}
+
+	n := b.Pick(key)
+	if n == nil {
+		return nil, InternalServerError
+	}
+	if available(n) && c.healthy(n.Addr) {
+		return n, nil
+	}
+
+	for _, n := range nodes {
+		if available(n) && c.healthy(n.Addr) {
+			return n, nil
+		}
+	}
+	if available(n) {
+		return n, nil
+	}
+	for _, n := range nodes {
+		if available(n) {
+			return n, nil
+		}
+	}
+	if except != "" {
+		return nil, InternalServerError
+	}
+	return nil, BreakerOpenError
+}
+
+// healthy reports whether client of addr is not failed to connect.
+func (c *RpcClient) healthy(addr string) bool {
+	c.cmMutex.Lock()
+	cc, ok := c.Cm[addr]
+	c.cmMutex.Unlock()
+	return !ok || cc.Healthy()
+}
+
+// acquire picks a node other than except for a request and returns its client, release must be
+// called with whether the request succeed.
+func (c *RpcClient) acquire(key, except string, dog bool) (*Client, func(success bool), *dogError.CodeError) {
+	n, err := c.pickNode(key, except)
+	if err != nil {
+		return nil, nil, err
+	}
+	var br *breaker.Breaker
+	if c.Breaker != nil {
+		br = c.Breaker.Get(n.Addr)
+		if !br.Allow() {
+			return nil, nil, BreakerOpenError
+		}
+	}
+
+	st := time.Now()
+	atomic.AddInt32(&n.pending, 1)
+	return c.connect(n.Addr, dog), func(success bool) {
+		atomic.AddInt32(&n.pending, -1)
+		if br != nil {
+			br.Done(success, time.Now().Sub(st))
+		}
+	}, nil
+}
+
 // Stop stop client
 func (c *RpcClient) Stop() {
+	c.stopOnce.Do(func() {
+		if c.stopChan != nil {
+			close(c.stopChan)
+		}
+	})
+
+	c.cmMutex.Lock()
+	defer c.cmMutex.Unlock()
 	for addr, cc := range c.Cm {
 		cc.Stop()
 		dlog.Error("dog rpc client stop client %s", addr)
@@ -109,66 +365,109 @@ func (c *RpcClient) Stop() {
 
 // connect
 func (c *RpcClient) Connect() (*Client, error) {
-	addr := &net.TCPAddr{}
-
-	if len(c.addrs) > 0 {
-		rand.Seed(time.Now().UnixNano())
-		idx := rand.Intn(len(c.addrs))
-		addr = c.addrs[idx]
-	} else {
-		return nil, InternalServerError
+	n, err := c.pickNode("", "")
+	if err != nil {
+		return nil, err
 	}
 
-	cc, ok := c.Cm[addr.String()]
-	if !ok {
-		c.cmMutex.Lock()
-		defer c.cmMutex.Unlock()
-		if cc, ok = c.Cm[addr.String()]; !ok {
-			cc = &Client{
-				Addr:           addr.String(),
-				RequestTimeout: c.Timeout,
-			}
+	return c.connect(n.Addr, false), nil
+}
 
-			if c.TlsCfg != nil {
-				cc.Dial = func(addr string) (conn io.ReadWriteCloser, err error) {
-					c, err := tls.DialWithDialer(dialer, DefaultDialNetWork, addr, c.TlsCfg)
-					if err != nil {
-						return nil, err
-					}
-					return c, err
-				}
-			}
+// requestTimeout returns the timeout of request, Timeout is in millisecond for dog packet.
+func (c *RpcClient) requestTimeout(dog bool) time.Duration {
+	timeout := c.Timeout
+	if dog {
+		timeout = time.Millisecond * time.Duration(c.Timeout)
+	}
+	if timeout <= 0 {
+		timeout = DefaultRequestTimeout
+	}
+	return timeout
+}
 
+// connect returns the client of addr, which is created and started if not exist.
+func (c *RpcClient) connect(addr string, dog bool) *Client {
+	c.cmMutex.Lock()
+	defer c.cmMutex.Unlock()
+
+	cc, ok := c.Cm[addr]
+	if ok {
+		if cc.clientStopChan == nil {
 			cc.Start()
-			c.Cm[addr.String()] = cc
-		} else {
-			dlog.Warn("[Connect] Addr %s already created.", addr)
 		}
+		return cc
+	}
+
+	cc = &Client{
+		Addr:           addr,
+		Conns:          c.ConnsPerAddr,
+		RequestTimeout: c.Timeout,
+		MaxConnAge:     c.MaxConnAge,
+	}
+	if dog {
+		cc.RequestTimeout = c.requestTimeout(true)
+		cc.Encoder = NewDogPacketEncoder
+		cc.Decoder = NewDogPacketDecoder
+		cc.Ping = dogPing
+		cc.PingInterval = c.PingInterval
+	}
+
+	if dial, err := NewDial(c.Network); err != nil {
+		dlog.Error("rpc client dial %s occur error:%s", addr, err)
 	} else {
-		if cc.clientStopChan == nil {
-			cc.Start()
+		cc.Dial = dial
+	}
+
+	if c.TlsCfg != nil {
+		network := DefaultDialNetWork
+		if c.Network == NetworkUnix {
+			network = NetworkUnix
+		}
+		cc.Dial = func(addr string) (conn io.ReadWriteCloser, err error) {
+			c, err := tls.DialWithDialer(dialer, network, addr, c.TlsCfg)
+			if err != nil {
+				return nil, err
+			}
+			return c, err
 		}
 	}
 
-	return cc, nil
+	cc.Start()
+	c.Cm[addr] = cc
+	return cc
 }
 
 // Invoke rpc call
 func (c *RpcClient) Invoke(cmd uint32, req []byte, client ...*Client) (code uint32, rsp []byte, err *dogError.CodeError) {
+	return c.invoke(context.Background(), cmd, nextSeq(), req, func(ctx *Context) (uint32, []byte, *dogError.CodeError) {
+		return c.rpcInvoke(ctx.Cmd, ctx.Seq, ctx.Req, client...)
+	})
+}
+
+func (c *RpcClient) rpcInvoke(cmd uint32, seq uint32, req []byte, client ...*Client) (code uint32, rsp []byte, err *dogError.CodeError) {
+	var reqPkt, rspPkt Packet
+	reqPkt = NewRpcPacketWithSeq(cmd, req, seq)
+	if len(client) == 0 && c.RetryPolicy != nil {
+		if rspPkt, err = c.callPolicy("", false, reqPkt, c.requestTimeout(false), time.Time{}); err != nil {
+			dlog.Error("[Invoke] call occur error:%v ", err)
+			return code, nil, err
+		}
+		return rspPkt.(*RpcPacket).ErrCode, rspPkt.(*RpcPacket).Body, nil
+	}
+
 	var ct *Client
 	if len(client) == 0 {
-		cc, err := c.Connect()
-		if err != nil {
-			dlog.Error("[Invoke] connect occur error:%s", err)
-			return code, nil, InternalServerError
+		cc, release, cErr := c.acquire("", "", false)
+		if cErr != nil {
+			dlog.Error("[Invoke] connect occur error:%s", cErr)
+			return code, nil, cErr
 		}
 		ct = cc
+		defer func() { release(err == nil) }()
 	} else {
 		ct = client[0]
 	}
 
-	var reqPkt, rspPkt Packet
-	reqPkt = NewRpcPacket(cmd, req)
 	if rspPkt, err = ct.CallRetry(reqPkt, c.RetryNum); err != nil {
 		dlog.Error("[Invoke] CallRetry occur error:%v ", err)
 		return code, nil, err
@@ -6,14 +6,18 @@
 package dogrpc
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
 	"fmt"
+	dogError "github.com/gdp-org/gd/derror"
 	"github.com/gdp-org/gd/dlog"
+	"github.com/gdp-org/gd/runtime/limiter"
 	"io/ioutil"
 	"net"
 	"strconv"
+	"time"
 )
 
 /*
@@ -22,11 +26,31 @@ import (
 
 type RpcHandlerFunc func([]byte) (uint32, []byte)
 
+// RpcCtxHandlerFunc is like RpcHandlerFunc, but receives the request context which carries
+// the trace id, client addr, handler deadline and is canceled on client disconnect.
+type RpcCtxHandlerFunc func(ctx context.Context, req []byte) (uint32, []byte)
+
 type RpcServer struct {
 	Addr           int `inject:"rpcHost"`
 	ss             *Server
-	defaultHandler map[uint32]RpcHandlerFunc
+	dog            bool
+	defaultHandler map[uint32]RpcCtxHandlerFunc
 	wrapHandler    map[uint32]interface{}
+	streamHandler  map[uint32]StreamHandlerFunc
+
+	interceptors    []ServerInterceptor
+	cmdInterceptors map[uint32][]ServerInterceptor
+
+	// Network is NetworkTcp, NetworkUnix or NetworkPipe, default NetworkTcp. Addr is the port of
+	// NetworkTcp, SockAddr is the socket path of NetworkUnix or the name of NetworkPipe.
+	Network  string `inject:"rpcNetwork" canNil:"true"`
+	SockAddr string `inject:"rpcSockAddr" canNil:"true"`
+
+	HandlerTimeout time.Duration    `inject:"rpcHandlerTimeout" canNil:"true"`
+	Limiter        *limiter.Limiter `inject:"rpcLimiter" canNil:"true"`
+	Auth           *Auth            `inject:"rpcAuth" canNil:"true"`
+	// ShutdownTimeout in second
+	ShutdownTimeout int64 `inject:"rpcShutdownTimeout" canNil:"true"`
 
 	UseTls           bool   `inject:"rpcUseTls" canNil:"true"`
 	RpcCaPemFile     string `inject:"rpcCaPemFile" canNil:"true"`
@@ -36,20 +60,41 @@ type RpcServer struct {
 
 func NewRpcServer() *RpcServer {
 	s := &RpcServer{
-		defaultHandler: make(map[uint32]RpcHandlerFunc),
+		defaultHandler: make(map[uint32]RpcCtxHandlerFunc),
 	}
 
 	s.ss = &Server{
-		Handler: s.dispatchPacket,
+		CtxHandler: s.dispatchPacket,
+		Reject:     rpcReject,
 	}
 
 	return s
 }
 
 func (s *RpcServer) Start() error {
+	if s.Auth != nil && !s.dog {
+		return errors.New("rpc auth is not supported by RpcPacket, which carries no metadata")
+	}
+
 	s.ss.Addr = fmt.Sprintf(":%d", s.Addr)
+	if s.Network != "" && s.Network != NetworkTcp {
+		if s.SockAddr == "" {
+			return fmt.Errorf("rpc server network %s without sock addr", s.Network)
+		}
+		s.ss.Addr = s.SockAddr
+
+		ln, err := NewListener(s.Network)
+		if err != nil {
+			return err
+		}
+		s.ss.Listener = ln
+	}
 
 	if s.UseTls {
+		if s.Network == NetworkPipe {
+			return errors.New("tls is not supported by pipe network")
+		}
+
 		if s.RpcCaPemFile == "" {
 			s.RpcCaPemFile = "conf/ca.pem"
 		}
@@ -85,6 +130,13 @@ func (s *RpcServer) Start() error {
 
 		s.ss.Listener = &netListener{
 			F: func(addr string) (net.Listener, error) {
+				if s.Network == NetworkUnix {
+					ln, err := listenUnix(addr)
+					if err != nil {
+						return nil, err
+					}
+					return tls.NewListener(ln, serverCfg), nil
+				}
 				return tls.Listen("tcp", addr, serverCfg)
 			},
 		}
@@ -99,13 +151,24 @@ func (s *RpcServer) Start() error {
 	return nil
 }
 
+// Close stops accepting connections, and waits pending requests up to ShutdownTimeout. requests
+// received meanwhile are answered with ShutdownError, see Server.Shutdown.
 func (s *RpcServer) Close() {
-	s.ss.Stop()
+	if s.ShutdownTimeout <= 0 {
+		s.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	s.ss.Shutdown(time.Duration(s.ShutdownTimeout) * time.Second)
 }
 
 func (s *RpcServer) AddHandler(headCmd uint32, f RpcHandlerFunc) {
+	s.AddCtxHandler(headCmd, func(ctx context.Context, req []byte) (uint32, []byte) {
+		return f(req)
+	})
+}
+
+func (s *RpcServer) AddCtxHandler(headCmd uint32, f RpcCtxHandlerFunc) {
 	if s.defaultHandler == nil {
-		s.defaultHandler = make(map[uint32]RpcHandlerFunc)
+		s.defaultHandler = make(map[uint32]RpcCtxHandlerFunc)
 	}
 
 	if _, ok := s.defaultHandler[headCmd]; ok {
@@ -117,7 +180,45 @@ func (s *RpcServer) AddHandler(headCmd uint32, f RpcHandlerFunc) {
 	dlog.Info("register head cmd [%d] success.", headCmd)
 }
 
-func (s *RpcServer) dispatchPacket(clientAddr string, req Packet) (rsp Packet) {
+// requestContext returns a copy of ctx carrying client addr and trace id of request.
+func requestContext(ctx context.Context, clientAddr string) context.Context {
+	traceId := IncomingMetadata(ctx)[MetaTraceId]
+	if traceId == "" {
+		traceId = strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+	ctx = context.WithValue(ctx, clientAddrKey, clientAddr)
+	return WithTraceId(ctx, traceId)
+}
+
+// newContext builds the filter context of one request. the returned cancel func must be called when the request is done.
+func (s *RpcServer) newContext(ctx context.Context, clientAddr string, seq uint32, headCmd uint32, f RpcCtxHandlerFunc, req []byte) (*Context, context.CancelFunc) {
+	ctx = requestContext(ctx, clientAddr)
+
+	var cancel context.CancelFunc
+	if s.HandlerTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.HandlerTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	c := &Context{
+		ClientAddr: clientAddr,
+		Seq:        seq,
+		Cmd:        headCmd,
+		Method:     strconv.Itoa(int(headCmd)),
+		Req:        req,
+		Meta:       IncomingMetadata(ctx),
+		Ctx:        ctx,
+		StartTime:  time.Now(),
+	}
+	c.Handler = func(req []byte) (uint32, []byte) {
+		return f(c.Ctx, req)
+	}
+
+	return c, cancel
+}
+
+func (s *RpcServer) dispatchPacket(ctx context.Context, clientAddr string, req Packet) (rsp Packet) {
 	packet := req.(*RpcPacket)
 	headCmd := packet.Cmd
 
@@ -127,13 +228,34 @@ func (s *RpcServer) dispatchPacket(clientAddr string, req Packet) (rsp Packet) {
 		return NewRpcPacketWithRet(headCmd, []byte(""), packet.Seq, uint32(InvalidParam.Code()))
 	}
 
-	code, body := globalFilter.Handle(&Context{
-		ClientAddr: clientAddr,
-		Seq:        packet.Seq,
-		Method:     strconv.Itoa(int(headCmd)),
-		Handler:    f,
-		Req:        req.(*RpcPacket).Body,
-	})
+	var err *dogError.CodeError
+	if ctx, err = s.authenticate(ctx, clientAddr, headCmd, packet.Body); err != nil {
+		return NewRpcPacketWithRet(headCmd, []byte(""), packet.Seq, uint32(err.Code()))
+	}
+
+	release, ok := s.acquire(headCmd)
+	if !ok {
+		return NewRpcPacketWithRet(headCmd, []byte(""), packet.Seq, uint32(OverflowError.Code()))
+	}
+	defer release()
+
+	c, cancel := s.newContext(ctx, clientAddr, packet.Seq, headCmd, f, packet.Body)
+	defer cancel()
+
+	code, body := s.handle(c)
 
 	return NewRpcPacketWithRet(packet.Cmd, body, packet.Seq, code)
 }
+
+func rpcReject(req Packet) Packet {
+	packet := req.(*RpcPacket)
+	return NewRpcPacketWithRet(packet.Cmd, []byte(""), packet.Seq, uint32(ShutdownError.Code()))
+}
+
+// acquire applies the limiter of cmd, release must be called after the request is handled if ok.
+func (s *RpcServer) acquire(cmd uint32) (release func(), ok bool) {
+	if s.Limiter == nil {
+		return func() {}, true
+	}
+	return s.Limiter.Acquire(strconv.FormatUint(uint64(cmd), 10))
+}
@@ -6,6 +6,7 @@
 package dogrpc
 
 import (
+	"context"
 	"fmt"
 	dogError "github.com/gdp-org/gd/derror"
 	"github.com/gdp-org/gd/dlog"
@@ -19,9 +20,19 @@ import (
 
 type HandlerFunc func(clientAddr string, req Packet) (rsp Packet)
 
+// CtxHandlerFunc is like HandlerFunc, but receives ctx which is canceled when the client connection
+// is closed or the server stops.
+type CtxHandlerFunc func(ctx context.Context, clientAddr string, req Packet) (rsp Packet)
+
+// FrameHandlerFunc is called in the connection reader goroutine before a request is dispatched to Handler,
+// so packets of the same connection are seen in order. it returns true if req is consumed, such as a stream frame.
+type FrameHandlerFunc func(ctx context.Context, conn *ServerConn, clientAddr string, req Packet) bool
+
 type Server struct {
 	Addr             string
 	Handler          HandlerFunc
+	CtxHandler       CtxHandlerFunc
+	FrameHandler     FrameHandlerFunc
 	Concurrency      int
 	FlushDelay       time.Duration
 	PendingResponses int
@@ -29,20 +40,36 @@ type Server struct {
 	RecvBufferSize   int
 	Listener         Listener
 	serverStopChan   chan struct{}
+	drainChan        chan struct{}
 	stopWg           sync.WaitGroup
 	Encoder          MessageEncoderFunc
 	Decoder          MessageDecoderFunc
+	// GoAway makes the packet sent to connected clients on Shutdown, which tells them to stop sending requests.
+	GoAway func() Packet
+	// Reject makes the response of request received after Shutdown starts, which is not handled.
+	// requests are still handled during Shutdown if it is nil.
+	Reject func(req Packet) Packet
+
+	conns     int32
+	workersCh chan struct{}
 }
 
 func (s *Server) Start() *dogError.CodeError {
-	if s.Handler == nil {
+	if s.Handler == nil && s.CtxHandler == nil {
 		panic("Server.Handler cannot be nil")
 	}
+	if s.CtxHandler == nil {
+		h := s.Handler
+		s.CtxHandler = func(ctx context.Context, clientAddr string, req Packet) Packet {
+			return h(clientAddr, req)
+		}
+	}
 
 	if s.serverStopChan != nil {
 		panic("server is already running. Stop it before starting it again")
 	}
 	s.serverStopChan = make(chan struct{})
+	s.drainChan = make(chan struct{})
 
 	if s.Concurrency <= 0 {
 		s.Concurrency = DefaultConcurrency
@@ -76,6 +103,7 @@ func (s *Server) Start() *dogError.CodeError {
 	}
 
 	workersCh := make(chan struct{}, s.Concurrency)
+	s.workersCh = workersCh
 	s.stopWg.Add(1)
 	go serverHandler(s, workersCh)
 	return nil
@@ -109,6 +137,32 @@ func (s *Server) Stop() {
 	dlog.Info("rpc server stop %s", s.Addr)
 }
 
+// Shutdown stops accepting connections and sends GoAway packet to connected clients, then waits
+// for pending requests of connections up to timeout before closing them. requests received after
+// it starts are answered by Reject, and each connection is closed as soon as it is idle, so that
+// clients of protocols without GoAway, such as RpcPacket, see the connection closed and dial again.
+func (s *Server) Shutdown(timeout time.Duration) {
+	if s.serverStopChan == nil {
+		panic("server must be started before stopping it")
+	}
+	close(s.drainChan)
+
+	done := make(chan struct{})
+	go func() {
+		s.stopWg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		dlog.Info("rpc server drain %s done", s.Addr)
+	case <-time.After(timeout):
+		dlog.Warn("rpc server drain %s timeout after %v, close connections", s.Addr, timeout)
+	}
+
+	s.Stop()
+}
+
 func serverHandler(s *Server, workersCh chan struct{}) {
 	defer s.stopWg.Done()
 
@@ -134,6 +188,14 @@ func serverHandler(s *Server, workersCh chan struct{}) {
 			s.Listener.Close()
 			<-acceptChan
 			return
+		case <-s.drainChan:
+			stopping.Store(true)
+			s.Listener.Close()
+			<-acceptChan
+			if err == nil {
+				conn.Close()
+			}
+			return
 		case <-acceptChan:
 			dlog.Debug("server handler [%s] connected.", clientAddr)
 		}
@@ -155,25 +217,62 @@ func serverHandler(s *Server, workersCh chan struct{}) {
 
 func serverHandleConnection(s *Server, conn io.ReadWriteCloser, clientAddr string, workersCh chan struct{}) {
 	defer s.stopWg.Done()
+	atomic.AddInt32(&s.conns, 1)
+	defer atomic.AddInt32(&s.conns, -1)
 
 	responsesChan := make(chan *serverMessage, s.PendingResponses)
 	stopChan := make(chan struct{})
+	flushChan := make(chan struct{})
 	readerDone := make(chan struct{})
 	writerDone := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sc := &ServerConn{
+		responsesChan: responsesChan,
+		stopChan:      stopChan,
+		drainChan:     s.drainChan,
+	}
 
-	go serverReader(s, conn, clientAddr, responsesChan, stopChan, readerDone, workersCh)
-	go serverWriter(s, conn, clientAddr, responsesChan, stopChan, writerDone)
+	go serverReader(ctx, s, sc, conn, clientAddr, responsesChan, stopChan, readerDone, workersCh)
+	go serverWriter(s, conn, clientAddr, responsesChan, stopChan, flushChan, writerDone)
 
 	select {
 	case <-readerDone:
+		cancel()
 		close(stopChan)
 		conn.Close()
 		<-writerDone
 	case <-writerDone:
+		cancel()
 		close(stopChan)
 		conn.Close()
 		<-readerDone
 	case <-s.serverStopChan:
+		cancel()
+		close(stopChan)
+		conn.Close()
+		<-readerDone
+		<-writerDone
+	case <-s.drainChan:
+		if s.GoAway != nil {
+			sc.Send(s.GoAway())
+		}
+
+		select {
+		case <-sc.idle():
+			// writer flushes responses and exits
+			close(flushChan)
+			select {
+			case <-writerDone:
+			case <-s.serverStopChan:
+			}
+		case <-readerDone:
+		case <-writerDone:
+		case <-s.serverStopChan:
+		}
+
+		cancel()
 		close(stopChan)
 		conn.Close()
 		<-readerDone
@@ -184,7 +283,7 @@ func serverHandleConnection(s *Server, conn io.ReadWriteCloser, clientAddr strin
 	dlog.Debug("serverHandle connection [%s] disconnected.", clientAddr)
 }
 
-func serverReader(s *Server, conn io.ReadWriteCloser, clientAddr string, responsesChan chan<- *serverMessage, stopChan <-chan struct{}, done chan<- struct{}, workersCh chan struct{}) {
+func serverReader(ctx context.Context, s *Server, sc *ServerConn, conn io.ReadWriteCloser, clientAddr string, responsesChan chan<- *serverMessage, stopChan <-chan struct{}, done chan<- struct{}, workersCh chan struct{}) {
 	defer func() {
 		if r := recover(); r != nil {
 			dlog.Error("server reader [%s]->[%s] dumpPanic when reading data from client: %v", clientAddr, s.Addr, r)
@@ -208,6 +307,15 @@ func serverReader(s *Server, conn io.ReadWriteCloser, clientAddr string, respons
 			return
 		}
 
+		if s.FrameHandler != nil && s.FrameHandler(ctx, sc, clientAddr, req) {
+			continue
+		}
+
+		if s.Reject != nil && sc.draining() {
+			sc.Send(s.Reject(req))
+			continue
+		}
+
 		m := serverMessagePool.Get().(*serverMessage)
 		m.Request = req
 		m.ClientAddr = clientAddr
@@ -221,18 +329,19 @@ func serverReader(s *Server, conn io.ReadWriteCloser, clientAddr string, respons
 				return
 			}
 		}
-		go serverRequest(s, responsesChan, stopChan, m, workersCh)
+		sc.acquire()
+		go serverRequest(ctx, s, sc, responsesChan, stopChan, m, workersCh)
 	}
 }
 
-func serverRequest(s *Server, responsesChan chan<- *serverMessage, stopChan <-chan struct{}, m *serverMessage, workersChan <-chan struct{}) {
+func serverRequest(ctx context.Context, s *Server, sc *ServerConn, responsesChan chan<- *serverMessage, stopChan <-chan struct{}, m *serverMessage, workersChan <-chan struct{}) {
 	req := m.Request
 	clientAddr := m.ClientAddr
 
 	m.Request = nil
 	m.ClientAddr = ""
 
-	rsp := callHandlerWithRecover(s.Handler, clientAddr, s.Addr, req)
+	rsp := callHandlerWithRecover(ctx, s.CtxHandler, clientAddr, s.Addr, req)
 	m.Response = rsp
 	select {
 	case responsesChan <- m:
@@ -242,10 +351,11 @@ func serverRequest(s *Server, responsesChan chan<- *serverMessage, stopChan <-ch
 		case <-stopChan:
 		}
 	}
+	sc.release()
 	<-workersChan
 }
 
-func callHandlerWithRecover(handler HandlerFunc, clientAddr string, serverAddr string, req Packet) (rsp Packet) {
+func callHandlerWithRecover(ctx context.Context, handler CtxHandlerFunc, clientAddr string, serverAddr string, req Packet) (rsp Packet) {
 	defer func() {
 		if x := recover(); x != nil {
 			rsp.SetErrCode(uint32(InternalServerError.Code()))
@@ -255,11 +365,12 @@ func callHandlerWithRecover(handler HandlerFunc, clientAddr string, serverAddr s
 			dlog.Error("callHandlerWithRecover [%s] -> [%s]. %s", clientAddr, serverAddr, errStr)
 		}
 	}()
-	rsp = handler(clientAddr, req)
+	rsp = handler(ctx, clientAddr, req)
 	return
 }
 
-func serverWriter(s *Server, conn io.ReadWriteCloser, clientAddr string, responsesChan <-chan *serverMessage, stopChan <-chan struct{}, done chan<- struct{}) {
+// serverWriter flushes and exits on flushChan if there is no response to send.
+func serverWriter(s *Server, conn io.ReadWriteCloser, clientAddr string, responsesChan <-chan *serverMessage, stopChan <-chan struct{}, flushChan <-chan struct{}, done chan<- struct{}) {
 	defer func() {
 		close(done)
 	}()
@@ -271,7 +382,7 @@ func serverWriter(s *Server, conn io.ReadWriteCloser, clientAddr string, respons
 		return
 	}
 
-	var flushChan <-chan time.Time
+	var delayChan <-chan time.Time
 	t := time.NewTimer(s.FlushDelay)
 
 	for {
@@ -285,19 +396,24 @@ func serverWriter(s *Server, conn io.ReadWriteCloser, clientAddr string, respons
 				return
 			case m = <-responsesChan:
 			case <-flushChan:
+				if err := enc.Flush(); err != nil {
+					dlog.Error("server writer [%s] -> [%s] cannot flush response: [%s]", clientAddr, s.Addr, err)
+				}
+				return
+			case <-delayChan:
 				if err := enc.Flush(); err != nil {
 					if !isServerStop(stopChan) {
 						err = fmt.Errorf("[%s] -> [%s] cannot flush response to underlying stream: [%s]", clientAddr, s.Addr, err)
 					}
 					return
 				}
-				flushChan = nil
+				delayChan = nil
 				continue
 			}
 		}
 
-		if flushChan == nil {
-			flushChan = getFlushChan(t, s.FlushDelay)
+		if delayChan == nil {
+			delayChan = getFlushChan(t, s.FlushDelay)
 		}
 
 		rsp := m.Response
@@ -325,6 +441,67 @@ func isServerStop(stopChan <-chan struct{}) bool {
 	}
 }
 
+// ServerConn is the client connection of server, which sends packets besides responses, such as stream frames.
+type ServerConn struct {
+	responsesChan chan<- *serverMessage
+	stopChan      <-chan struct{}
+	drainChan     <-chan struct{}
+	streams       map[uint32]*Stream
+	streamsLock   sync.Mutex
+
+	// pending requests and streams, waited by Shutdown
+	pendingLock sync.Mutex
+	pending     int
+	idleChan    chan struct{}
+}
+
+// draining reports whether server is shutting down, new requests and streams are rejected then.
+func (sc *ServerConn) draining() bool {
+	return isServerStop(sc.drainChan)
+}
+
+func (sc *ServerConn) acquire() {
+	sc.pendingLock.Lock()
+	sc.pending++
+	sc.pendingLock.Unlock()
+}
+
+func (sc *ServerConn) release() {
+	sc.pendingLock.Lock()
+	sc.pending--
+	if sc.pending == 0 && sc.idleChan != nil {
+		close(sc.idleChan)
+		sc.idleChan = nil
+	}
+	sc.pendingLock.Unlock()
+}
+
+// idle returns a chan closed when there is no pending request or stream.
+func (sc *ServerConn) idle() <-chan struct{} {
+	sc.pendingLock.Lock()
+	defer sc.pendingLock.Unlock()
+
+	ch := make(chan struct{})
+	if sc.pending == 0 {
+		close(ch)
+	} else {
+		sc.idleChan = ch
+	}
+	return ch
+}
+
+func (sc *ServerConn) Send(p Packet) error {
+	m := serverMessagePool.Get().(*serverMessage)
+	m.Response = p
+	select {
+	case sc.responsesChan <- m:
+		return nil
+	case <-sc.stopChan:
+		serverMessagePool.Put(m)
+		return StreamClosedError
+	}
+}
+
 type serverMessage struct {
 	Request    Packet
 	Response   Packet
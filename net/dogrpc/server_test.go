@@ -0,0 +1,127 @@
+/**
+ * Copyright 2018 gd Author. All Rights Reserved.
+ * Author: Chuck1024
+ */
+
+package dogrpc
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestServerShutdown(t *testing.T) {
+	s := NewDogRpcServer()
+	s.AddCtxHandler(1, func(ctx context.Context, req []byte) (uint32, []byte) {
+		time.Sleep(200 * time.Millisecond)
+		return 0, req
+	})
+	s.ss.Addr = "127.0.0.1:0"
+	if err := s.ss.Start(); err != nil {
+		t.Fatalf("server start occur error:%s", err)
+	}
+
+	c := &Client{
+		Addr:    s.ss.Listener.ListenAddr().String(),
+		Encoder: NewDogPacketEncoder,
+		Decoder: NewDogPacketDecoder,
+	}
+	c.Start()
+	defer c.Stop()
+
+	type result struct {
+		rsp Packet
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		rsp, err := c.Call(NewDogPacket(1, []byte("hello")))
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		done <- result{rsp: rsp}
+	}()
+
+	// shutdown while request is being handled
+	time.Sleep(50 * time.Millisecond)
+	st := time.Now()
+	s.ss.Shutdown(3 * time.Second)
+	if cost := time.Since(st); cost >= 3*time.Second {
+		t.Fatalf("shutdown waits until timeout, cost %v", cost)
+	}
+
+	r := <-done
+	if r.err != nil {
+		t.Fatalf("pending request occur error:%s", r.err)
+	}
+	if p := r.rsp.(*DogPacket); p.ErrCode != 0 || string(p.Body) != "hello" {
+		t.Fatalf("unexpected response code %d body %s", p.ErrCode, p.Body)
+	}
+}
+
+func TestServerHandler(t *testing.T) {
+	s := &Server{
+		Addr: "127.0.0.1:0",
+		Handler: func(clientAddr string, req Packet) Packet {
+			p := req.(*RpcPacket)
+			return NewRpcPacketWithRet(p.Cmd, p.Body, p.Seq, 0)
+		},
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("server start occur error:%s", err)
+	}
+	defer s.Stop()
+
+	c := &Client{Addr: s.Listener.ListenAddr().String()}
+	c.Start()
+	defer c.Stop()
+
+	rsp, err := c.Call(NewRpcPacket(1, []byte("hello")))
+	if err != nil {
+		t.Fatalf("call occur error:%s", err)
+	}
+	if p := rsp.(*RpcPacket); p.ErrCode != 0 || string(p.Body) != "hello" {
+		t.Fatalf("unexpected response code %d body %s", p.ErrCode, p.Body)
+	}
+}
+
+func TestServerShutdownReject(t *testing.T) {
+	s := NewRpcServer()
+	s.AddHandler(1, func(req []byte) (uint32, []byte) {
+		time.Sleep(200 * time.Millisecond)
+		return 0, req
+	})
+	s.ss.Addr = "127.0.0.1:0"
+	if err := s.ss.Start(); err != nil {
+		t.Fatalf("server start occur error:%s", err)
+	}
+
+	c := &Client{Addr: s.ss.Listener.ListenAddr().String()}
+	c.Start()
+	defer c.Stop()
+
+	done := make(chan Packet, 1)
+	go func() {
+		rsp, _ := c.Call(NewRpcPacket(1, []byte("hello")))
+		done <- rsp
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	go s.ss.Shutdown(3 * time.Second)
+	time.Sleep(50 * time.Millisecond)
+
+	// RpcPacket has no GoAway, request on the draining connection is rejected
+	rsp, err := c.Call(NewRpcPacket(1, []byte("again")))
+	if err != nil {
+		t.Fatalf("call occur error:%s", err)
+	}
+	if p := rsp.(*RpcPacket); p.ErrCode != uint32(ShutdownError.Code()) {
+		t.Fatalf("unexpected response code %d", p.ErrCode)
+	}
+
+	if p, ok := (<-done).(*RpcPacket); !ok || p.ErrCode != 0 || string(p.Body) != "hello" {
+		t.Fatalf("unexpected pending response %+v", p)
+	}
+}
@@ -0,0 +1,500 @@
+/**
+ * Copyright 2018 gd Author. All Rights Reserved.
+ * Author: Chuck1024
+ */
+
+package dogrpc
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	dogError "github.com/gdp-org/gd/derror"
+	"github.com/gdp-org/gd/dlog"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+ * dog stream. It is multiplexed over the connection of dog packet, all frames of a stream
+ * share the seq of StreamOpen frame. Both sides start with DefaultStreamWindow messages of
+ * send window, and grant more by StreamWindow frame after messages are received.
+ */
+
+const (
+	StreamOpen   uint8 = 1 // client opens a stream of cmd
+	StreamData   uint8 = 2 // one message
+	StreamEnd    uint8 = 3 // client half closes, or server ends stream with ErrCode
+	StreamReset  uint8 = 4 // either side cancels stream
+	StreamWindow uint8 = 5 // body is uint32 of messages granted to peer
+)
+
+// StreamHandlerFunc handles a stream opened by client. stream is ended with code when it returns.
+type StreamHandlerFunc func(ctx context.Context, stream *Stream) (code uint32)
+
+type Stream struct {
+	id     uint32
+	cmd    uint32
+	codec  uint8
+	client bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// client stream sends frames by the connection it is bound to, server stream by send.
+	sendChan chan<- *AsyncResult
+	send     func(p Packet) error
+	bound    chan struct{}
+
+	window     int32
+	windowChan chan struct{}
+	consumed   int32
+	sendClosed int32
+
+	// recvChan, recvErr and recvClosed are written by connection reader only.
+	recvChan   chan *DogPacket
+	recvErr    error
+	recvClosed bool
+
+	lock     sync.Mutex
+	finished bool
+	done     chan struct{}
+	err      error
+	onDone   func()
+}
+
+func newStream(ctx context.Context, id uint32, cmd uint32, codec uint8, client bool) *Stream {
+	s := &Stream{
+		id:         id,
+		cmd:        cmd,
+		codec:      codec,
+		client:     client,
+		bound:      make(chan struct{}),
+		window:     DefaultStreamWindow,
+		windowChan: make(chan struct{}, 1),
+		recvChan:   make(chan *DogPacket, DefaultStreamWindow),
+		done:       make(chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	go s.watch()
+	return s
+}
+
+func (s *Stream) ID() uint32 {
+	return s.id
+}
+
+func (s *Stream) Context() context.Context {
+	return s.ctx
+}
+
+// Send sends a message, it blocks when the send window granted by peer is used up.
+func (s *Stream) Send(body []byte) error {
+	if atomic.LoadInt32(&s.sendClosed) != 0 {
+		return StreamClosedError
+	}
+
+	for {
+		n := atomic.LoadInt32(&s.window)
+		if n > 0 {
+			if atomic.CompareAndSwapInt32(&s.window, n, n-1) {
+				break
+			}
+			continue
+		}
+
+		select {
+		case <-s.windowChan:
+		case <-s.done:
+			return s.err
+		}
+	}
+
+	return s.sendFrame(StreamData, body, 0)
+}
+
+// SendMsg marshals v by the codec of stream and sends it.
+func (s *Stream) SendMsg(v interface{}) error {
+	body, err := s.getCodec().Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.Send(body)
+}
+
+// Recv receives a message. it returns io.EOF when peer closes send normally,
+// a CodeError when server ends the stream with non zero code.
+func (s *Stream) Recv() ([]byte, error) {
+	select {
+	case p, ok := <-s.recvChan:
+		return s.recv(p, ok)
+	default:
+	}
+
+	select {
+	case p, ok := <-s.recvChan:
+		return s.recv(p, ok)
+	case <-s.done:
+		// prefer messages received before stream is done.
+		select {
+		case p, ok := <-s.recvChan:
+			return s.recv(p, ok)
+		default:
+		}
+		return nil, s.err
+	}
+}
+
+// RecvMsg receives a message and unmarshals it to v by the codec of stream.
+func (s *Stream) RecvMsg(v interface{}) error {
+	body, err := s.Recv()
+	if err != nil {
+		return err
+	}
+	return s.getCodec().Unmarshal(body, v)
+}
+
+// CloseSend tells server no more message will be sent. server stream is ended when handler returns.
+func (s *Stream) CloseSend() error {
+	if !atomic.CompareAndSwapInt32(&s.sendClosed, 0, 1) {
+		return nil
+	}
+	return s.sendFrame(StreamEnd, nil, 0)
+}
+
+// Cancel resets the stream, peer gets StreamResetError.
+func (s *Stream) Cancel() {
+	s.cancel()
+}
+
+func (s *Stream) getCodec() Codec {
+	if cd := GetCodec(s.codec); cd != nil {
+		return cd
+	}
+	return jsonCodec{}
+}
+
+func (s *Stream) recv(p *DogPacket, ok bool) ([]byte, error) {
+	if !ok {
+		return nil, s.recvErr
+	}
+
+	if atomic.AddInt32(&s.consumed, 1) >= DefaultStreamWindow/2 {
+		n := atomic.SwapInt32(&s.consumed, 0)
+		body := make([]byte, 4)
+		binary.BigEndian.PutUint32(body, uint32(n))
+		s.sendFrame(StreamWindow, body, 0)
+	}
+
+	return p.Body, nil
+}
+
+func (s *Stream) watch() {
+	<-s.ctx.Done()
+	select {
+	case <-s.done:
+	default:
+		// canceled or deadline exceeded by caller
+		s.reset(s.ctx.Err())
+	}
+}
+
+// bind binds client stream to the connection which sends StreamOpen frame.
+// it returns false if stream is finished before.
+func (s *Stream) bind(sendChan chan<- *AsyncResult) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.finished {
+		return false
+	}
+	s.sendChan = sendChan
+	close(s.bound)
+	return true
+}
+
+func (s *Stream) newFrame(flag uint8, body []byte, code uint32) *DogPacket {
+	p := NewDogPacketWithRet(s.cmd, body, s.id, code)
+	p.Padding = s.codec
+	p.Stream = flag
+	return p
+}
+
+func (s *Stream) sendFrame(flag uint8, body []byte, code uint32) error {
+	p := s.newFrame(flag, body, code)
+	if !s.client {
+		select {
+		case <-s.done:
+			return s.err
+		default:
+		}
+		return s.send(p)
+	}
+
+	select {
+	case <-s.bound:
+	case <-s.done:
+		return s.err
+	}
+
+	m := acquireAsyncResult()
+	m.Request = p
+	m.skipResponse = true
+	select {
+	case s.sendChan <- m:
+		return nil
+	case <-s.done:
+		releaseAsyncResult(m)
+		return s.err
+	}
+}
+
+// reset finishes stream and tells peer if the stream is opened.
+func (s *Stream) reset(err error) {
+	s.lock.Lock()
+	opened := !s.client || s.sendChan != nil
+	s.lock.Unlock()
+
+	if !s.finish(err) || !opened {
+		return
+	}
+
+	p := s.newFrame(StreamReset, nil, 0)
+	if !s.client {
+		s.send(p)
+		return
+	}
+
+	m := acquireAsyncResult()
+	m.Request = p
+	m.skipResponse = true
+	select {
+	case s.sendChan <- m:
+	default:
+		releaseAsyncResult(m)
+	}
+}
+
+// finish marks stream done with err, it returns false if stream is already finished.
+func (s *Stream) finish(err error) bool {
+	s.lock.Lock()
+	if s.finished {
+		s.lock.Unlock()
+		return false
+	}
+	s.finished = true
+	s.err = err
+	close(s.done)
+	s.lock.Unlock()
+
+	s.cancel()
+	if s.onDone != nil {
+		s.onDone()
+	}
+	return true
+}
+
+// handleFrame is called by connection reader in order.
+func (s *Stream) handleFrame(p *DogPacket) {
+	switch p.Stream {
+	case StreamData:
+		if s.recvClosed {
+			return
+		}
+		select {
+		case s.recvChan <- p:
+		default:
+			dlog.Error("stream %d cmd %d peer exceeds recv window", s.id, s.cmd)
+			s.reset(OverflowError)
+		}
+	case StreamEnd:
+		if !s.recvClosed {
+			s.recvErr = io.EOF
+			if s.client && p.ErrCode != uint32(dogError.RpcSuccess) {
+				s.recvErr = dogError.NewCodeError(int(p.ErrCode), "stream ended with code %d", p.ErrCode)
+			}
+			s.recvClosed = true
+			close(s.recvChan)
+		}
+		if s.client {
+			s.finish(s.recvErr)
+		}
+	case StreamReset:
+		s.finish(StreamResetError)
+	case StreamWindow:
+		if len(p.Body) >= 4 {
+			atomic.AddInt32(&s.window, int32(binary.BigEndian.Uint32(p.Body)))
+			select {
+			case s.windowChan <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+/*
+ * client stream
+ */
+
+// OpenStream opens a stream of cmd on the connection which sends StreamOpen frame.
+// ctx carries deadline, trace id and metadata to server, stream is reset when ctx is done.
+func (c *Client) OpenStream(ctx context.Context, cmd uint32, codec uint8) (*Stream, *dogError.CodeError) {
+	st := newStream(ctx, nextDogSeq(), cmd, codec, true)
+	c.streams.Store(st.id, st)
+	st.onDone = func() {
+		c.streams.Delete(st.id)
+	}
+
+	p := st.newFrame(StreamOpen, nil, 0)
+	setDogPacketContext(p, ctx)
+	if err := p.sign(); err != nil {
+		dlog.Error("OpenStream sign occur error:%s", err)
+		st.finish(err)
+		return nil, InternalServerError
+	}
+
+	m := acquireAsyncResult()
+	m.Request = p
+	m.skipResponse = true
+	m.stream = st
+	select {
+	case c.requestsChan <- m:
+		return st, nil
+	case <-ctx.Done():
+		releaseAsyncResult(m)
+		st.finish(ctx.Err())
+		return nil, TimeOutError.SetMsg(fmt.Sprintf("[%s]. Cannot open stream: %s", c.Addr, ctx.Err()))
+	}
+}
+
+// OpenStream opens a dog packet stream of cmd, see Client.OpenStream.
+func (c *RpcClient) OpenStream(ctx context.Context, cmd uint32) (*Stream, *dogError.CodeError) {
+	cc, err := c.dogConnectKey(balanceKey(ctx))
+	if err != nil {
+		dlog.Error("OpenStream connect occur error:%s", err)
+		return nil, InternalServerError
+	}
+
+	// client interceptors are called when stream is opened, such as credentials.
+	var st *Stream
+	_, _, sErr := c.invoke(ctx, cmd, 0, nil, func(ctx *Context) (code uint32, rsp []byte, err *dogError.CodeError) {
+		st, err = cc.OpenStream(ctx.Ctx, cmd, c.Codec)
+		return
+	})
+	return st, sErr
+}
+
+/*
+ * server stream
+ */
+
+func (s *RpcServer) AddStreamHandler(headCmd uint32, f StreamHandlerFunc) {
+	if s.streamHandler == nil {
+		s.streamHandler = make(map[uint32]StreamHandlerFunc)
+	}
+
+	if _, ok := s.streamHandler[headCmd]; ok {
+		dlog.Warn("AddStreamHandler head cmd [%d] already registered.", headCmd)
+		return
+	}
+
+	s.streamHandler[headCmd] = f
+	dlog.Info("AddStreamHandler register head cmd [%d] success.", headCmd)
+}
+
+func (s *RpcServer) dogStreamFrame(ctx context.Context, conn *ServerConn, clientAddr string, req Packet) bool {
+	packet, ok := req.(*DogPacket)
+	if !ok || packet.Stream == 0 {
+		return false
+	}
+
+	if packet.Stream != StreamOpen {
+		conn.streamsLock.Lock()
+		st := conn.streams[packet.Seq]
+		conn.streamsLock.Unlock()
+		if st != nil {
+			st.handleFrame(packet)
+		}
+		return true
+	}
+
+	end := func(code uint32) {
+		rsp := newDogRspPacket(packet, nil, code)
+		rsp.Stream = StreamEnd
+		conn.Send(rsp)
+	}
+
+	if conn.draining() {
+		end(uint32(ShutdownError.Code()))
+		return true
+	}
+
+	f, ok := s.streamHandler[packet.Cmd]
+	if !ok {
+		dlog.Error("dogStreamFrame head cmd %d not register stream handler!", packet.Cmd)
+		end(uint32(InvalidParam.Code()))
+		return true
+	}
+
+	ctx, cancel, err := dogPacketContext(ctx, packet)
+	if err != nil {
+		dlog.Warn("dogStreamFrame head cmd %d seq %d occur error:%s", packet.Cmd, packet.Seq, err.Error())
+		end(uint32(err.Code()))
+		return true
+	}
+	if ctx, err = s.authenticate(ctx, clientAddr, packet.Cmd, packet.Body); err != nil {
+		cancel()
+		end(uint32(err.Code()))
+		return true
+	}
+
+	// stream holds limiter of cmd until it is done
+	release, ok := s.acquire(packet.Cmd)
+	if !ok {
+		cancel()
+		end(uint32(OverflowError.Code()))
+		return true
+	}
+	ctx = requestContext(ctx, clientAddr)
+
+	conn.acquire()
+	st := newStream(ctx, packet.Seq, packet.Cmd, packet.Padding, false)
+	st.send = conn.Send
+	st.onDone = func() {
+		cancel()
+		release()
+		conn.release()
+		conn.streamsLock.Lock()
+		delete(conn.streams, st.id)
+		conn.streamsLock.Unlock()
+	}
+
+	conn.streamsLock.Lock()
+	if conn.streams == nil {
+		conn.streams = make(map[uint32]*Stream)
+	}
+	conn.streams[st.id] = st
+	conn.streamsLock.Unlock()
+
+	go serveStream(f, st)
+	return true
+}
+
+func serveStream(f StreamHandlerFunc, st *Stream) {
+	code := uint32(InternalServerError.Code())
+	defer func() {
+		if x := recover(); x != nil {
+			stackTrace := make([]byte, 1<<20)
+			n := runtime.Stack(stackTrace, false)
+			dlog.Error("serveStream cmd %d panic occured: %v\n Stack trace: %s", st.cmd, x, stackTrace[:n])
+		}
+
+		if atomic.CompareAndSwapInt32(&st.sendClosed, 0, 1) {
+			st.sendFrame(StreamEnd, nil, code)
+		}
+		st.finish(io.EOF)
+	}()
+
+	code = f(st.ctx, st)
+}
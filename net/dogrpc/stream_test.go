@@ -0,0 +1,131 @@
+/**
+ * Copyright 2018 gd Author. All Rights Reserved.
+ * Author: Chuck1024
+ */
+
+package dogrpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+func startStreamTestServer(t *testing.T, cmd uint32, f StreamHandlerFunc) (*RpcServer, *Client) {
+	s := NewDogRpcServer()
+	s.AddStreamHandler(cmd, f)
+	s.ss.Addr = "127.0.0.1:0"
+	if err := s.ss.Start(); err != nil {
+		t.Fatalf("server start occur error:%s", err)
+	}
+
+	c := &Client{
+		Addr:    s.ss.Listener.ListenAddr().String(),
+		Encoder: NewDogPacketEncoder,
+		Decoder: NewDogPacketDecoder,
+	}
+	c.Start()
+	return s, c
+}
+
+func TestStreamEcho(t *testing.T) {
+	s, c := startStreamTestServer(t, 1, func(ctx context.Context, stream *Stream) uint32 {
+		for {
+			body, err := stream.Recv()
+			if err == io.EOF {
+				return 0
+			}
+			if err != nil {
+				return uint32(InternalServerError.Code())
+			}
+			if err := stream.Send(body); err != nil {
+				return uint32(InternalServerError.Code())
+			}
+		}
+	})
+	defer s.ss.Stop()
+	defer c.Stop()
+
+	st, err := c.OpenStream(context.Background(), 1, CodecJson)
+	if err != nil {
+		t.Fatalf("open stream occur error:%s", err)
+	}
+
+	// more messages than window to check flow control
+	n := DefaultStreamWindow * 4
+	go func() {
+		for i := 0; i < n; i++ {
+			if err := st.Send([]byte(fmt.Sprintf("%d", i))); err != nil {
+				t.Errorf("send occur error:%s", err)
+				return
+			}
+		}
+		st.CloseSend()
+	}()
+
+	for i := 0; i < n; i++ {
+		body, err := st.Recv()
+		if err != nil {
+			t.Fatalf("recv %d occur error:%s", i, err)
+		}
+		if string(body) != fmt.Sprintf("%d", i) {
+			t.Fatalf("unexpected body %s, expect %d", body, i)
+		}
+	}
+
+	if _, err := st.Recv(); err != io.EOF {
+		t.Fatalf("expect io.EOF, got %v", err)
+	}
+}
+
+func TestStreamServerCode(t *testing.T) {
+	s, c := startStreamTestServer(t, 1, func(ctx context.Context, stream *Stream) uint32 {
+		stream.SendMsg(map[string]string{"Data": "hello"})
+		return uint32(InvalidParam.Code())
+	})
+	defer s.ss.Stop()
+	defer c.Stop()
+
+	st, err := c.OpenStream(context.Background(), 1, CodecMsgpack)
+	if err != nil {
+		t.Fatalf("open stream occur error:%s", err)
+	}
+
+	ret := make(map[string]string)
+	if err := st.RecvMsg(&ret); err != nil || ret["Data"] != "hello" {
+		t.Fatalf("unexpected ret %v, err %v", ret, err)
+	}
+
+	_, rErr := st.Recv()
+	if ce, ok := rErr.(interface{ Code() int }); !ok || ce.Code() != InvalidParam.Code() {
+		t.Fatalf("expect code %d, got %v", InvalidParam.Code(), rErr)
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	canceled := make(chan struct{})
+	s, c := startStreamTestServer(t, 1, func(ctx context.Context, stream *Stream) uint32 {
+		<-ctx.Done()
+		close(canceled)
+		return 0
+	})
+	defer s.ss.Stop()
+	defer c.Stop()
+
+	st, err := c.OpenStream(context.Background(), 1, CodecJson)
+	if err != nil {
+		t.Fatalf("open stream occur error:%s", err)
+	}
+	if err := st.Send([]byte("hello")); err != nil {
+		t.Fatalf("send occur error:%s", err)
+	}
+	st.Cancel()
+
+	select {
+	case <-canceled:
+	case <-time.After(3 * time.Second):
+		t.Fatal("server stream is not canceled")
+	}
+}
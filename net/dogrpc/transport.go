@@ -6,13 +6,35 @@
 package dogrpc
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"net"
+	"os"
+	"sync"
 	"time"
 )
 
+/*
+ * transports of dogrpc. NetworkTcp is the default, NetworkUnix listens on unix domain socket for
+ * sidecar deployments, whose addr is the socket path. NetworkPipe is an in-process transport by
+ * net.Pipe, whose addr is any name, so that server and client in the same process talk without
+ * opening ports, e.g. in tests.
+ */
+
 const (
 	DefaultDialNetWork = "tcp"
+
+	NetworkTcp  = "tcp"
+	NetworkUnix = "unix"
+	NetworkPipe = "pipe"
+)
+
+var (
+	errPipeClosed = errors.New("pipe listener closed")
+
+	pipeListeners     = make(map[string]*pipeListener)
+	pipeListenersLock sync.Mutex
 )
 
 var (
@@ -35,6 +57,44 @@ func defaultDial(addr string) (conn io.ReadWriteCloser, err error) {
 	return dialer.Dial(DefaultDialNetWork, addr)
 }
 
+// NewListener returns listener of network, "" is NetworkTcp.
+func NewListener(network string) (Listener, error) {
+	switch network {
+	case "", NetworkTcp:
+		return &defaultListener{}, nil
+	case NetworkUnix:
+		return &netListener{F: listenUnix}, nil
+	case NetworkPipe:
+		return &pipeListener{}, nil
+	}
+	return nil, fmt.Errorf("unknown network %s", network)
+}
+
+// NewDial returns dial func of network, "" is NetworkTcp.
+func NewDial(network string) (DialFunc, error) {
+	switch network {
+	case "", NetworkTcp:
+		return defaultDial, nil
+	case NetworkUnix:
+		return func(addr string) (io.ReadWriteCloser, error) {
+			return dialer.Dial(NetworkUnix, addr)
+		}, nil
+	case NetworkPipe:
+		return dialPipe, nil
+	}
+	return nil, fmt.Errorf("unknown network %s", network)
+}
+
+// listenUnix listens on socket path addr, the socket file left by last process is removed.
+func listenUnix(addr string) (net.Listener, error) {
+	if fi, err := os.Stat(addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(addr); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen(NetworkUnix, addr)
+}
+
 type defaultListener struct {
 	L net.Listener
 }
@@ -105,4 +165,86 @@ func (ln *netListener) Accept() (conn io.ReadWriteCloser, clientAddr string, err
 
 func (ln *netListener) Close() error {
 	return ln.L.Close()
-}
\ No newline at end of file
+}
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string {
+	return NetworkPipe
+}
+
+func (a pipeAddr) String() string {
+	return string(a)
+}
+
+type pipeListener struct {
+	addr      pipeAddr
+	conns     chan net.Conn
+	closeChan chan struct{}
+	closeOnce sync.Once
+}
+
+func (ln *pipeListener) Init(addr string) error {
+	pipeListenersLock.Lock()
+	defer pipeListenersLock.Unlock()
+
+	if _, ok := pipeListeners[addr]; ok {
+		return fmt.Errorf("pipe %s already in use", addr)
+	}
+
+	ln.addr = pipeAddr(addr)
+	ln.conns = make(chan net.Conn)
+	ln.closeChan = make(chan struct{})
+	pipeListeners[addr] = ln
+	return nil
+}
+
+func (ln *pipeListener) ListenAddr() net.Addr {
+	if ln.conns != nil {
+		return ln.addr
+	}
+	return nil
+}
+
+func (ln *pipeListener) Accept() (conn io.ReadWriteCloser, clientAddr string, err error) {
+	select {
+	case c := <-ln.conns:
+		return c, c.RemoteAddr().String(), nil
+	case <-ln.closeChan:
+		return nil, "", errPipeClosed
+	}
+}
+
+func (ln *pipeListener) Close() error {
+	ln.closeOnce.Do(func() {
+		pipeListenersLock.Lock()
+		if pipeListeners[string(ln.addr)] == ln {
+			delete(pipeListeners, string(ln.addr))
+		}
+		pipeListenersLock.Unlock()
+		close(ln.closeChan)
+	})
+	return nil
+}
+
+func dialPipe(addr string) (conn io.ReadWriteCloser, err error) {
+	pipeListenersLock.Lock()
+	ln := pipeListeners[addr]
+	pipeListenersLock.Unlock()
+	if ln == nil {
+		return nil, fmt.Errorf("pipe %s is not listened", addr)
+	}
+
+	server, client := net.Pipe()
+	t := time.NewTimer(dialer.Timeout)
+	defer t.Stop()
+	select {
+	case ln.conns <- server:
+		return client, nil
+	case <-ln.closeChan:
+	case <-t.C:
+	}
+	server.Close()
+	client.Close()
+	return nil, fmt.Errorf("pipe %s cannot be connected", addr)
+}
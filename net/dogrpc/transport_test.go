@@ -0,0 +1,70 @@
+/**
+ * Copyright 2018 gd Author. All Rights Reserved.
+ * Author: Chuck1024
+ */
+
+package dogrpc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testTransport(t *testing.T, network, addr string) {
+	s := NewDogRpcServer()
+	s.Network = network
+	s.SockAddr = addr
+	s.AddHandler(1024, func(req []byte) (uint32, []byte) {
+		return 0, req
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("server start occur error:%s", err)
+	}
+	defer s.Close()
+
+	// server is started in background, wait until it is listened
+	dial, _ := NewDial(network)
+	for i := 0; ; i++ {
+		conn, err := dial(addr)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if i == 100 {
+			t.Fatalf("dial %s occur error:%s", addr, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	c := NewClient(time.Second, 0, false, nil, "", "", "").SetNetwork(network).AddAddr(addr)
+	defer c.Stop()
+
+	code, rsp, err := c.DogInvoke(1024, "hello")
+	if err != nil {
+		t.Fatalf("invoke occur error:%s", err)
+	}
+	var body string
+	if dErr := GetCodec(CodecJson).Unmarshal(rsp, &body); dErr != nil || code != 0 || body != "hello" {
+		t.Fatalf("unexpected response code %d body %s", code, rsp)
+	}
+}
+
+func TestPipeTransport(t *testing.T) {
+	testTransport(t, NetworkPipe, "test")
+
+	if _, err := dialPipe("test"); err == nil {
+		t.Fatal("expect error of dialing closed pipe")
+	}
+}
+
+func TestUnixTransport(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dogrpc")
+	if err != nil {
+		t.Fatalf("make temp dir occur error:%s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	testTransport(t, NetworkUnix, filepath.Join(dir, "rpc.sock"))
+}
@@ -0,0 +1,71 @@
+/**
+ * Copyright 2019 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package dogrpc
+
+import (
+	"context"
+	"fmt"
+	"github.com/gdp-org/gd/dlog"
+	"github.com/gdp-org/gd/utls/validate"
+	"reflect"
+)
+
+/*
+ * typed handlers are checked by compiler and called without reflection, they decode, validate
+ * and return by codec of request as handlers of AddDogHandler.
+ *
+ * e.g.
+ *
+ * func getUser(ctx context.Context, req *GetUserReq) (uint32, string, error, *User)
+ *
+ * dogrpc.AddTypedHandler(s, 1024, getUser)
+ */
+
+// DogHandlerFunc is typed handler, req is nil if request body is empty.
+type DogHandlerFunc[In, Out any] func(ctx context.Context, req *In) (code uint32, message string, err error, ret Out)
+
+// Handle converts typed handler to RpcCtxHandlerFunc, it panics if validate tags of In are illegal.
+func Handle[In, Out any](f DogHandlerFunc[In, Out]) RpcCtxHandlerFunc {
+	if err := validate.Check(reflect.TypeOf((*In)(nil))); err != nil {
+		panic(fmt.Sprintf("typed handler validate tag illegal %v: %s", f, err))
+	}
+
+	return func(ctx context.Context, req []byte) (uint32, []byte) {
+		cd := GetCodec(CodecFromContext(ctx))
+		if cd == nil {
+			cd = jsonCodec{}
+		}
+
+		var in *In
+		if len(req) > 0 {
+			in = new(In)
+			if code, resp, ok := decodeReq(cd, req, in, f); !ok {
+				return code, resp
+			}
+		}
+
+		if code, resp, ok := validReq(cd, in, f); !ok {
+			return code, resp
+		}
+
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		code, message, err, ret := f(ctx, in)
+		return code, returnCodec(cd, code, message, err, ret)
+	}
+}
+
+// AddTypedHandler adds typed handler of headCmd, it returns error if validate tags of In are illegal.
+func AddTypedHandler[In, Out any](s *RpcServer, headCmd uint32, f DogHandlerFunc[In, Out]) error {
+	if err := validate.Check(reflect.TypeOf((*In)(nil))); err != nil {
+		dlog.Error("AddTypedHandler head cmd [%d] validate tag illegal:%s", headCmd, err)
+		return err
+	}
+
+	s.AddCtxHandler(headCmd, Handle(f))
+	return nil
+}
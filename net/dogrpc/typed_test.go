@@ -0,0 +1,74 @@
+/**
+ * Copyright 2019 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package dogrpc
+
+import (
+	"context"
+	"encoding/json"
+	de "github.com/gdp-org/gd/derror"
+	"testing"
+)
+
+type typedTestReq struct {
+	Name string `json:"name" validate:"required"`
+}
+
+func typedTestHandler(ctx context.Context, req *typedTestReq) (uint32, string, error, *wrapTestResp) {
+	return uint32(de.RpcSuccess), "ok", nil, &wrapTestResp{Ret: req.Name + ":" + TraceId(ctx)}
+}
+
+func TestTypedHandler(t *testing.T) {
+	f := Handle(typedTestHandler)
+
+	code, rsp := f(WithTraceId(context.Background(), "trace"), []byte(`{"name":"hello"}`))
+	if code != uint32(de.RpcSuccess) {
+		t.Fatalf("unexpected code %d", code)
+	}
+	ret := struct {
+		Result wrapTestResp `json:"result"`
+	}{}
+	if err := json.Unmarshal(rsp, &ret); err != nil {
+		t.Fatalf("unmarshal rsp occur error:%s", err)
+	}
+	if ret.Result.Ret != "hello:trace" {
+		t.Fatalf("unexpected ret %s", ret.Result.Ret)
+	}
+
+	if code, _ := f(context.Background(), nil); code != uint32(de.ParameterError) {
+		t.Fatalf("unexpected code %d of empty req", code)
+	}
+	if code, _ := f(context.Background(), []byte(`{"name":1}`)); code != uint32(de.RpcInternalServerError) {
+		t.Fatalf("unexpected code %d of invalid req", code)
+	}
+}
+
+func TestAddTypedHandler(t *testing.T) {
+	s := NewDogRpcServer()
+	if err := AddTypedHandler(s, 1, typedTestHandler); err != nil {
+		t.Fatalf("add typed handler occur error:%s", err)
+	}
+	if _, ok := s.defaultHandler[1]; !ok {
+		t.Fatal("typed handler not registered")
+	}
+
+	type illegalReq struct {
+		Name string `validate:"max"`
+	}
+	if err := AddTypedHandler(s, 2, func(ctx context.Context, req *illegalReq) (uint32, string, error, string) {
+		return 0, "", nil, ""
+	}); err == nil {
+		t.Fatal("expect error when validate tag is illegal")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expect panic of Handle when validate tag is illegal")
+		}
+	}()
+	Handle(func(ctx context.Context, req *illegalReq) (uint32, string, error, string) {
+		return 0, "", nil, ""
+	})
+}
@@ -6,16 +6,23 @@
 package dogrpc
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	de "github.com/gdp-org/gd/derror"
 	"github.com/gdp-org/gd/dlog"
+	"github.com/gdp-org/gd/utls/validate"
 	"reflect"
 )
 
-var errInterface = reflect.TypeOf((*error)(nil)).Elem()
+var (
+	errInterface = reflect.TypeOf((*error)(nil)).Elem()
+	ctxInterface = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
 
-func wrap(toWrap interface{}) (RpcHandlerFunc, error) {
+// wrap supports func(req *T) (code uint32, message string, err error, ret *R)
+// and func(ctx context.Context, req *T) (code uint32, message string, err error, ret *R)
+// req is validated by validate tags of T before handler, ParameterError with field errors is returned if not valid.
+func wrap(toWrap interface{}) (RpcCtxHandlerFunc, error) {
 	refToWrap := reflect.ValueOf(toWrap)
 	wt := reflect.TypeOf(toWrap)
 	if wt.Kind() != reflect.Func {
@@ -25,7 +32,15 @@ func wrap(toWrap interface{}) (RpcHandlerFunc, error) {
 	if wtNumIn < 1 {
 		return nil, fmt.Errorf("params in count must > 1 %v", toWrap)
 	}
-	inType := wt.In(0)
+	withCtx := wt.In(0) == ctxInterface
+	reqIdx := 0
+	if withCtx {
+		if wtNumIn < 2 {
+			return nil, fmt.Errorf("params in count must > 2 when first param is context %v", toWrap)
+		}
+		reqIdx = 1
+	}
+	inType := wt.In(reqIdx)
 	if wt.NumOut() < 4 {
 		return nil, fmt.Errorf("params out count must > 4 %v", toWrap)
 	}
@@ -41,8 +56,11 @@ func wrap(toWrap interface{}) (RpcHandlerFunc, error) {
 	if !wt.Out(2).Implements(errInterface) {
 		return nil, fmt.Errorf("params out 4 must be derror %v", toWrap)
 	}
+	if err := validate.Check(inType); err != nil {
+		return nil, fmt.Errorf("params in validate tag illegal %v: %s", toWrap, err)
+	}
 
-	wrapped := func(req []byte) (code uint32, resp []byte) {
+	wrapped := func(ctx context.Context, req []byte) (code uint32, resp []byte) {
 		var inVal reflect.Value
 		if inType.Kind() == reflect.Ptr {
 			ite := inType.Elem()
@@ -52,11 +70,14 @@ func wrap(toWrap interface{}) (RpcHandlerFunc, error) {
 		}
 		inValInterface := inVal.Interface()
 
+		cd := GetCodec(CodecFromContext(ctx))
+		if cd == nil {
+			cd = jsonCodec{}
+		}
+
+		var ok bool
 		if len(req) > 0 {
-			jsonErr := json.Unmarshal(req, inValInterface)
-			if jsonErr != nil {
-				dlog.Info("wrap wrap data from json fail!bts=%s,func=%v,err=%v", string(req), toWrap, jsonErr)
-				Return(uint32(de.RpcInternalServerError), "data type not valid", jsonErr, nil)
+			if code, resp, ok = decodeReq(cd, req, inValInterface, toWrap); !ok {
 				return
 			}
 		} else {
@@ -66,13 +87,23 @@ func wrap(toWrap interface{}) (RpcHandlerFunc, error) {
 			}
 		}
 
+		if code, resp, ok = validReq(cd, inValInterface, toWrap); !ok {
+			return
+		}
+
 		in := make([]reflect.Value, wtNumIn)
-		in[0] = inVal
+		if withCtx {
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			in[0] = reflect.ValueOf(ctx)
+		}
+		in[reqIdx] = inVal
 		out := refToWrap.Call(in)
 		if len(out) != 4 {
 			dlog.Error("wrap return not 4!in=%v,out=%v,func=%v", in, out, toWrap)
 			code = uint32(de.RpcInternalServerError)
-			resp = Return(uint32(de.RpcInternalServerError), "ret not 4!", nil, nil)
+			resp = returnCodec(cd, uint32(de.RpcInternalServerError), "ret not 4!", nil, nil)
 			return
 		}
 
@@ -107,23 +138,61 @@ func wrap(toWrap interface{}) (RpcHandlerFunc, error) {
 		}
 
 		dlog.Debug("wrap wrapped call,in=%v,out=%v,func=%v", in, out, toWrap)
-		resp = Return(code, message, err, ret)
+		resp = returnCodec(cd, code, message, err, ret)
 		return
 	}
 
 	return wrapped, nil
 }
 
+// decodeReq unmarshals req to in by cd, code and resp are returned if it fails.
+func decodeReq(cd Codec, req []byte, in interface{}, handler interface{}) (code uint32, resp []byte, ok bool) {
+	decodeErr := cd.Unmarshal(req, in)
+	if decodeErr != nil {
+		dlog.Info("wrap wrap data from %s fail!bts=%s,func=%v,err=%v", cd.Name(), string(req), handler, decodeErr)
+		code = uint32(de.RpcInternalServerError)
+		resp = returnCodec(cd, code, "data type not valid", decodeErr, nil)
+		return code, resp, false
+	}
+	return 0, nil, true
+}
+
+// validReq validates in by validate tags, see utls/validate, code and resp are returned if it is not valid.
+func validReq(cd Codec, in interface{}, handler interface{}) (code uint32, resp []byte, ok bool) {
+	errs := validate.Struct(in)
+	if len(errs) == 0 {
+		return 0, nil, true
+	}
+
+	dlog.Info("wrap data not valid!func=%v,err=%v", handler, errs)
+	code = uint32(de.ParameterError)
+	var result interface{} = errs
+	if _, ok := cd.(protobufCodec); ok {
+		// field errors are not proto.Message, only code is carried
+		result = nil
+	}
+	resp = returnCodec(cd, code, errs.Error(), nil, result)
+	return code, resp, false
+}
+
 func Return(code uint32, message string, err error, result interface{}) (resp []byte) {
-	ret := make(map[string]interface{})
-	ret["code"] = code
-	ret["result"] = result
-	ret["message"] = message
+	return returnCodec(jsonCodec{}, code, message, err, result)
+}
+
+func returnCodec(cd Codec, code uint32, message string, err error, result interface{}) (resp []byte) {
+	ret := &envelope{
+		Code:    code,
+		Message: message,
+		Result:  result,
+	}
 
 	if err != nil {
-		ret["message"] = err.Error()
+		ret.Message = err.Error()
 	}
 
-	resp, _ = json.Marshal(ret)
+	resp, mErr := cd.Marshal(ret)
+	if mErr != nil {
+		dlog.Error("return marshal %s fail!ret=%v,err=%v", cd.Name(), ret, mErr)
+	}
 	return
 }
@@ -0,0 +1,114 @@
+/**
+ * Copyright 2019 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package dogrpc
+
+import (
+	"context"
+	"encoding/json"
+	de "github.com/gdp-org/gd/derror"
+	"testing"
+)
+
+type wrapTestReq struct {
+	Data string
+}
+
+type wrapTestResp struct {
+	Ret string
+}
+
+func TestWrapCtxHandler(t *testing.T) {
+	f, err := wrap(func(ctx context.Context, req *wrapTestReq) (code uint32, message string, err error, ret *wrapTestResp) {
+		return uint32(de.RpcSuccess), "ok", nil, &wrapTestResp{Ret: req.Data + ":" + TraceId(ctx)}
+	})
+	if err != nil {
+		t.Fatalf("wrap occur error:%s", err)
+	}
+
+	ctx := WithTraceId(context.Background(), "trace")
+	code, rsp := f(ctx, []byte(`{"Data":"hello"}`))
+	if code != uint32(de.RpcSuccess) {
+		t.Fatalf("unexpected code %d", code)
+	}
+
+	ret := struct {
+		Result wrapTestResp `json:"result"`
+	}{}
+	if err := json.Unmarshal(rsp, &ret); err != nil {
+		t.Fatalf("unmarshal rsp occur error:%s", err)
+	}
+	if ret.Result.Ret != "hello:trace" {
+		t.Fatalf("unexpected ret %s", ret.Result.Ret)
+	}
+}
+
+func TestWrapHandler(t *testing.T) {
+	f, err := wrap(func(req *wrapTestReq) (code uint32, message string, err error, ret *wrapTestResp) {
+		return uint32(de.RpcSuccess), "ok", nil, &wrapTestResp{Ret: req.Data}
+	})
+	if err != nil {
+		t.Fatalf("wrap occur error:%s", err)
+	}
+
+	if code, _ := f(context.Background(), []byte(`{"Data":"hello"}`)); code != uint32(de.RpcSuccess) {
+		t.Fatalf("unexpected code %d", code)
+	}
+}
+
+func TestWrapInvalidCtxHandler(t *testing.T) {
+	if _, err := wrap(func(ctx context.Context) (code uint32, message string, err error, ret *wrapTestResp) {
+		return
+	}); err == nil {
+		t.Fatal("expect error when ctx handler has no request param")
+	}
+}
+
+type wrapValidateReq struct {
+	Name string `json:"name" validate:"required,max=4"`
+}
+
+func TestWrapValidate(t *testing.T) {
+	f, err := wrap(func(req *wrapValidateReq) (code uint32, message string, err error, ret *wrapTestResp) {
+		return uint32(de.RpcSuccess), "ok", nil, &wrapTestResp{Ret: req.Name}
+	})
+	if err != nil {
+		t.Fatalf("wrap occur error:%s", err)
+	}
+
+	code, rsp := f(context.Background(), []byte(`{"name":"hello"}`))
+	if code != uint32(de.ParameterError) {
+		t.Fatalf("unexpected code %d", code)
+	}
+
+	ret := struct {
+		Message string `json:"message"`
+		Result  []struct {
+			Field string `json:"field"`
+			Rule  string `json:"rule"`
+		} `json:"result"`
+	}{}
+	if err := json.Unmarshal(rsp, &ret); err != nil {
+		t.Fatalf("unmarshal rsp occur error:%s", err)
+	}
+	if len(ret.Result) != 1 || ret.Result[0].Field != "name" || ret.Result[0].Rule != "max" || ret.Message == "" {
+		t.Fatalf("unexpected rsp %s", rsp)
+	}
+
+	if code, _ := f(context.Background(), nil); code != uint32(de.ParameterError) {
+		t.Fatalf("unexpected code %d of empty req", code)
+	}
+	if code, _ := f(context.Background(), []byte(`{"name":"ok"}`)); code != uint32(de.RpcSuccess) {
+		t.Fatalf("unexpected code %d", code)
+	}
+
+	if _, err := wrap(func(req *struct {
+		Name string `validate:"unknown"`
+	}) (code uint32, message string, err error, ret *wrapTestResp) {
+		return
+	}); err == nil {
+		t.Fatal("expect error when validate tag is illegal")
+	}
+}
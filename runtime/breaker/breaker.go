@@ -0,0 +1,231 @@
+/**
+ * Copyright 2020 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package breaker
+
+import (
+	"fmt"
+	"github.com/gdp-org/gd/dlog"
+	"github.com/gdp-org/gd/runtime/pc"
+	"sync"
+	"time"
+)
+
+/*
+ * circuit breaker. a breaker is closed at first, it opens when error rate or slow rate of
+ * requests in window exceeds the threshold, and rejects requests until OpenTimeout. then it
+ * becomes half open and lets HalfOpenRequests probes pass, it closes if all of them succeed,
+ * otherwise opens again.
+ */
+
+type State int32
+
+const (
+	StateClosed State = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half_open"
+	}
+	return "unknown"
+}
+
+const (
+	DefaultWindow           = 10 * time.Second
+	DefaultMinRequests      = 20
+	DefaultErrorRate        = 0.5
+	DefaultOpenTimeout      = 5 * time.Second
+	DefaultHalfOpenRequests = 1
+
+	// pc key of state transitions, incr 1 each time breaker of backend turns to state
+	PcBreakerState = "breaker,name=%s,backend=%s,state=%s"
+)
+
+type Config struct {
+	Window           time.Duration // requests are counted in window, default DefaultWindow
+	MinRequests      int           // breaker doesn't open if requests in window are less, default DefaultMinRequests
+	ErrorRate        float64       // open if failed / total reaches it, default DefaultErrorRate
+	SlowThreshold    time.Duration // request costs more is slow, 0 disables slow rate
+	SlowRate         float64       // open if slow / total reaches it, 0 disables
+	OpenTimeout      time.Duration // turns to half open after it, default DefaultOpenTimeout
+	HalfOpenRequests int           // probes in half open, default DefaultHalfOpenRequests
+}
+
+func (c Config) withDefault() Config {
+	if c.Window <= 0 {
+		c.Window = DefaultWindow
+	}
+	if c.MinRequests <= 0 {
+		c.MinRequests = DefaultMinRequests
+	}
+	if c.ErrorRate <= 0 {
+		c.ErrorRate = DefaultErrorRate
+	}
+	if c.OpenTimeout <= 0 {
+		c.OpenTimeout = DefaultOpenTimeout
+	}
+	if c.HalfOpenRequests <= 0 {
+		c.HalfOpenRequests = DefaultHalfOpenRequests
+	}
+	return c
+}
+
+// Breaker is the circuit breaker of one backend.
+type Breaker struct {
+	name    string
+	backend string
+	cfg     Config
+
+	lock        sync.Mutex
+	state       State
+	windowStart time.Time
+	total       int
+	failed      int
+	slow        int
+	openAt      time.Time
+	probes      int
+	succeeded   int
+}
+
+func NewBreaker(name, backend string, cfg Config) *Breaker {
+	return &Breaker{
+		name:        name,
+		backend:     backend,
+		cfg:         cfg.withDefault(),
+		windowStart: time.Now(),
+	}
+}
+
+// State returns current state, an open breaker turns to half open after OpenTimeout.
+func (b *Breaker) State() State {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.checkOpenTimeout(time.Now())
+	return b.state
+}
+
+// Allow reports whether a request can be sent to backend. Done must be called with
+// the result of request if it returns true.
+func (b *Breaker) Allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.checkOpenTimeout(time.Now())
+	switch b.state {
+	case StateOpen:
+		return false
+	case StateHalfOpen:
+		if b.probes >= b.cfg.HalfOpenRequests {
+			return false
+		}
+		b.probes++
+	}
+	return true
+}
+
+// Done records the result of request allowed before.
+func (b *Breaker) Done(success bool, cost time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := time.Now()
+	slow := b.cfg.SlowThreshold > 0 && cost >= b.cfg.SlowThreshold
+	switch b.state {
+	case StateClosed:
+		if now.Sub(b.windowStart) >= b.cfg.Window {
+			b.resetWindow(now)
+		}
+
+		b.total++
+		if !success {
+			b.failed++
+		}
+		if slow {
+			b.slow++
+		}
+
+		if b.total >= b.cfg.MinRequests && (float64(b.failed)/float64(b.total) >= b.cfg.ErrorRate ||
+			b.cfg.SlowRate > 0 && float64(b.slow)/float64(b.total) >= b.cfg.SlowRate) {
+			b.setState(StateOpen, now)
+		}
+	case StateHalfOpen:
+		if !success || slow {
+			b.setState(StateOpen, now)
+			return
+		}
+
+		b.succeeded++
+		if b.succeeded >= b.cfg.HalfOpenRequests {
+			b.setState(StateClosed, now)
+		}
+	}
+}
+
+func (b *Breaker) checkOpenTimeout(now time.Time) {
+	if b.state == StateOpen && now.Sub(b.openAt) >= b.cfg.OpenTimeout {
+		b.setState(StateHalfOpen, now)
+	}
+}
+
+func (b *Breaker) resetWindow(now time.Time) {
+	b.windowStart = now
+	b.total = 0
+	b.failed = 0
+	b.slow = 0
+}
+
+// setState must be called with lock held.
+func (b *Breaker) setState(s State, now time.Time) {
+	switch s {
+	case StateOpen:
+		b.openAt = now
+		dlog.Warn("breaker %s backend %s open, total %d, failed %d, slow %d", b.name, b.backend, b.total, b.failed, b.slow)
+	case StateHalfOpen:
+		b.probes = 0
+		b.succeeded = 0
+		dlog.Info("breaker %s backend %s half open", b.name, b.backend)
+	case StateClosed:
+		dlog.Info("breaker %s backend %s closed", b.name, b.backend)
+	}
+
+	b.state = s
+	b.resetWindow(now)
+	pc.Incr(fmt.Sprintf(PcBreakerState, b.name, b.backend, s), 1)
+}
+
+// Group holds breakers of backends, which are created on first use.
+type Group struct {
+	Name   string
+	Config Config
+
+	breakers sync.Map
+}
+
+func NewGroup(name string, cfg Config) *Group {
+	return &Group{Name: name, Config: cfg}
+}
+
+// Get returns the breaker of backend.
+func (g *Group) Get(backend string) *Breaker {
+	if b, ok := g.breakers.Load(backend); ok {
+		return b.(*Breaker)
+	}
+
+	b, _ := g.breakers.LoadOrStore(backend, NewBreaker(g.Name, backend, g.Config))
+	return b.(*Breaker)
+}
+
+// Remove drops the breaker of backend, e.g. backend is removed from discovery.
+func (g *Group) Remove(backend string) {
+	g.breakers.Delete(backend)
+}
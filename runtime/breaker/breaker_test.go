@@ -0,0 +1,80 @@
+/**
+ * Copyright 2020 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package breaker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	b := NewBreaker("test", "127.0.0.1:10240", Config{
+		MinRequests: 4,
+		ErrorRate:   0.5,
+		OpenTimeout: 50 * time.Millisecond,
+	})
+
+	for i := 0; i < 4; i++ {
+		if !b.Allow() {
+			t.Fatalf("request %d is not allowed when closed", i)
+		}
+		if i%2 == 0 {
+			b.Done(false, 0)
+		} else {
+			b.Done(true, 0)
+		}
+	}
+
+	if b.State() != StateOpen || b.Allow() {
+		t.Fatalf("expect open, got %s", b.State())
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if b.State() != StateHalfOpen {
+		t.Fatalf("expect half open, got %s", b.State())
+	}
+	if !b.Allow() || b.Allow() {
+		t.Fatal("expect only one probe in half open")
+	}
+
+	// failed probe opens again
+	b.Done(false, 0)
+	if b.State() != StateOpen {
+		t.Fatalf("expect open, got %s", b.State())
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatal("probe is not allowed")
+	}
+	b.Done(true, 0)
+	if b.State() != StateClosed {
+		t.Fatalf("expect closed, got %s", b.State())
+	}
+}
+
+func TestBreakerSlow(t *testing.T) {
+	b := NewBreaker("test", "127.0.0.1:10240", Config{
+		MinRequests:   2,
+		SlowThreshold: 100 * time.Millisecond,
+		SlowRate:      0.5,
+	})
+
+	b.Allow()
+	b.Done(true, 10*time.Millisecond)
+	b.Allow()
+	b.Done(true, 200*time.Millisecond)
+	if b.State() != StateOpen {
+		t.Fatalf("expect open, got %s", b.State())
+	}
+}
+
+func TestGroup(t *testing.T) {
+	g := NewGroup("test", Config{})
+	if g.Get("a") != g.Get("a") || g.Get("a") == g.Get("b") {
+		t.Fatal("unexpected breaker of backend")
+	}
+}
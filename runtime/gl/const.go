@@ -16,4 +16,7 @@ const (
 	SecretKey  = "glSecretKey"
 	GdTokenRaw = "gdTokenRaw"
 	GdToken    = "gdToken"
+
+	AuthSubject = "glAuthSubject"
+	AuthClaims  = "glAuthClaims"
 )
@@ -0,0 +1,193 @@
+/**
+ * Copyright 2020 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package limiter
+
+import (
+	"fmt"
+	"github.com/gdp-org/gd/dlog"
+	"github.com/gdp-org/gd/runtime/pc"
+	"gopkg.in/ini.v1"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ * per key limiter, key is dogrpc cmd, http route or grpc method. each key has a token bucket
+ * of Rate requests per second with Burst, and MaxInFlight requests being handled at most.
+ * keys without rule are not limited.
+ *
+ * rules are configured in a section of conf.ini, e.g.
+ *
+ * [RpcLimit]
+ * 1024 = rate=100,burst=200,inflight=50
+ *
+ * [HttpLimit]
+ * /test = rate=1000,inflight=100
+ */
+
+const (
+	// pc key of rejected requests
+	PcLimiterReject = "limiter,name=%s,key=%s,reason=%s"
+
+	reasonRate     = "rate"
+	reasonInFlight = "inflight"
+)
+
+type Rule struct {
+	Rate        float64 // requests per second, 0 is unlimited
+	Burst       int     // bucket size, default max(1, Rate)
+	MaxInFlight int     // 0 is unlimited
+}
+
+// ParseRule parses rule like "rate=100,burst=200,inflight=50", omitted fields are unlimited.
+func ParseRule(s string) (Rule, error) {
+	var r Rule
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return r, fmt.Errorf("limiter rule field %s illegal", field)
+		}
+
+		var err error
+		switch strings.TrimSpace(kv[0]) {
+		case "rate":
+			r.Rate, err = strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		case "burst":
+			r.Burst, err = strconv.Atoi(strings.TrimSpace(kv[1]))
+		case "inflight":
+			r.MaxInFlight, err = strconv.Atoi(strings.TrimSpace(kv[1]))
+		default:
+			err = fmt.Errorf("limiter rule field %s unknown", kv[0])
+		}
+		if err != nil {
+			return r, err
+		}
+	}
+	return r, nil
+}
+
+type bucket struct {
+	rule Rule
+
+	lock   sync.Mutex
+	tokens float64
+	last   time.Time
+
+	inFlight int32
+}
+
+func newBucket(r Rule) *bucket {
+	if r.Rate > 0 && r.Burst <= 0 {
+		r.Burst = int(r.Rate)
+		if r.Burst < 1 {
+			r.Burst = 1
+		}
+	}
+	return &bucket{rule: r, tokens: float64(r.Burst), last: time.Now()}
+}
+
+func (b *bucket) take() bool {
+	if b.rule.Rate <= 0 {
+		return true
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rule.Rate
+	if b.tokens > float64(b.rule.Burst) {
+		b.tokens = float64(b.rule.Burst)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+type Limiter struct {
+	name    string
+	lock    sync.RWMutex
+	buckets map[string]*bucket
+}
+
+func New(name string) *Limiter {
+	return &Limiter{name: name, buckets: make(map[string]*bucket)}
+}
+
+// NewFromSection creates limiter with rules in sec, key of sec is the key of limiter.
+func NewFromSection(name string, sec *ini.Section) (*Limiter, error) {
+	l := New(name)
+	for _, k := range sec.Keys() {
+		r, err := ParseRule(k.String())
+		if err != nil {
+			return nil, fmt.Errorf("limiter %s key %s: %v", name, k.Name(), err)
+		}
+		l.SetRule(k.Name(), r)
+	}
+	return l, nil
+}
+
+// SetRule sets rule of key, requests being handled are not counted by the new rule.
+func (l *Limiter) SetRule(key string, r Rule) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.buckets[key] = newBucket(r)
+}
+
+// Acquire reports whether a request of key can be handled. release must be called after
+// the request is handled if it returns true.
+func (l *Limiter) Acquire(key string) (release func(), ok bool) {
+	l.lock.RLock()
+	b := l.buckets[key]
+	l.lock.RUnlock()
+	if b == nil {
+		return func() {}, true
+	}
+
+	if b.rule.MaxInFlight > 0 {
+		if atomic.AddInt32(&b.inFlight, 1) > int32(b.rule.MaxInFlight) {
+			atomic.AddInt32(&b.inFlight, -1)
+			l.reject(key, reasonInFlight)
+			return nil, false
+		}
+	}
+
+	if !b.take() {
+		if b.rule.MaxInFlight > 0 {
+			atomic.AddInt32(&b.inFlight, -1)
+		}
+		l.reject(key, reasonRate)
+		return nil, false
+	}
+
+	if b.rule.MaxInFlight <= 0 {
+		return func() {}, true
+	}
+
+	var once int32
+	return func() {
+		if atomic.CompareAndSwapInt32(&once, 0, 1) {
+			atomic.AddInt32(&b.inFlight, -1)
+		}
+	}, true
+}
+
+func (l *Limiter) reject(key, reason string) {
+	dlog.Debug("limiter %s reject key %s by %s", l.name, key, reason)
+	pc.Incr(fmt.Sprintf(PcLimiterReject, l.name, key, reason), 1)
+}
@@ -0,0 +1,84 @@
+/**
+ * Copyright 2020 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package limiter
+
+import (
+	"gopkg.in/ini.v1"
+	"testing"
+)
+
+func TestParseRule(t *testing.T) {
+	r, err := ParseRule("rate=100, burst=200,inflight=50")
+	if err != nil {
+		t.Fatalf("parse rule occur error:%s", err)
+	}
+	if r.Rate != 100 || r.Burst != 200 || r.MaxInFlight != 50 {
+		t.Fatalf("unexpected rule %+v", r)
+	}
+
+	if _, err := ParseRule("qps=100"); err == nil {
+		t.Fatal("expect error of unknown field")
+	}
+}
+
+func TestLimiterRate(t *testing.T) {
+	l := New("test")
+	l.SetRule("1024", Rule{Rate: 1, Burst: 2})
+
+	for i := 0; i < 2; i++ {
+		if _, ok := l.Acquire("1024"); !ok {
+			t.Fatalf("request %d is rejected", i)
+		}
+	}
+	if _, ok := l.Acquire("1024"); ok {
+		t.Fatal("expect rejected by rate")
+	}
+
+	// keys without rule are not limited
+	if _, ok := l.Acquire("1025"); !ok {
+		t.Fatal("key without rule is rejected")
+	}
+}
+
+func TestLimiterInFlight(t *testing.T) {
+	l := New("test")
+	l.SetRule("/test", Rule{MaxInFlight: 1})
+
+	release, ok := l.Acquire("/test")
+	if !ok {
+		t.Fatal("first request is rejected")
+	}
+	if _, ok := l.Acquire("/test"); ok {
+		t.Fatal("expect rejected by inflight")
+	}
+
+	release()
+	release()
+	if _, ok := l.Acquire("/test"); !ok {
+		t.Fatal("request is rejected after release")
+	}
+	if _, ok := l.Acquire("/test"); ok {
+		t.Fatal("release twice must not free two slots")
+	}
+}
+
+func TestNewFromSection(t *testing.T) {
+	f, err := ini.Load([]byte("[HttpLimit]\n/test = rate=1,burst=1\n"))
+	if err != nil {
+		t.Fatalf("load ini occur error:%s", err)
+	}
+
+	l, err := NewFromSection("http", f.Section("HttpLimit"))
+	if err != nil {
+		t.Fatalf("new limiter occur error:%s", err)
+	}
+	if _, ok := l.Acquire("/test"); !ok {
+		t.Fatal("first request is rejected")
+	}
+	if _, ok := l.Acquire("/test"); ok {
+		t.Fatal("expect rejected by rate")
+	}
+}
@@ -1,8 +1,8 @@
 package main
 
 import (
-	"fmt"
 	"github.com/gdp-org/gd"
+	"github.com/gdp-org/gd/net/dogrpc"
 	"github.com/gdp-org/gd/service/discovery"
 	"time"
 )
@@ -30,13 +30,9 @@ func main() {
 		gd.Debug("%s:%d", v.GetIp(), v.GetPort())
 	}
 
-	// you can choose one or use load balance algorithm to choose best one.
-	// or put all to c.Addr
-	for _, v := range hosts {
-		if !v.GetOffline() {
-			c.AddAddr(fmt.Sprintf("%s:%d", v.GetIp(), v.GetPort()))
-		}
-	}
+	// nodes follow discovery, offline nodes are skipped and weight is used by balancer.
+	c.SetBalancer(dogrpc.NewRoundRobinBalancer()).SetDiscovery(r, "test")
+	defer c.Stop()
 
 	body := &struct {
 		Data string
@@ -17,6 +17,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"google.golang.org/grpc"
 	"net/http"
+	"time"
 )
 
 type TestReq struct {
@@ -92,6 +93,11 @@ func Register(e *gd.Engine) {
 		return
 	}
 	dogrpc.Use([]dogrpc.Filter{&dogrpc.GlFilter{}, &dogrpc.LogFilter{}})
+	e.RpcServer.UseCmd(1024, func(ctx *dogrpc.Context, next dogrpc.ServerHandler) (uint32, []byte) {
+		code, rsp := next(ctx)
+		gd.Debug("cmd %d seq %d from %s cost %v", ctx.Cmd, ctx.Seq, ctx.ClientAddr, time.Since(ctx.StartTime))
+		return code, rsp
+	})
 
 	// grpc
 	inject.RegisterOrFail("registerHandler", &reg{handler: &server{}})
@@ -0,0 +1,366 @@
+/**
+ * Copyright 2019 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package validate
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+/*
+ * declarative validation of struct fields by validate tag, rules are separated by comma:
+ *
+ * required      value is not zero, e.g. non-empty string, non-nil pointer, non-empty slice
+ * min=N, max=N  bound of number, or length of string, slice and map
+ * enum=a|b|c    value is one of a, b and c
+ * regex=EXPR    string matches EXPR, it must be the last rule since EXPR may contain comma
+ *
+ * e.g. `json:"name" validate:"required,max=32,regex=^[a-z]+$"`
+ *
+ * rules except required are skipped for nil pointers, so optional fields should be pointers.
+ * fields of nested structs, and elements of slices and maps which are structs, are validated
+ * too. field names in errors are json tag names, or form tag names, e.g. items[0].name.
+ */
+
+const Tag = "validate"
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, fe := range e {
+		msgs = append(msgs, fe.Message)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+type rule struct {
+	name  string
+	param string
+	num   float64
+	enum  []string
+	regex *regexp.Regexp
+}
+
+type field struct {
+	index int
+	name  string
+	rules []rule
+}
+
+var structCache sync.Map // reflect.Type -> []field
+
+// Check returns error if validate tags of t, or of types nested in t, are illegal.
+func Check(t reflect.Type) error {
+	return check(t, make(map[reflect.Type]bool))
+}
+
+func check(t reflect.Type, seen map[reflect.Type]bool) error {
+	t = elemType(t)
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return check(t.Elem(), seen)
+	}
+	if t.Kind() != reflect.Struct || seen[t] {
+		return nil
+	}
+	seen[t] = true
+
+	fields, err := parseStruct(t)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if err := check(t.Field(f.index).Type, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Struct validates v, which is a struct or pointer to struct, nil pointer is validated as zero value.
+func Struct(v interface{}) Errors {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return nil
+	}
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			rv = reflect.Zero(rv.Type().Elem())
+			continue
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var errs Errors
+	validateStruct(rv, "", &errs)
+	return errs
+}
+
+func validateStruct(v reflect.Value, prefix string, errs *Errors) {
+	fields, err := parseStruct(v.Type())
+	if err != nil {
+		// tags are checked when handlers are wrapped
+		return
+	}
+
+	for _, f := range fields {
+		name := f.name
+		if prefix != "" {
+			name = prefix + "." + f.name
+		}
+		validateValue(v.Field(f.index), name, f.rules, errs)
+	}
+}
+
+func validateValue(v reflect.Value, name string, rules []rule, errs *Errors) {
+	for _, r := range rules {
+		if r.name == "required" {
+			if isZero(v) {
+				*errs = append(*errs, FieldError{Field: name, Rule: r.name, Message: name + " is required"})
+				return
+			}
+			continue
+		}
+
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return
+		}
+		if msg := r.apply(reflect.Indirect(v)); msg != "" {
+			*errs = append(*errs, FieldError{Field: name, Rule: r.name, Message: name + " " + msg})
+		}
+	}
+
+	nested(v, name, errs)
+}
+
+// nested validates fields of struct v, or of struct elements of v.
+func nested(v reflect.Value, name string, errs *Errors) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		validateStruct(v, name, errs)
+	case reflect.Slice, reflect.Array:
+		if elemType(v.Type().Elem()).Kind() != reflect.Struct {
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			nested(v.Index(i), name+"["+strconv.Itoa(i)+"]", errs)
+		}
+	case reflect.Map:
+		if elemType(v.Type().Elem()).Kind() != reflect.Struct {
+			return
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			nested(iter.Value(), fmt.Sprintf("%s[%v]", name, iter.Key().Interface()), errs)
+		}
+	}
+}
+
+// apply returns the violation of v, "" if there is none.
+func (r rule) apply(v reflect.Value) string {
+	switch r.name {
+	case "min", "max":
+		n, isLen := number(v)
+		if r.name == "min" && n < r.num {
+			if isLen {
+				return "length must be at least " + r.param
+			}
+			return "must be at least " + r.param
+		}
+		if r.name == "max" && n > r.num {
+			if isLen {
+				return "length must be at most " + r.param
+			}
+			return "must be at most " + r.param
+		}
+	case "enum":
+		s := fmt.Sprint(v.Interface())
+		for _, e := range r.enum {
+			if s == e {
+				return ""
+			}
+		}
+		return "must be one of " + strings.Join(r.enum, ", ")
+	case "regex":
+		if !r.regex.MatchString(v.String()) {
+			return "must match " + r.param
+		}
+	}
+	return ""
+}
+
+// number returns value of number v, or length of others.
+func number(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), false
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), false
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), false
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), true
+	}
+	return float64(v.Len()), true
+}
+
+func isZero(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+func parseStruct(t reflect.Type) ([]field, error) {
+	if fields, ok := structCache.Load(t); ok {
+		return fields.([]field), nil
+	}
+
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		rules, err := parseRules(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %s", t.Name(), f.Name, err)
+		}
+		if len(rules) == 0 && !hasStruct(f.Type) {
+			continue
+		}
+		fields = append(fields, field{index: i, name: fieldName(f), rules: rules})
+	}
+
+	structCache.Store(t, fields)
+	return fields, nil
+}
+
+func parseRules(f reflect.StructField) ([]rule, error) {
+	tag := f.Tag.Get(Tag)
+	if tag == "" || tag == "-" {
+		return nil, nil
+	}
+
+	ft := elemType(f.Type)
+	var rules []rule
+	for tag != "" {
+		var s string
+		if strings.HasPrefix(tag, "regex=") {
+			s, tag = tag, ""
+		} else if i := strings.Index(tag, ","); i >= 0 {
+			s, tag = tag[:i], tag[i+1:]
+		} else {
+			s, tag = tag, ""
+		}
+
+		r := rule{name: s}
+		if i := strings.Index(s, "="); i >= 0 {
+			r.name, r.param = s[:i], s[i+1:]
+		}
+
+		switch r.name {
+		case "required":
+		case "min", "max":
+			n, err := strconv.ParseFloat(r.param, 64)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s param %s is not number", r.name, r.param)
+			}
+			if !isNumber(ft) && !hasLen(ft) {
+				return nil, fmt.Errorf("rule %s not support %s", r.name, ft)
+			}
+			r.num = n
+		case "enum":
+			if r.param == "" {
+				return nil, fmt.Errorf("rule enum has no value")
+			}
+			r.enum = strings.Split(r.param, "|")
+		case "regex":
+			if ft.Kind() != reflect.String {
+				return nil, fmt.Errorf("rule regex not support %s", ft)
+			}
+			re, err := regexp.Compile(r.param)
+			if err != nil {
+				return nil, fmt.Errorf("rule regex %s", err)
+			}
+			r.regex = re
+		default:
+			return nil, fmt.Errorf("rule %s unknown", r.name)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func fieldName(f reflect.StructField) string {
+	for _, tag := range []string{"json", "form"} {
+		if name := strings.Split(f.Tag.Get(tag), ",")[0]; name != "" && name != "-" {
+			return name
+		}
+	}
+	return f.Name
+}
+
+func elemType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// hasStruct reports whether t is struct, or slice, array and map of struct.
+func hasStruct(t reflect.Type) bool {
+	t = elemType(t)
+	switch t.Kind() {
+	case reflect.Struct:
+		return true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return elemType(t.Elem()).Kind() == reflect.Struct
+	}
+	return false
+}
+
+func isNumber(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func hasLen(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map, reflect.Chan:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+/**
+ * Copyright 2019 gd Author. All rights reserved.
+ * Author: Chuck1024
+ */
+
+package validate
+
+import (
+	"reflect"
+	"testing"
+)
+
+type testItem struct {
+	Name  string `json:"name" validate:"required"`
+	Count int    `json:"count" validate:"min=1,max=10"`
+}
+
+type testReq struct {
+	Id     string              `json:"id" validate:"required,regex=^[a-z]{2,4}$"`
+	Kind   string              `json:"kind" validate:"enum=a|b"`
+	Tags   []string            `json:"tags" validate:"max=2"`
+	Age    *int                `json:"age" validate:"min=18"`
+	Item   *testItem           `json:"item" validate:"required"`
+	Items  []testItem          `json:"items"`
+	ByName map[string]testItem `json:"byName"`
+	skip   string              `validate:"required"`
+}
+
+func TestStruct(t *testing.T) {
+	age := 3
+	req := &testReq{
+		Id:     "abcdef",
+		Kind:   "c",
+		Tags:   []string{"x", "y", "z"},
+		Age:    &age,
+		Items:  []testItem{{Name: "n", Count: 1}, {Count: 11}},
+		ByName: map[string]testItem{"k": {Name: "n"}},
+	}
+
+	errs := Struct(req)
+	expect := map[string]string{
+		"id":              "regex",
+		"kind":            "enum",
+		"tags":            "max",
+		"age":             "min",
+		"item":            "required",
+		"items[1].name":   "required",
+		"items[1].count":  "max",
+		"byName[k].count": "min",
+	}
+	if len(errs) != len(expect) {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	for _, e := range errs {
+		if expect[e.Field] != e.Rule {
+			t.Fatalf("unexpected error %+v", e)
+		}
+	}
+
+	ok := &testReq{Id: "ab", Kind: "a", Item: &testItem{Name: "n", Count: 10}}
+	if errs := Struct(ok); len(errs) != 0 {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+}
+
+func TestStructNil(t *testing.T) {
+	var req *testReq
+	errs := Struct(req)
+	if len(errs) != 3 || errs[0].Field != "id" || errs[1].Field != "kind" || errs[2].Field != "item" {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	if errs.Error() != "id is required; kind must be one of a, b; item is required" {
+		t.Fatalf("unexpected message %s", errs.Error())
+	}
+}
+
+func TestCheck(t *testing.T) {
+	if err := Check(reflect.TypeOf(&testReq{})); err != nil {
+		t.Fatalf("check occur error:%s", err)
+	}
+
+	illegal := []interface{}{
+		struct {
+			A string `validate:"unknown"`
+		}{},
+		struct {
+			A string `validate:"min=x"`
+		}{},
+		struct {
+			A int `validate:"regex=^a$"`
+		}{},
+		struct {
+			A []struct {
+				B bool `validate:"max=1"`
+			}
+		}{},
+	}
+	for _, v := range illegal {
+		if err := Check(reflect.TypeOf(v)); err == nil {
+			t.Fatalf("expect error of %T", v)
+		}
+	}
+}